@@ -0,0 +1,347 @@
+// Package alarm schedules recurring alarms.  Rules are either a time of day on some weekdays, or a
+// cron expression, and are stored in a JSON file so that they survive restarts.  The Scheduler is
+// driven by the clock's once-per-second tick, so alarms start ringing exactly on the second.
+package alarm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var alarmEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "alarm_events",
+	Help: "count of alarm events, by type",
+}, []string{"type"})
+
+const (
+	// DefaultSnooze is how long an alarm is snoozed for when the caller doesn't say.
+	DefaultSnooze = 9 * time.Minute
+	// ringDuration is how long an alarm rings before giving up on its own.
+	ringDuration = 10 * time.Minute
+)
+
+// Rule is a recurring alarm.  Exactly one of Time or Cron must be set.
+type Rule struct {
+	ID    string `json:"id"`
+	Label string `json:"label,omitempty"` // Shown on the display while ringing; "ALARM" if empty.
+	// Time is the time of day, "HH:MM" or "HH:MM:SS", that the alarm rings on Days.
+	Time string `json:"time,omitempty"`
+	// Days are the weekdays ("mon", "tue", ...) that a Time rule rings on; empty means every day.
+	Days []string `json:"days,omitempty"`
+	// Cron is a five-field cron expression ("30 7 * * mon-fri"); the alarm rings at second zero.
+	Cron     string `json:"cron,omitempty"`
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+func (r Rule) compile() (*schedule, error) {
+	switch {
+	case r.ID == "":
+		return nil, errors.New("rule has no id")
+	case r.Time != "" && r.Cron != "":
+		return nil, fmt.Errorf("rule %q: only one of time or cron may be set", r.ID)
+	case r.Cron != "":
+		if len(r.Days) > 0 {
+			return nil, fmt.Errorf("rule %q: days can't be used with cron; use the day of week field", r.ID)
+		}
+		s, err := parseCron(r.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", r.ID, err)
+		}
+		return s, nil
+	case r.Time != "":
+		s, err := parseDaily(r.Time, r.Days)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", r.ID, err)
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("rule %q: one of time or cron must be set", r.ID)
+}
+
+// Validate returns an error if the rule can't be scheduled.
+func (r Rule) Validate() error {
+	_, err := r.compile()
+	return err
+}
+
+// DisplayLabel returns what the display shows while the rule's alarm is ringing.
+func (r Rule) DisplayLabel() string {
+	if r.Label == "" {
+		return "ALARM"
+	}
+	return r.Label
+}
+
+// EventType says what happened to an alarm.
+type EventType string
+
+const (
+	Ring    EventType = "ring"    // The alarm started ringing, on schedule or after a snooze.
+	Snooze  EventType = "snooze"  // The alarm was snoozed.
+	Dismiss EventType = "dismiss" // The alarm was dismissed.
+	Timeout EventType = "timeout" // Nobody dismissed the alarm, so it stopped on its own.
+)
+
+// Event is sent to the event listener when an alarm changes state.
+type Event struct {
+	Type  EventType `json:"type"`
+	Rule  Rule      `json:"rule"`
+	Time  time.Time `json:"time"`
+	Until time.Time `json:"until,omitempty"` // Snooze: when the alarm will ring again.
+}
+
+// Status is what the Scheduler is doing right now.
+type Status struct {
+	Ringing      *Rule     `json:"ringing,omitempty"`
+	RingingSince time.Time `json:"ringing_since,omitempty"`
+	Snoozed      *Rule     `json:"snoozed,omitempty"`
+	SnoozedUntil time.Time `json:"snoozed_until,omitempty"`
+}
+
+type rule struct {
+	Rule
+	schedule *schedule
+}
+
+// Scheduler holds the alarm rules and decides, on every tick, whether an alarm is ringing.
+type Scheduler struct {
+	path string
+
+	mu           sync.Mutex
+	rules        []rule      // sorted by ID; must hold mu.
+	ringing      *Rule       // must hold mu.
+	ringingSince time.Time   // must hold mu.
+	snoozed      *Rule       // must hold mu.
+	snoozedUntil time.Time   // must hold mu.
+	onEvent      func(Event) // must hold mu.
+}
+
+// Open loads the rules stored at path.  A missing file means that there are no alarms yet; it's
+// created when a rule is added.
+func Open(path string) (*Scheduler, error) {
+	s := &Scheduler{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read alarms: %w", err)
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: decode alarms: %w", path, err)
+	}
+	for _, r := range rules {
+		if err := s.put(r); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return s, nil
+}
+
+// SetEventListener arranges for f to be called whenever an alarm changes state.  f is called from
+// Tick, Snooze or Dismiss, and must not block.  Passing nil removes the listener.
+func (s *Scheduler) SetEventListener(f func(Event)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onEvent = f
+}
+
+// Rules returns the alarm rules, sorted by ID.
+func (s *Scheduler) Rules() []Rule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]Rule, 0, len(s.rules))
+	for _, r := range s.rules {
+		result = append(result, r.Rule)
+	}
+	return result
+}
+
+// Status returns which alarm, if any, is ringing or snoozed.
+func (s *Scheduler) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Status{
+		Ringing:      s.ringing,
+		RingingSince: s.ringingSince,
+		Snoozed:      s.snoozed,
+		SnoozedUntil: s.snoozedUntil,
+	}
+}
+
+// put adds or replaces a rule; must hold mu, or be called before anything else can see s.
+func (s *Scheduler) put(r Rule) error {
+	sched, err := r.compile()
+	if err != nil {
+		return err
+	}
+	i := sort.Search(len(s.rules), func(i int) bool { return s.rules[i].ID >= r.ID })
+	if i < len(s.rules) && s.rules[i].ID == r.ID {
+		s.rules[i] = rule{r, sched}
+		return nil
+	}
+	s.rules = append(s.rules, rule{})
+	copy(s.rules[i+1:], s.rules[i:])
+	s.rules[i] = rule{r, sched}
+	return nil
+}
+
+// Put adds a rule, or replaces the rule with the same ID, and saves the rules.
+func (s *Scheduler) Put(r Rule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := append([]rule(nil), s.rules...)
+	if err := s.put(r); err != nil {
+		return err
+	}
+	if err := s.save(); err != nil {
+		s.rules = old
+		return err
+	}
+	return nil
+}
+
+// ErrNoSuchRule is returned when deleting a rule that doesn't exist.
+var ErrNoSuchRule = errors.New("no such rule")
+
+// Delete removes the rule with the given ID and saves the rules.
+func (s *Scheduler) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := sort.Search(len(s.rules), func(i int) bool { return s.rules[i].ID >= id })
+	if i == len(s.rules) || s.rules[i].ID != id {
+		return fmt.Errorf("delete %q: %w", id, ErrNoSuchRule)
+	}
+	old := append([]rule(nil), s.rules...)
+	s.rules = append(s.rules[:i], s.rules[i+1:]...)
+	if err := s.save(); err != nil {
+		s.rules = old
+		return err
+	}
+	return nil
+}
+
+// save writes the rules to disk, replacing the old file atomically; must hold mu.
+func (s *Scheduler) save() error {
+	rules := make([]Rule, 0, len(s.rules))
+	for _, r := range s.rules {
+		rules = append(rules, r.Rule)
+	}
+	data, err := json.MarshalIndent(rules, "", "    ")
+	if err != nil {
+		return fmt.Errorf("encode alarms: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".alarms-*.json")
+	if err != nil {
+		return fmt.Errorf("save alarms: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("save alarms: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("save alarms: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("save alarms: %w", err)
+	}
+	return nil
+}
+
+// Tick is called once per second with the tick time, in the time zone that alarms are scheduled
+// in.  It returns the label to display if an alarm is ringing.
+func (s *Scheduler) Tick(t time.Time) (label string, ringing bool) {
+	s.mu.Lock()
+	var events []Event
+	if s.ringing != nil && t.Sub(s.ringingSince) >= ringDuration {
+		events = append(events, Event{Type: Timeout, Rule: *s.ringing, Time: t})
+		s.ringing = nil
+	}
+	if s.snoozed != nil && !t.Before(s.snoozedUntil) {
+		r := *s.snoozed
+		s.ring(r, t)
+		events = append(events, Event{Type: Ring, Rule: r, Time: t})
+	}
+	for _, r := range s.rules {
+		if !r.Disabled && r.schedule.matches(t) {
+			s.ring(r.Rule, t)
+			events = append(events, Event{Type: Ring, Rule: r.Rule, Time: t})
+		}
+	}
+	if s.ringing != nil {
+		label, ringing = s.ringing.DisplayLabel(), true
+	}
+	onEvent := s.onEvent
+	s.mu.Unlock()
+	notify(onEvent, events)
+	return label, ringing
+}
+
+// ring starts r ringing, replacing any ringing or snoozed alarm; must hold mu.
+func (s *Scheduler) ring(r Rule, t time.Time) {
+	s.ringing, s.ringingSince = &r, t
+	s.snoozed, s.snoozedUntil = nil, time.Time{}
+}
+
+// ErrNotRinging is returned when snoozing or dismissing while no alarm is ringing.
+var ErrNotRinging = errors.New("no alarm is ringing")
+
+// Snooze silences the ringing alarm until now+d.
+func (s *Scheduler) Snooze(now time.Time, d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("snooze duration %v must be positive", d)
+	}
+	s.mu.Lock()
+	if s.ringing == nil {
+		s.mu.Unlock()
+		return ErrNotRinging
+	}
+	until := now.Add(d).Truncate(time.Second)
+	ev := Event{Type: Snooze, Rule: *s.ringing, Time: now, Until: until}
+	s.snoozed, s.snoozedUntil = s.ringing, until
+	s.ringing, s.ringingSince = nil, time.Time{}
+	onEvent := s.onEvent
+	s.mu.Unlock()
+	notify(onEvent, []Event{ev})
+	return nil
+}
+
+// Dismiss stops the ringing or snoozed alarm until its rule next matches.
+func (s *Scheduler) Dismiss(now time.Time) error {
+	s.mu.Lock()
+	r := s.ringing
+	if r == nil {
+		r = s.snoozed
+	}
+	if r == nil {
+		s.mu.Unlock()
+		return ErrNotRinging
+	}
+	ev := Event{Type: Dismiss, Rule: *r, Time: now}
+	s.ringing, s.ringingSince = nil, time.Time{}
+	s.snoozed, s.snoozedUntil = nil, time.Time{}
+	onEvent := s.onEvent
+	s.mu.Unlock()
+	notify(onEvent, []Event{ev})
+	return nil
+}
+
+func notify(f func(Event), events []Event) {
+	for _, ev := range events {
+		alarmEvents.WithLabelValues(string(ev.Type)).Inc()
+		if f != nil {
+			f(ev)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package alarm
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 2021-10-04 is a Monday.
+func at(day, hour, min, sec int) time.Time {
+	return time.Date(2021, 10, day, hour, min, sec, 0, time.UTC)
+}
+
+func TestRuleMatches(t *testing.T) {
+	testData := []struct {
+		name  string
+		rule  Rule
+		yes   []time.Time
+		no    []time.Time
+		error string
+	}{
+		{
+			name: "every day",
+			rule: Rule{ID: "a", Time: "07:30"},
+			yes:  []time.Time{at(4, 7, 30, 0), at(10, 7, 30, 0)},
+			no:   []time.Time{at(4, 7, 30, 1), at(4, 7, 31, 0), at(4, 19, 30, 0)},
+		},
+		{
+			name: "weekdays with seconds",
+			rule: Rule{ID: "a", Time: "06:00:30", Days: []string{"mon", "Fri"}},
+			yes:  []time.Time{at(4, 6, 0, 30), at(8, 6, 0, 30)},
+			no:   []time.Time{at(5, 6, 0, 30), at(4, 6, 0, 0)},
+		},
+		{
+			name: "cron weekdays",
+			rule: Rule{ID: "a", Cron: "30 7 * * mon-fri"},
+			yes:  []time.Time{at(4, 7, 30, 0), at(8, 7, 30, 0)},
+			no:   []time.Time{at(9, 7, 30, 0), at(10, 7, 30, 0), at(4, 7, 30, 1)},
+		},
+		{
+			name: "cron steps and lists",
+			rule: Rule{ID: "a", Cron: "*/15 9-17/4,20 * oct *"},
+			yes:  []time.Time{at(4, 9, 0, 0), at(4, 13, 45, 0), at(4, 17, 15, 0), at(4, 20, 30, 0)},
+			no:   []time.Time{at(4, 10, 0, 0), at(4, 9, 10, 0), time.Date(2021, 11, 1, 9, 0, 0, 0, time.UTC)},
+		},
+		{
+			name: "cron sunday is 0 or 7",
+			rule: Rule{ID: "a", Cron: "0 12 * * 7"},
+			yes:  []time.Time{at(10, 12, 0, 0)},
+			no:   []time.Time{at(9, 12, 0, 0)},
+		},
+		{
+			name: "cron day of month or day of week",
+			rule: Rule{ID: "a", Cron: "0 8 1 * mon"},
+			yes:  []time.Time{at(1, 8, 0, 0), at(4, 8, 0, 0)},
+			no:   []time.Time{at(5, 8, 0, 0)},
+		},
+		{name: "no schedule", rule: Rule{ID: "a"}, error: "one of time or cron"},
+		{name: "both", rule: Rule{ID: "a", Time: "07:00", Cron: "* * * * *"}, error: "only one"},
+		{name: "no id", rule: Rule{Time: "07:00"}, error: "no id"},
+		{name: "bad time", rule: Rule{ID: "a", Time: "25:00"}, error: "out of range"},
+		{name: "bad day", rule: Rule{ID: "a", Time: "07:00", Days: []string{"someday"}}, error: "invalid value"},
+		{name: "short cron", rule: Rule{ID: "a", Cron: "* * *"}, error: "want 5 fields"},
+		{name: "bad range", rule: Rule{ID: "a", Cron: "0 5-3 * * *"}, error: "backwards range"},
+		{name: "bad month", rule: Rule{ID: "a", Cron: "0 0 1 13 *"}, error: "out of range"},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := test.rule.compile()
+			if test.error != "" {
+				if err == nil || !strings.Contains(err.Error(), test.error) {
+					t.Fatalf("compile: unexpected error:\n  got: %v\n want: ...%s...", err, test.error)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("compile: %v", err)
+			}
+			for _, tm := range test.yes {
+				if !s.matches(tm) {
+					t.Errorf("does not match %v", tm.Format(time.RFC1123))
+				}
+			}
+			for _, tm := range test.no {
+				if s.matches(tm) {
+					t.Errorf("unexpectedly matches %v", tm.Format(time.RFC1123))
+				}
+			}
+		})
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alarms.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("open missing file: %v", err)
+	}
+	var events []EventType
+	s.SetEventListener(func(e Event) { events = append(events, e.Type) })
+	if err := s.Put(Rule{ID: "wake", Label: "WAKE UP", Time: "07:00"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(Rule{ID: "off", Time: "07:00", Disabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(Rule{ID: "bad"}); err == nil {
+		t.Error("put invalid rule: expected error")
+	}
+
+	if _, ringing := s.Tick(at(4, 6, 59, 59)); ringing {
+		t.Error("ringing before the alarm time")
+	}
+	if label, ringing := s.Tick(at(4, 7, 0, 0)); !ringing || label != "WAKE UP" {
+		t.Errorf("at alarm time: got (%q, %v), want (%q, true)", label, ringing, "WAKE UP")
+	}
+	if _, ringing := s.Tick(at(4, 7, 0, 1)); !ringing {
+		t.Error("stopped ringing after one second")
+	}
+	if err := s.Snooze(at(4, 7, 0, 1).Add(300*time.Millisecond), time.Minute); err != nil {
+		t.Fatalf("snooze: %v", err)
+	}
+	if _, ringing := s.Tick(at(4, 7, 0, 2)); ringing {
+		t.Error("ringing while snoozed")
+	}
+	if _, ringing := s.Tick(at(4, 7, 1, 1)); !ringing {
+		t.Error("not ringing after snooze")
+	}
+	if err := s.Dismiss(at(4, 7, 1, 2)); err != nil {
+		t.Fatalf("dismiss: %v", err)
+	}
+	if err := s.Dismiss(at(4, 7, 1, 3)); err != ErrNotRinging {
+		t.Errorf("dismiss twice:\n  got: %v\n want: %v", err, ErrNotRinging)
+	}
+	if _, ringing := s.Tick(at(4, 7, 1, 3)); ringing {
+		t.Error("ringing after dismiss")
+	}
+
+	// Alarms give up on their own eventually.
+	s.Tick(at(5, 7, 0, 0))
+	if _, ringing := s.Tick(at(5, 7, 0, 0).Add(ringDuration)); ringing {
+		t.Error("still ringing after ring duration")
+	}
+
+	want := []EventType{Ring, Snooze, Ring, Dismiss, Ring, Timeout}
+	if got := events; strings.Join(typeStrings(got), ",") != strings.Join(typeStrings(want), ",") {
+		t.Errorf("events:\n  got: %v\n want: %v", got, want)
+	}
+
+	// Rules survive a restart.
+	s2, err := Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if got, want := len(s2.Rules()), 2; got != want {
+		t.Errorf("rules after reopen:\n  got: %v\n want: %v", got, want)
+	}
+	if err := s2.Delete("off"); err != nil {
+		t.Errorf("delete: %v", err)
+	}
+	if err := s2.Delete("off"); err == nil {
+		t.Error("delete twice: expected error")
+	}
+}
+
+func typeStrings(ts []EventType) []string {
+	var result []string
+	for _, t := range ts {
+		result = append(result, string(t))
+	}
+	return result
+}
+
+func TestOpenInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alarms.json")
+	if err := os.WriteFile(path, []byte(`[{"id": "x", "time": "7am"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil || !strings.Contains(err.Error(), `rule "x"`) {
+		t.Errorf("open invalid file: unexpected error: %v", err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "alarms.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := http.StripPrefix("/alarms", s)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if method == "POST" {
+			req.Header.Set("content-type", "application/x-www-form-urlencoded")
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("PUT", "/alarms/rules/wake", `{"time": "07:00", "days": ["sat", "sun"]}`); rec.Code != http.StatusOK {
+		t.Fatalf("put rule: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do("PUT", "/alarms/rules/wake", `{"time": "7"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("put invalid rule: got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := do("PUT", "/alarms/rules/wake", `{"id": "other", "time": "07:00"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("put mismatched id: got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := do("POST", "/alarms/snooze", ""); rec.Code != http.StatusConflict {
+		t.Errorf("snooze when not ringing: got status %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	s.Tick(at(9, 7, 0, 0))
+	if rec := do("POST", "/alarms/snooze", "duration=bogus"); rec.Code != http.StatusBadRequest {
+		t.Errorf("snooze with bad duration: got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := do("POST", "/alarms/snooze", "duration=5m"); rec.Code != http.StatusOK {
+		t.Errorf("snooze: status %d: %s", rec.Code, rec.Body)
+	}
+
+	rec := do("GET", "/alarms/", "")
+	var got struct {
+		Rules  []Rule
+		Status Status
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode status: %v", err)
+	}
+	if len(got.Rules) != 1 || got.Rules[0].ID != "wake" || got.Status.Snoozed == nil {
+		t.Errorf("unexpected status: %#v", got)
+	}
+
+	if rec := do("POST", "/alarms/dismiss", ""); rec.Code != http.StatusOK {
+		t.Errorf("dismiss: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do("DELETE", "/alarms/rules/wake", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("delete: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do("DELETE", "/alarms/rules/wake", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("delete twice: got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
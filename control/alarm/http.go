@@ -0,0 +1,113 @@
+package alarm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ServeHTTP lets alarms be managed over HTTP; mount it with http.StripPrefix.
+//
+//	GET    /             the rules and status, as JSON
+//	PUT    /rules/<id>   add or replace a rule; the body is the JSON rule, whose id may be omitted
+//	DELETE /rules/<id>   delete a rule
+//	POST   /snooze       snooze the ringing alarm; optional form value duration=9m
+//	POST   /dismiss      dismiss the ringing or snoozed alarm
+func (s *Scheduler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := req.URL.Path
+	switch {
+	case path == "" || path == "/":
+		if req.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, struct {
+			Rules  []Rule `json:"rules"`
+			Status Status `json:"status"`
+		}{s.Rules(), s.Status()})
+	case strings.HasPrefix(path, "/rules/") && len(path) > len("/rules/"):
+		s.serveRule(w, req, strings.TrimPrefix(path, "/rules/"))
+	case path == "/snooze" || path == "/dismiss":
+		if req.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var err error
+		if path == "/dismiss" {
+			err = s.Dismiss(time.Now())
+		} else {
+			d := DefaultSnooze
+			if v := req.FormValue("duration"); v != "" {
+				if d, err = time.ParseDuration(v); err != nil {
+					http.Error(w, fmt.Sprintf("parse duration: %v", err), http.StatusBadRequest)
+					return
+				}
+			}
+			err = s.Snooze(time.Now(), d)
+		}
+		if errors.Is(err, ErrNotRinging) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, s.Status())
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (s *Scheduler) serveRule(w http.ResponseWriter, req *http.Request, id string) {
+	switch req.Method {
+	case http.MethodPut:
+		var r Rule
+		d := json.NewDecoder(req.Body)
+		d.DisallowUnknownFields()
+		if err := d.Decode(&r); err != nil {
+			http.Error(w, fmt.Sprintf("decode rule: %v", err), http.StatusBadRequest)
+			return
+		}
+		if r.ID == "" {
+			r.ID = id
+		}
+		if r.ID != id {
+			http.Error(w, fmt.Sprintf("rule id %q does not match url", r.ID), http.StatusBadRequest)
+			return
+		}
+		if err := r.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.Put(r); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r)
+	case http.MethodDelete:
+		if err := s.Delete(id); errors.Is(err, ErrNoSuchRule) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	e := json.NewEncoder(w)
+	e.SetIndent("", "    ")
+	if err := e.Encode(v); err != nil {
+		log.Printf("alarm: encode response: %v", err)
+	}
+}
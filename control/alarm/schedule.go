@@ -0,0 +1,174 @@
+package alarm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule is a compiled Rule; each field is a bitmask of the values that match.
+type schedule struct {
+	second, minute, hour uint64
+	dom, month, dow      uint64
+	// anyDom and anyDow record whether the day-of-month and day-of-week fields were "*"; as in
+	// cron, when both are restricted a time matches if either one does.
+	anyDom, anyDow bool
+}
+
+func (s *schedule) matches(t time.Time) bool {
+	if s.second&(1<<uint(t.Second())) == 0 || s.minute&(1<<uint(t.Minute())) == 0 ||
+		s.hour&(1<<uint(t.Hour())) == 0 || s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	domOK := s.dom&(1<<uint(t.Day())) != 0
+	dowOK := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.anyDom || s.anyDow {
+		return domOK && dowOK
+	}
+	return domOK || dowOK
+}
+
+// cronField describes one field of a cron expression.
+type cronField struct {
+	name     string
+	min, max int
+	names    []string // Names for min, min+1, ...; like "jan" or "sun".
+}
+
+var (
+	minuteField = cronField{name: "minute", min: 0, max: 59}
+	hourField   = cronField{name: "hour", min: 0, max: 23}
+	domField    = cronField{name: "day of month", min: 1, max: 31}
+	monthField  = cronField{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	dowField    = cronField{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+func (f cronField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + i, nil
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid value %q", f.name, s)
+	}
+	if n < f.min || n > f.max {
+		return 0, fmt.Errorf("%s: %d is out of range %d-%d", f.name, n, f.min, f.max)
+	}
+	return n, nil
+}
+
+// parse parses a comma-separated list of values, ranges ("1-5") and steps ("*/15", "8-18/2").
+func (f cronField) parse(s string) (uint64, error) {
+	var mask uint64
+	for _, part := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("%s: invalid step in %q", f.name, part)
+			}
+			step, part = n, part[:i]
+		}
+		lo, hi := f.min, f.max
+		switch {
+		case part == "*":
+		case strings.IndexByte(part, '-') > 0:
+			i := strings.IndexByte(part, '-')
+			var err error
+			if lo, err = f.value(part[:i]); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(part[i+1:]); err != nil {
+				return 0, err
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("%s: backwards range %q", f.name, part)
+			}
+		default:
+			v, err := f.value(part)
+			if err != nil {
+				return 0, err
+			}
+			lo, hi = v, v
+			if step != 1 {
+				hi = f.max
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+	return mask, nil
+}
+
+// parseCron parses a standard five-field cron expression: minute, hour, day of month, month and
+// day of week.  Alarms fire at second zero of matching minutes.
+func parseCron(expr string) (*schedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q: want 5 fields, got %d", expr, len(fields))
+	}
+	s := &schedule{second: 1, anyDom: fields[2] == "*", anyDow: fields[4] == "*"}
+	var err error
+	for i, f := range []struct {
+		field cronField
+		dst   *uint64
+	}{
+		{minuteField, &s.minute},
+		{hourField, &s.hour},
+		{domField, &s.dom},
+		{monthField, &s.month},
+		{dowField, &s.dow},
+	} {
+		if *f.dst, err = f.field.parse(fields[i]); err != nil {
+			return nil, fmt.Errorf("cron expression %q: %w", expr, err)
+		}
+	}
+	// Both 0 and 7 mean Sunday.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// parseDaily compiles a time of day ("07:30" or "07:30:15") and an optional list of weekdays
+// ("mon", "tue", ...; empty means every day) into a schedule.
+func parseDaily(clock string, days []string) (*schedule, error) {
+	var h, m, sec int
+	parts := strings.Split(clock, ":")
+	if len(parts) != 2 && len(parts) != 3 {
+		return nil, fmt.Errorf("time %q: want HH:MM or HH:MM:SS", clock)
+	}
+	for i, dst := range []*int{&h, &m, &sec}[:len(parts)] {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return nil, fmt.Errorf("time %q: want HH:MM or HH:MM:SS", clock)
+		}
+		*dst = n
+	}
+	if h < 0 || h > 23 || m < 0 || m > 59 || sec < 0 || sec > 59 {
+		return nil, fmt.Errorf("time %q is out of range", clock)
+	}
+	s := &schedule{
+		second: 1 << uint(sec),
+		minute: 1 << uint(m),
+		hour:   1 << uint(h),
+		anyDom: true,
+	}
+	s.dom, _ = domField.parse("*")
+	s.month, _ = monthField.parse("*")
+	if len(days) == 0 {
+		s.dow, _ = dowField.parse("*")
+	}
+	for _, d := range days {
+		v, err := dowField.value(d)
+		if err != nil {
+			return nil, fmt.Errorf("days: %w", err)
+		}
+		s.dow |= 1 << uint(v%7)
+	}
+	return s, nil
+}
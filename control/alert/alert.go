@@ -0,0 +1,328 @@
+// Package alert raises alerts when metrics stay out of bounds, and sends notifications when an
+// alert starts firing and when it resolves.  Rules are kept in a JSON file that looks like this:
+//
+//	{
+//	    "webhooks": ["https://example.com/hooks/clock"],
+//	    "rules": [
+//	        {"name": "no-gps-fix", "metric": "gpsd.fix_mode", "op": "<", "value": 2, "for": "5m", "if_missing": true},
+//	        {"name": "offset", "metric": "chrony.system_offset_seconds", "abs": true, "op": ">", "value": 0.001, "for": "10m"}
+//	    ]
+//	}
+//
+// Programs feed the Engine metric values as they arrive, and call Evaluate periodically.
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultMaxAge is how old a metric's value can be before it counts as missing, if the rules file
+// doesn't say.
+const DefaultMaxAge = 2 * time.Minute
+
+// File is a rules file.
+type File struct {
+	Webhooks []string `json:"webhooks"` // Notifications are POSTed to each of these as JSON.
+	MaxAge   string   `json:"max_age"`  // Like "2m"; empty for DefaultMaxAge.
+	Rules    []*Rule  `json:"rules"`
+
+	maxAge time.Duration
+}
+
+// Rule describes when an alert fires: when Metric compared with Value by Op is true, and stays
+// true for For.
+type Rule struct {
+	Name   string  `json:"name"`
+	Metric string  `json:"metric"`
+	Abs    bool    `json:"abs"` // Compare the metric's absolute value, for offsets.
+	Op     string  `json:"op"`  // ">", ">=", "<", "<=", "==" or "!=".
+	Value  float64 `json:"value"`
+	For    string  `json:"for"` // Like "5m"; empty to fire as soon as the condition is true.
+	// IfMissing makes a missing or stale metric count as meeting the condition; otherwise it
+	// counts as not meeting it.
+	IfMissing bool   `json:"if_missing"`
+	Message   string `json:"message"` // Added to notifications, to say what to do.
+
+	forDuration time.Duration
+}
+
+func (r *Rule) compile() error {
+	if r.Name == "" {
+		return errors.New("rule has no name")
+	}
+	if r.Metric == "" {
+		return fmt.Errorf("rule %q: no metric", r.Name)
+	}
+	if _, ok := ops[r.Op]; !ok {
+		return fmt.Errorf("rule %q: unknown op %q", r.Name, r.Op)
+	}
+	r.forDuration = 0
+	if r.For != "" {
+		d, err := time.ParseDuration(r.For)
+		if err != nil {
+			return fmt.Errorf("rule %q: for: %w", r.Name, err)
+		}
+		if d < 0 {
+			return fmt.Errorf("rule %q: for: %v is negative", r.Name, d)
+		}
+		r.forDuration = d
+	}
+	return nil
+}
+
+var ops = map[string]func(a, b float64) bool{
+	">":  func(a, b float64) bool { return a > b },
+	">=": func(a, b float64) bool { return a >= b },
+	"<":  func(a, b float64) bool { return a < b },
+	"<=": func(a, b float64) bool { return a <= b },
+	"==": func(a, b float64) bool { return a == b },
+	"!=": func(a, b float64) bool { return a != b },
+}
+
+// holds returns whether the rule's condition is true of the value v.
+func (r *Rule) holds(v float64) bool {
+	if r.Abs {
+		v = math.Abs(v)
+	}
+	return ops[r.Op](v, r.Value)
+}
+
+// condition describes the rule's condition, like "|chrony.system_offset_seconds| > 0.001".
+func (r *Rule) condition() string {
+	m := r.Metric
+	if r.Abs {
+		m = "|" + m + "|"
+	}
+	return fmt.Sprintf("%s %s %s", m, r.Op, strconv.FormatFloat(r.Value, 'g', -1, 64))
+}
+
+// ParseFile parses and validates a rules file.
+func ParseFile(data []byte) (*File, error) {
+	f := new(File)
+	if len(bytes.TrimSpace(data)) > 0 {
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.DisallowUnknownFields()
+		if err := d.Decode(f); err != nil {
+			return nil, fmt.Errorf("decode rules: %w", err)
+		}
+	}
+	if err := f.compile(); err != nil {
+		return nil, fmt.Errorf("validate rules: %w", err)
+	}
+	return f, nil
+}
+
+func (f *File) compile() error {
+	f.maxAge = DefaultMaxAge
+	if f.MaxAge != "" {
+		d, err := time.ParseDuration(f.MaxAge)
+		if err != nil {
+			return fmt.Errorf("max_age: %w", err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("max_age: %v is not positive", d)
+		}
+		f.maxAge = d
+	}
+	for _, w := range f.Webhooks {
+		if u, err := url.Parse(w); err != nil {
+			return fmt.Errorf("webhook: %w", err)
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("webhook: scheme must be http or https, not %q", u.Scheme)
+		}
+	}
+	names := make(map[string]bool)
+	for _, r := range f.Rules {
+		if err := r.compile(); err != nil {
+			return err
+		}
+		if names[r.Name] {
+			return fmt.Errorf("rule %q: duplicate name", r.Name)
+		}
+		names[r.Name] = true
+	}
+	return nil
+}
+
+// LoadFile reads and validates the rules file at path.  A missing file has no rules.
+func LoadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read rules: %w", err)
+	}
+	f, err := ParseFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+// State is where an alert is in its lifecycle.
+type State string
+
+const (
+	StateInactive State = "inactive" // The condition is false.
+	StatePending  State = "pending"  // The condition is true, but not for long enough yet.
+	StateFiring   State = "firing"   // The condition has been true for long enough.
+)
+
+// Alert is the current state of one rule.
+type Alert struct {
+	Rule    *Rule     `json:"rule"`
+	State   State     `json:"state"`
+	Value   float64   `json:"value"`
+	Missing bool      `json:"missing"` // The metric is missing or stale, so Value is meaningless.
+	Since   time.Time `json:"since"`   // When the condition became true; zero if it isn't.
+}
+
+// Notification is sent when an alert starts firing, and when it resolves.
+type Notification struct {
+	Status  string    `json:"status"` // "firing" or "resolved".
+	Rule    string    `json:"rule"`
+	Metric  string    `json:"metric"`
+	Value   *float64  `json:"value"` // Nil if the metric is missing.
+	Since   time.Time `json:"since"` // When the condition became true.
+	Time    time.Time `json:"time"`
+	Summary string    `json:"summary"` // What happened, for people.
+	Message string    `json:"message,omitempty"`
+}
+
+func newNotification(status string, a *Alert, now time.Time) Notification {
+	n := Notification{Status: status, Rule: a.Rule.Name, Metric: a.Rule.Metric, Since: a.Since, Time: now, Message: a.Rule.Message}
+	value := "missing"
+	if !a.Missing {
+		v := a.Value
+		n.Value = &v
+		value = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	if status == "firing" {
+		n.Summary = fmt.Sprintf("%s is %s; firing because %s", a.Rule.Metric, value, a.Rule.condition())
+	} else {
+		n.Summary = fmt.Sprintf("%s is %s; resolved after %v because %s is false", a.Rule.Metric, value, now.Sub(a.Since).Round(time.Second), a.Rule.condition())
+	}
+	return n
+}
+
+type sample struct {
+	value float64
+	time  time.Time
+}
+
+// Engine evaluates rules against the latest values of metrics.  It is safe to use from multiple
+// goroutines.
+type Engine struct {
+	mu      sync.Mutex
+	file    *File
+	metrics map[string]sample
+	alerts  map[string]*Alert // By rule name.
+}
+
+// NewEngine returns an engine that evaluates the rules in f, which may be nil for no rules.
+func NewEngine(f *File) *Engine {
+	e := &Engine{metrics: make(map[string]sample), alerts: make(map[string]*Alert)}
+	e.SetFile(f, time.Time{})
+	return e
+}
+
+// SetFile replaces the rules at time now.  Rules that keep their names keep their state.  An
+// alert whose rule is removed while it's firing is resolved, and the returned notifications say
+// so; they belong to the webhooks of the old rules.
+func (e *Engine) SetFile(f *File, now time.Time) []Notification {
+	if f == nil {
+		f = &File{maxAge: DefaultMaxAge}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.file = f
+	alerts := make(map[string]*Alert)
+	for _, r := range f.Rules {
+		a, ok := e.alerts[r.Name]
+		if !ok {
+			a = &Alert{State: StateInactive}
+		}
+		a.Rule = r
+		alerts[r.Name] = a
+	}
+	var result []Notification
+	for name, a := range e.alerts {
+		if _, ok := alerts[name]; ok || a.State != StateFiring {
+			continue
+		}
+		n := newNotification("resolved", a, now)
+		n.Summary = fmt.Sprintf("rule %s was removed; resolved after %v", name, now.Sub(a.Since).Round(time.Second))
+		result = append(result, n)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Rule < result[j].Rule })
+	e.alerts = alerts
+	return result
+}
+
+// Webhooks returns where notifications should be sent.
+func (e *Engine) Webhooks() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Webhooks
+}
+
+// Set records the value of a metric at time t.
+func (e *Engine) Set(metric string, value float64, t time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.metrics[metric] = sample{value: value, time: t}
+}
+
+// Evaluate checks every rule against the latest metrics, and returns notifications for the alerts
+// that started firing or resolved since the last evaluation.  An alert that stays firing isn't
+// notified again.
+func (e *Engine) Evaluate(now time.Time) []Notification {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var result []Notification
+	for _, r := range e.file.Rules {
+		a := e.alerts[r.Name]
+		s, ok := e.metrics[r.Metric]
+		a.Missing = !ok || now.Sub(s.time) > e.file.maxAge
+		a.Value = s.value
+		holds := r.IfMissing
+		if !a.Missing {
+			holds = r.holds(s.value)
+		}
+		if !holds {
+			if a.State == StateFiring {
+				result = append(result, newNotification("resolved", a, now))
+			}
+			a.State, a.Since = StateInactive, time.Time{}
+			continue
+		}
+		if a.State == StateInactive {
+			a.State, a.Since = StatePending, now
+		}
+		if a.State == StatePending && now.Sub(a.Since) >= r.forDuration {
+			a.State = StateFiring
+			result = append(result, newNotification("firing", a, now))
+		}
+	}
+	return result
+}
+
+// Alerts returns the state of every rule's alert, sorted by rule name.
+func (e *Engine) Alerts() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	result := make([]Alert, 0, len(e.alerts))
+	for _, a := range e.alerts {
+		result = append(result, *a)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Rule.Name < result[j].Rule.Name })
+	return result
+}
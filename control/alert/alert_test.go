@@ -0,0 +1,239 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseFile(t *testing.T) {
+	testData := []struct {
+		name    string
+		in      string
+		wantErr string
+	}{
+		{name: "empty", in: ""},
+		{name: "rule", in: `{"rules": [{"name": "fix", "metric": "gpsd.fix_mode", "op": "<", "value": 2, "for": "5m"}]}`},
+		{name: "typo", in: `{"rules": [{"name": "fix", "metrc": "gpsd.fix_mode"}]}`, wantErr: `unknown field "metrc"`},
+		{name: "no name", in: `{"rules": [{"metric": "gpsd.fix_mode", "op": "<"}]}`, wantErr: "rule has no name"},
+		{name: "no metric", in: `{"rules": [{"name": "fix", "op": "<"}]}`, wantErr: `rule "fix": no metric`},
+		{name: "bad op", in: `{"rules": [{"name": "fix", "metric": "gpsd.fix_mode", "op": "=>"}]}`, wantErr: `rule "fix": unknown op "=>"`},
+		{name: "bad for", in: `{"rules": [{"name": "fix", "metric": "gpsd.fix_mode", "op": "<", "for": "5 minutes"}]}`, wantErr: `rule "fix": for:`},
+		{name: "duplicate", in: `{"rules": [{"name": "fix", "metric": "a", "op": "<"}, {"name": "fix", "metric": "b", "op": "<"}]}`, wantErr: `rule "fix": duplicate name`},
+		{name: "bad max age", in: `{"max_age": "0s"}`, wantErr: "max_age: 0s is not positive"},
+		{name: "bad webhook", in: `{"webhooks": ["ftp://example.com"]}`, wantErr: `webhook: scheme must be http or https, not "ftp"`},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseFile([]byte(test.in))
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("error:\n  got: %v\n want: something containing %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestExampleRules(t *testing.T) {
+	f, err := LoadFile("../../etc/gps-clock-alerts.json")
+	if err != nil {
+		t.Fatalf("load example: %v", err)
+	}
+	if len(f.Rules) == 0 {
+		t.Error("example has no rules")
+	}
+	if _, err := LoadFile("/nonexistent/alerts.json"); err != nil {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func mustParse(t *testing.T, in string) *File {
+	t.Helper()
+	f, err := ParseFile([]byte(in))
+	if err != nil {
+		t.Fatalf("parse rules: %v", err)
+	}
+	return f
+}
+
+// summarize describes notifications compactly, for comparing.
+func summarize(ns []Notification) string {
+	var parts []string
+	for _, n := range ns {
+		parts = append(parts, n.Status+" "+n.Rule)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func TestEvaluate(t *testing.T) {
+	e := NewEngine(mustParse(t, `{"max_age": "1m", "rules": [
+		{"name": "offset", "metric": "chrony.system_offset_seconds", "abs": true, "op": ">", "value": 0.001, "for": "30s"},
+		{"name": "fix", "metric": "gpsd.fix_mode", "op": "<", "value": 2, "if_missing": true},
+		{"name": "hot", "metric": "sensors.temperature_celsius", "op": ">", "value": 50}
+	]}`))
+	start := time.Unix(1633046400, 0)
+	at := func(s int) time.Time { return start.Add(time.Duration(s) * time.Second) }
+
+	steps := []struct {
+		at   int
+		set  map[string]float64
+		want string
+	}{
+		// gpsd hasn't reported yet, and that counts; the sensor hasn't either, and that doesn't.
+		{at: 0, set: map[string]float64{"chrony.system_offset_seconds": -0.002}, want: "firing fix"},
+		{at: 10, set: map[string]float64{"gpsd.fix_mode": 3}, want: "resolved fix"},
+		{at: 20, set: map[string]float64{"chrony.system_offset_seconds": -0.002}},
+		// The offset has been out of bounds for 30 seconds.
+		{at: 30, set: map[string]float64{"chrony.system_offset_seconds": 0.003}, want: "firing offset"},
+		// Still firing; no repeat.
+		{at: 40, set: map[string]float64{"chrony.system_offset_seconds": 0.003, "gpsd.fix_mode": 3}},
+		{at: 50, set: map[string]float64{"chrony.system_offset_seconds": 0.0001, "sensors.temperature_celsius": 55, "gpsd.fix_mode": 3}, want: "resolved offset, firing hot"},
+		// A blip shorter than the for-duration doesn't fire.
+		{at: 60, set: map[string]float64{"chrony.system_offset_seconds": 0.002, "sensors.temperature_celsius": 55, "gpsd.fix_mode": 3}},
+		{at: 70, set: map[string]float64{"chrony.system_offset_seconds": 0.0001, "sensors.temperature_celsius": 55, "gpsd.fix_mode": 3}},
+		// gpsd goes quiet for longer than max_age; the stale temperature stops counting too.
+		{at: 140, set: map[string]float64{"chrony.system_offset_seconds": 0.0001}, want: "firing fix, resolved hot"},
+	}
+	for _, step := range steps {
+		for m, v := range step.set {
+			e.Set(m, v, at(step.at))
+		}
+		if got, want := summarize(e.Evaluate(at(step.at))), step.want; got != want {
+			t.Errorf("at %ds: notifications:\n  got: %v\n want: %v", step.at, got, want)
+		}
+	}
+
+	var states []string
+	for _, a := range e.Alerts() {
+		states = append(states, fmt.Sprintf("%s=%s", a.Rule.Name, a.State))
+	}
+	if got, want := strings.Join(states, " "), "fix=firing hot=inactive offset=inactive"; got != want {
+		t.Errorf("alerts:\n  got: %v\n want: %v", got, want)
+	}
+
+	// Reloading keeps the state of rules that are still there.
+	if got := e.SetFile(mustParse(t, `{"max_age": "1m", "rules": [{"name": "fix", "metric": "gpsd.fix_mode", "op": "<", "value": 2, "if_missing": true}]}`), at(150)); len(got) > 0 {
+		t.Errorf("reload: unexpected notifications %v", summarize(got))
+	}
+	if got := e.Evaluate(at(150)); len(got) > 0 {
+		t.Errorf("after reload: unexpected notifications %v", summarize(got))
+	}
+	e.Set("gpsd.fix_mode", 2, at(160))
+	if got, want := summarize(e.Evaluate(at(160))), "resolved fix"; got != want {
+		t.Errorf("after reload: notifications:\n  got: %v\n want: %v", got, want)
+	}
+
+	// Removing the rule of a firing alert resolves it.
+	e.Set("gpsd.fix_mode", 1, at(170))
+	if got, want := summarize(e.Evaluate(at(170))), "firing fix"; got != want {
+		t.Errorf("before removal: notifications:\n  got: %v\n want: %v", got, want)
+	}
+	ns := e.SetFile(nil, at(230))
+	if got, want := summarize(ns), "resolved fix"; got != want {
+		t.Errorf("removal: notifications:\n  got: %v\n want: %v", got, want)
+	}
+	if len(ns) == 1 && ns[0].Summary != "rule fix was removed; resolved after 1m0s" {
+		t.Errorf("removal: summary %q", ns[0].Summary)
+	}
+	if got := e.Evaluate(at(240)); len(got) > 0 || len(e.Alerts()) > 0 {
+		t.Errorf("after removal: notifications %v, alerts %v", summarize(got), e.Alerts())
+	}
+}
+
+func TestNotification(t *testing.T) {
+	e := NewEngine(mustParse(t, `{"rules": [{"name": "offset", "metric": "chrony.system_offset_seconds", "abs": true, "op": ">", "value": 0.001, "for": "1m", "message": "check the antenna"}]}`))
+	start := time.Unix(1633046400, 0)
+	e.Set("chrony.system_offset_seconds", -0.002, start)
+	e.Evaluate(start)
+	e.Set("chrony.system_offset_seconds", -0.0025, start.Add(time.Minute))
+	ns := e.Evaluate(start.Add(time.Minute))
+	if len(ns) != 1 {
+		t.Fatalf("notifications: %v", ns)
+	}
+	n := ns[0]
+	if n.Value == nil || *n.Value != -0.0025 || !n.Since.Equal(start) || !n.Time.Equal(start.Add(time.Minute)) || n.Message != "check the antenna" {
+		t.Errorf("notification: %+v", n)
+	}
+	if got, want := n.Summary, "chrony.system_offset_seconds is -0.0025; firing because |chrony.system_offset_seconds| > 0.001"; got != want {
+		t.Errorf("summary:\n  got: %v\n want: %v", got, want)
+	}
+	e.Set("chrony.system_offset_seconds", 0, start.Add(3*time.Minute))
+	ns = e.Evaluate(start.Add(3 * time.Minute))
+	if got, want := ns[0].Summary, "chrony.system_offset_seconds is 0; resolved after 3m0s because |chrony.system_offset_seconds| > 0.001 is false"; got != want {
+		t.Errorf("summary:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+// receiver is a webhook that records what it receives, failing the first failures requests.
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	failures int
+	got      []Notification
+}
+
+func newReceiver(t *testing.T, failures int) *receiver {
+	r := &receiver{failures: failures}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.failures > 0 {
+			r.failures--
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		if got, want := req.Header.Get("content-type"), "application/json"; got != want {
+			t.Errorf("content-type:\n  got: %v\n want: %v", got, want)
+		}
+		var n Notification
+		if err := json.NewDecoder(req.Body).Decode(&n); err != nil {
+			t.Errorf("decode notification: %v", err)
+		}
+		r.got = append(r.got, n)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) summary() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return summarize(r.got)
+}
+
+func TestDeliver(t *testing.T) {
+	ok, flaky, broken := newReceiver(t, 0), newReceiver(t, 2), newReceiver(t, 3)
+	ns := []Notification{{Status: "firing", Rule: "fix"}, {Status: "resolved", Rule: "fix"}}
+	err := Deliver(context.Background(), []string{ok.URL, flaky.URL, broken.URL}, ns, time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "fix: "+broken.URL+": post notification: unexpected status 503") {
+		t.Errorf("error: %v", err)
+	}
+	if got, want := ok.summary(), "firing fix, resolved fix"; got != want {
+		t.Errorf("ok receiver:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := flaky.summary(), "firing fix, resolved fix"; got != want {
+		t.Errorf("flaky receiver:\n  got: %v\n want: %v", got, want)
+	}
+	// The broken receiver used up its failures on the first notification.
+	if got, want := broken.summary(), "resolved fix"; got != want {
+		t.Errorf("broken receiver:\n  got: %v\n want: %v", got, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Deliver(ctx, []string{newReceiver(t, 1).URL}, ns, time.Hour); err == nil {
+		t.Error("delivery with a cancelled context should fail")
+	}
+}
@@ -0,0 +1,46 @@
+package alert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jrockway/beaglebone-gps-clock/control/webhook"
+)
+
+// deliveryAttempts is how many times Deliver tries each webhook.
+const deliveryAttempts = 3
+
+// Deliver posts each notification to each webhook, in order.  A failed post is tried again after
+// retry, up to three times in all, before Deliver gives up on it and moves on.  The returned error
+// describes every post that failed.
+func Deliver(ctx context.Context, webhooks []string, notifications []Notification, retry time.Duration) error {
+	var errs []string
+	for _, n := range notifications {
+		for _, url := range webhooks {
+			var err error
+			for i := 0; i < deliveryAttempts; i++ {
+				if i > 0 {
+					select {
+					case <-ctx.Done():
+						return fmt.Errorf("deliver notifications: %w", ctx.Err())
+					case <-time.After(retry):
+					}
+				}
+				if err = webhook.Post(ctx, url, n); err == nil {
+					break
+				}
+				err = fmt.Errorf("post notification: %w", err)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s: %v", n.Rule, url, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,382 @@
+// Package chronymon polls chronyd's command port and hands out snapshots of what it reports.
+package chronymon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/chrony"
+)
+
+const (
+	// DefaultInterval is how often a Monitor polls chronyd if Interval isn't set.
+	DefaultInterval = 30 * time.Second
+
+	// DefaultTimeout is how long a poll may take if Timeout isn't set.
+	DefaultTimeout = 10 * time.Second
+
+	// DefaultRetry is how long Run waits before reconnecting after an error if Retry isn't set.
+	DefaultRetry = 10 * time.Second
+
+	// sourceAttempts is how many times poll asks for the list of sources, if chronyd's sources
+	// keep changing while it asks.
+	sourceAttempts = 3
+)
+
+// Source is one of chronyd's time sources.
+type Source struct {
+	Index int                // The source's position in chronyd's list of sources, when polled.
+	Data  chrony.SourceData  // As shown by "chronyc sources".
+	Stats chrony.SourceStats // As shown by "chronyc sourcestats".
+	NTP   *chrony.NTPData    // As shown by "chronyc ntpdata"; nil for reference clocks.
+}
+
+// Snapshot is the result of one poll of chronyd.
+type Snapshot struct {
+	Time     time.Time // When the poll started.
+	Err      error     // Why the poll failed; if set, the rest of the snapshot is empty.
+	Tracking chrony.Tracking
+	Sources  []Source
+
+	// The rest of the reports are optional; they're nil if chronyd didn't give them out, and
+	// Warnings says why.  ServerStats, Clients and each source's NTP data are only available
+	// over chronyd's Unix socket.
+	Activity    *Activity
+	RTC         *RTC // Also nil if chronyd isn't tracking the real-time clock.
+	ServerStats *chrony.ServerStats2
+	Clients     []Client // At most MaxClients of them.
+	Warnings    []error
+}
+
+// Synchronized returns true if chronyd was reachable and synchronized to a source.
+func (s *Snapshot) Synchronized() bool {
+	return s.Err == nil && s.Tracking.LeapStatus != LeapUnsynchronized && s.Tracking.Stratum > 0
+}
+
+// LeapUnsynchronized is the leap status that chronyd reports when it isn't synchronized.
+const LeapUnsynchronized = 3
+
+// Poll connects to chronyd's command port at addr, takes one snapshot, and disconnects.  If
+// socket, the path of chronyd's Unix socket, isn't empty, the snapshot includes the reports that
+// need it.
+func Poll(addr, socket string) (*Snapshot, error) {
+	conn, err := net.DialTimeout("udp", addr, DefaultTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+	s, err := poll(conn, &chrony.Client{Sequence: 1, Connection: conn}, DefaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if socket != "" {
+		if err := pollPrivileged(socket, s, DefaultTimeout); err != nil {
+			s.Warnings = append(s.Warnings, err)
+		}
+	}
+	return s, nil
+}
+
+// poll asks chronyd for tracking, the list of sources, and each source's data and statistics.
+func poll(conn net.Conn, c *chrony.Client, timeout time.Duration) (*Snapshot, error) {
+	s := &Snapshot{Time: time.Now()}
+	if err := conn.SetDeadline(s.Time.Add(timeout)); err != nil {
+		return nil, fmt.Errorf("set deadline: %w", err)
+	}
+
+	res, err := c.Communicate(chrony.NewTrackingPacket())
+	if err != nil {
+		return nil, fmt.Errorf("get tracking info: %w", err)
+	}
+	tracking, ok := res.(*chrony.ReplyTracking)
+	if !ok {
+		return nil, fmt.Errorf("tracking reply was of unexpected type %T", res)
+	}
+	s.Tracking = tracking.Tracking
+
+	for attempt := 1; ; attempt++ {
+		s.Sources, err = getSources(c)
+		if !errors.Is(err, errInconsistent) || attempt == sourceAttempts {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Activity, err = getActivity(c); err != nil {
+		s.Warnings = append(s.Warnings, fmt.Errorf("get activity: %w", err))
+	}
+	if s.RTC, err = getRTC(c); err != nil {
+		s.Warnings = append(s.Warnings, fmt.Errorf("get rtcdata: %w", err))
+	}
+	return s, nil
+}
+
+// errInconsistent means that chronyd's sources changed while getSources was asking about them.
+var errInconsistent = errors.New("sources changed while polling")
+
+// errNoSuchSource is the error that the chrony package returns when chronyd is asked about a
+// source index that it doesn't have.  The package doesn't return the status in any other form.
+var errNoSuchSource = "got status " + chrony.StatusDesc[4]
+
+// sourceError wraps err from asking about source i, turning chronyd's complaint that the source
+// doesn't exist into errInconsistent; the source must have been removed since we counted them.
+func sourceError(i int, what string, err error) error {
+	if err.Error() == errNoSuchSource {
+		return fmt.Errorf("%w: source %d: %s: %v", errInconsistent, i, what, err)
+	}
+	return fmt.Errorf("source %d: %s: %w", i, what, err)
+}
+
+// countSources asks chronyd how many sources it has.
+func countSources(c *chrony.Client) (int, error) {
+	res, err := c.Communicate(chrony.NewSourcesPacket())
+	if err != nil {
+		return 0, fmt.Errorf("get sources: %w", err)
+	}
+	sources, ok := res.(*chrony.ReplySources)
+	if !ok {
+		return 0, fmt.Errorf("sources reply was of unexpected type %T", res)
+	}
+	return sources.NSources, nil
+}
+
+// getSources asks chronyd for each source's data and statistics.  chronyd can add, remove or
+// reorder sources between requests, so the statistics are matched to the data by address rather
+// than by index, and errInconsistent is returned if they don't pair up, if an index stops
+// existing, or if the number of sources is different at the end.
+func getSources(c *chrony.Client) ([]Source, error) {
+	n, err := countSources(c)
+	if err != nil {
+		return nil, err
+	}
+	var result []Source
+	var stats []chrony.SourceStats
+	for i := 0; i < n; i++ {
+		res, err := c.Communicate(chrony.NewSourceDataPacket(int32(i)))
+		if err != nil {
+			return nil, sourceError(i, "get source data", err)
+		}
+		data, ok := res.(*chrony.ReplySourceData)
+		if !ok {
+			return nil, fmt.Errorf("source %d: source data reply was of unexpected type %T", i, res)
+		}
+		res, err = c.Communicate(chrony.NewSourceStatsPacket(int32(i)))
+		if err != nil {
+			return nil, sourceError(i, "get sourcestats", err)
+		}
+		ss, ok := res.(*chrony.ReplySourceStats)
+		if !ok {
+			return nil, fmt.Errorf("source %d: sourcestats reply was of unexpected type %T", i, res)
+		}
+		result = append(result, Source{Index: i, Data: data.SourceData})
+		stats = append(stats, ss.SourceStats)
+	}
+	if err := matchStats(result, stats); err != nil {
+		return nil, err
+	}
+	if after, err := countSources(c); err != nil {
+		return nil, err
+	} else if after != n {
+		return nil, fmt.Errorf("%w: had %d sources, now %d", errInconsistent, n, after)
+	}
+	return result, nil
+}
+
+// statsAddr returns the address that identifies the source that ss describes, in the form that
+// sourcedata uses: reference clocks have no address, so their refid stands in for it.
+func statsAddr(ss chrony.SourceStats) net.IP {
+	if ss.IPAddr != nil && !ss.IPAddr.IsUnspecified() {
+		return ss.IPAddr
+	}
+	return net.IPv4(byte(ss.RefID>>24), byte(ss.RefID>>16), byte(ss.RefID>>8), byte(ss.RefID))
+}
+
+// matchStats fills in each source's Stats from stats, which must describe the same sources in any
+// order.  A source that appears twice means that chronyd's list shifted between requests.
+func matchStats(sources []Source, stats []chrony.SourceStats) error {
+	used := make([]bool, len(stats))
+	seen := make(map[string]bool)
+	for i := range sources {
+		addr := sources[i].Data.IPAddr
+		if seen[addr.String()] {
+			return fmt.Errorf("%w: %v appears twice", errInconsistent, SourceName(addr))
+		}
+		seen[addr.String()] = true
+		found := false
+		for j, ss := range stats {
+			if !used[j] && statsAddr(ss).Equal(addr) {
+				sources[i].Stats, used[j], found = ss, true, true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: no sourcestats for %v", errInconsistent, SourceName(addr))
+		}
+	}
+	return nil
+}
+
+// Monitor polls chronyd periodically and sends each snapshot to its subscribers.
+type Monitor struct {
+	Interval time.Duration // How often to poll; DefaultInterval if zero.
+	Timeout  time.Duration // How long a poll may take; DefaultTimeout if zero.
+	Retry    time.Duration // How long to wait after an error; DefaultRetry if zero.
+
+	addr, socket func() string
+	poll         PollFunc // If set, used instead of polling chronyd.
+
+	mu     sync.Mutex
+	latest *Snapshot
+	subs   map[chan *Snapshot]struct{}
+}
+
+// New returns a Monitor that polls chronyd at the address that addr returns.  addr is called
+// before each poll, and the monitor reconnects when its result changes.  socket returns the path
+// of chronyd's Unix socket, for the reports that need it; they're left out if it's nil or returns
+// "".
+func New(addr, socket func() string) *Monitor {
+	return &Monitor{addr: addr, socket: socket}
+}
+
+// PollFunc takes one snapshot within timeout.
+type PollFunc func(timeout time.Duration) (*Snapshot, error)
+
+// NewPoller returns a Monitor that takes each snapshot by calling poll, so that other time
+// daemons can be monitored like chronyd.
+func NewPoller(poll PollFunc) *Monitor {
+	return &Monitor{poll: poll}
+}
+
+// Latest returns the most recent snapshot, or nil if chronyd hasn't been polled yet.
+func (m *Monitor) Latest() *Snapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.latest
+}
+
+// Subscribe returns a channel that receives each new snapshot, starting with the latest one if
+// there is one, and a function that cancels the subscription.  A subscriber that falls behind
+// misses the snapshots in between, but always receives the newest one.
+func (m *Monitor) Subscribe() (<-chan *Snapshot, func()) {
+	ch := make(chan *Snapshot, 1)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.subs == nil {
+		m.subs = make(map[chan *Snapshot]struct{})
+	}
+	m.subs[ch] = struct{}{}
+	if m.latest != nil {
+		ch <- m.latest
+	}
+	return ch, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.subs, ch)
+	}
+}
+
+// publish makes s the latest snapshot and sends it to the subscribers.
+func (m *Monitor) publish(s *Snapshot) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latest = s
+	for ch := range m.subs {
+		// Replace a snapshot that the subscriber hasn't read yet.
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- s
+	}
+}
+
+// orDefault returns d, or def if d isn't positive.
+func orDefault(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
+
+// Run polls chronyd until the context is cancelled.  Failed polls are published as snapshots
+// with Err set, and are retried on a new connection.
+func (m *Monitor) Run(ctx context.Context) error {
+	for {
+		err := m.monitor(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil {
+			continue
+		}
+		log.Printf("chronymon: %v", err)
+		m.publish(&Snapshot{Time: time.Now(), Err: err})
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(orDefault(m.Retry, DefaultRetry)):
+		}
+	}
+}
+
+// monitor polls chronyd over one connection until something goes wrong, or returns nil when the
+// address changes.
+func (m *Monitor) monitor(ctx context.Context) error {
+	timeout := orDefault(m.Timeout, DefaultTimeout)
+	if m.poll != nil {
+		return m.monitorPoller(ctx, timeout)
+	}
+	addr := m.addr()
+	conn, err := net.DialTimeout("udp", addr, timeout)
+	if err != nil {
+		return fmt.Errorf("dial %s: %w", addr, err)
+	}
+	defer conn.Close()
+	c := &chrony.Client{Sequence: 1, Connection: conn}
+	for {
+		if newAddr := m.addr(); newAddr != addr {
+			log.Printf("chronymon: address changed from %s to %s; reconnecting", addr, newAddr)
+			return nil
+		}
+		s, err := poll(conn, c, timeout)
+		if err != nil {
+			return err
+		}
+		if m.socket != nil {
+			if socket := m.socket(); socket != "" {
+				if err := pollPrivileged(socket, s, timeout); err != nil {
+					s.Warnings = append(s.Warnings, err)
+				}
+			}
+		}
+		m.publish(s)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(orDefault(m.Interval, DefaultInterval)):
+		}
+	}
+}
+
+// monitorPoller takes snapshots with the Monitor's PollFunc until one fails.
+func (m *Monitor) monitorPoller(ctx context.Context, timeout time.Duration) error {
+	for {
+		s, err := m.poll(timeout)
+		if err != nil {
+			return err
+		}
+		m.publish(s)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(orDefault(m.Interval, DefaultInterval)):
+		}
+	}
+}
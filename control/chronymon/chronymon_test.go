@@ -0,0 +1,761 @@
+package chronymon_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/chrony"
+	"github.com/jrockway/beaglebone-gps-clock/control/chronymon"
+	"github.com/jrockway/beaglebone-gps-clock/control/chronymon/chronytest"
+)
+
+func TestSourceName(t *testing.T) {
+	testData := []struct {
+		in   net.IP
+		want string
+	}{
+		{nil, "<nil>"},
+		{net.IPv4(0, 0, 0, 0), "0.0.0.0"},
+		{net.IPv6interfacelocalallnodes, "ff01::1"},
+		{net.IPv4(1, 2, 3, 4), "1.2.3.4"},
+		{net.IPv4('A', 0, 0, 0), "A"},
+		{net.IPv4(80, 80, 83, 0), "PPS"},
+		{net.IPv4(71, 80, 83, 0), "GPS"},
+		{net.IPv4(82, 84, 67, 0), "RTC"},
+		{net.IPv4(80, 72, 67, 48), "PHC0"},
+	}
+
+	for _, test := range testData {
+		t.Run(test.in.String(), func(t *testing.T) {
+			got := chronymon.SourceName(test.in)
+			if want := test.want; got != want {
+				t.Errorf("convert refid (%s):\n  got: %v\n want: %v", []byte(test.in), got, want)
+			}
+		})
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	m := chronymon.New(func() string { return "localhost:0" }, nil)
+	if got := m.Latest(); got != nil {
+		t.Errorf("latest before any polls:\n  got: %v\n want: nil", got)
+	}
+	first, second := &chronymon.Snapshot{Time: time.Unix(1, 0)}, &chronymon.Snapshot{Time: time.Unix(2, 0)}
+
+	early, cancelEarly := m.Subscribe()
+	chronymon.Publish(m, first)
+	late, cancelLate := m.Subscribe()
+	if got, want := <-late, first; got != want {
+		t.Errorf("late subscriber's first snapshot:\n  got: %v\n want: %v", got, want)
+	}
+
+	// early hasn't read first yet, so it should be replaced.
+	chronymon.Publish(m, second)
+	if got, want := <-early, second; got != want {
+		t.Errorf("slow subscriber's snapshot:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := <-late, second; got != want {
+		t.Errorf("late subscriber's second snapshot:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := m.Latest(), second; got != want {
+		t.Errorf("latest:\n  got: %v\n want: %v", got, want)
+	}
+
+	cancelEarly()
+	cancelLate()
+	chronymon.Publish(m, first)
+	select {
+	case s := <-early:
+		t.Errorf("cancelled subscription received %v", s)
+	default:
+	}
+}
+
+func TestSynchronized(t *testing.T) {
+	testData := []struct {
+		name string
+		in   chronymon.Snapshot
+		want bool
+	}{
+		{"synced", chronymon.Snapshot{Tracking: chrony.Tracking{Stratum: 1}}, true},
+		{"unsynced", chronymon.Snapshot{Tracking: chrony.Tracking{Stratum: 1, LeapStatus: chronymon.LeapUnsynchronized}}, false},
+		{"stratum 0", chronymon.Snapshot{}, false},
+		{"error", chronymon.Snapshot{Err: errors.New("oops"), Tracking: chrony.Tracking{Stratum: 1}}, false},
+	}
+	for _, test := range testData {
+		if got, want := test.in.Synchronized(), test.want; got != want {
+			t.Errorf("%s:\n  got: %v\n want: %v", test.name, got, want)
+		}
+	}
+}
+
+// newFakeChronyd starts a fake chronyd with a GPS reference clock and one NTP server.
+func newFakeChronyd(t *testing.T) *chronytest.Server {
+	t.Helper()
+	s, err := chronytest.NewServer()
+	if err != nil {
+		t.Fatalf("start fake chronyd: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	s.SetTracking(chrony.Tracking{
+		RefID:      0x50505300,
+		IPAddr:     net.IPv4(127, 127, 1, 0),
+		Stratum:    1,
+		RefTime:    time.Unix(1633046400, 500),
+		LastOffset: -0.000001,
+		RMSOffset:  0.0000025,
+		FreqPPM:    -12.5,
+	})
+	s.SetSources([]chrony.SourceData{
+		{IPAddr: net.IPv4(80, 80, 83, 0), Stratum: 0, State: chrony.SourceStateSync, Mode: chrony.SourceModeRef, Reachability: 0o377, Poll: 4, LatestMeas: 0.0000003},
+		{IPAddr: net.IPv4(192, 0, 2, 1), Stratum: 2, State: chrony.SourceStateCandidate, Reachability: 0o17, Poll: 6, LatestMeas: -0.0021},
+	}, []chrony.SourceStats{
+		{RefID: 0x50505300, NSamples: 16, SpanSeconds: 240, StandardDeviation: 0.0000001},
+		{RefID: 0xc0000201, IPAddr: net.IPv4(192, 0, 2, 1), NSamples: 8, SpanSeconds: 512, EstimatedOffset: -0.002},
+	})
+	s.SetNTPData(chrony.NTPData{RemoteAddr: net.IPv4(192, 0, 2, 1), RemotePort: 123, Version: 4, Mode: 4, Stratum: 1, Offset: -0.002, PeerDelay: 0.015, TotalRXCount: 42})
+	s.SetActivity(chronymon.Activity{Online: 1, Offline: 1})
+	s.SetRTC(&chronymon.RTC{RefTime: time.Unix(1633046000, 0), Samples: 5, Runs: 3, Span: time.Hour, SecondsFast: -0.25, GainRatePPM: 1.5})
+	s.SetServerStats(chrony.ServerStats2{NTPHits: 1000, CMDHits: 10, NTPDrops: 1})
+	return s
+}
+
+// approx reports whether a and b agree to the precision of chrony's float format.
+func approx(a, b float64) bool {
+	return math.Abs(a-b) <= math.Abs(b)*1e-6
+}
+
+func TestPoll(t *testing.T) {
+	fake := newFakeChronyd(t)
+	s, err := chronymon.Poll(fake.Addr, fake.Socket)
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	tr := s.Tracking
+	if tr.RefID != 0x50505300 || tr.Stratum != 1 || !tr.IPAddr.Equal(net.IPv4(127, 127, 1, 0)) || !tr.RefTime.Equal(time.Unix(1633046400, 500)) {
+		t.Errorf("tracking: %+v", tr)
+	}
+	if !approx(tr.LastOffset, -0.000001) || !approx(tr.RMSOffset, 0.0000025) || !approx(tr.FreqPPM, -12.5) {
+		t.Errorf("tracking floats: offset %v, rms offset %v, freq %v", tr.LastOffset, tr.RMSOffset, tr.FreqPPM)
+	}
+	if got, want := len(s.Sources), 2; got != want {
+		t.Fatalf("number of sources:\n  got: %v\n want: %v", got, want)
+	}
+	for i, src := range s.Sources {
+		if got, want := src.Index, i; got != want {
+			t.Errorf("source %d: index:\n  got: %v\n want: %v", i, got, want)
+		}
+	}
+	ntp := s.Sources[1]
+	if !ntp.Data.IPAddr.Equal(net.IPv4(192, 0, 2, 1)) || ntp.Data.State != chrony.SourceStateCandidate || ntp.Data.Reachability != 0o17 || ntp.Data.Poll != 6 {
+		t.Errorf("source data: %+v", ntp.Data)
+	}
+	if !approx(ntp.Data.LatestMeas, -0.0021) {
+		t.Errorf("latest measurement:\n  got: %v\n want: %v", ntp.Data.LatestMeas, -0.0021)
+	}
+	if ntp.Stats.RefID != 0xc0000201 || ntp.Stats.NSamples != 8 || ntp.Stats.SpanSeconds != 512 || !approx(ntp.Stats.EstimatedOffset, -0.002) {
+		t.Errorf("source stats: %+v", ntp.Stats)
+	}
+	if !s.Synchronized() {
+		t.Error("snapshot should be synchronized")
+	}
+
+	if len(s.Warnings) > 0 {
+		t.Errorf("unexpected warnings: %v", s.Warnings)
+	}
+	if s.Sources[0].NTP != nil {
+		t.Errorf("reference clock has ntpdata: %+v", s.Sources[0].NTP)
+	}
+	if ntp := s.Sources[1].NTP; ntp == nil || ntp.RemotePort != 123 || ntp.TotalRXCount != 42 || !approx(ntp.PeerDelay, 0.015) {
+		t.Errorf("ntpdata: %+v", ntp)
+	}
+	if got, want := s.Activity, (&chronymon.Activity{Online: 1, Offline: 1}); !reflect.DeepEqual(got, want) {
+		t.Errorf("activity:\n  got: %+v\n want: %+v", got, want)
+	}
+	if rtc := s.RTC; rtc == nil || !rtc.RefTime.Equal(time.Unix(1633046000, 0)) || rtc.Samples != 5 || rtc.Runs != 3 || rtc.Span != time.Hour || !approx(rtc.SecondsFast, -0.25) || !approx(rtc.GainRatePPM, 1.5) {
+		t.Errorf("rtcdata: %+v", rtc)
+	}
+	if got, want := s.ServerStats, (&chrony.ServerStats2{NTPHits: 1000, CMDHits: 10, NTPDrops: 1}); !reflect.DeepEqual(got, want) {
+		t.Errorf("serverstats:\n  got: %+v\n want: %+v", got, want)
+	}
+
+	want := []uint16{
+		chronytest.CommandTracking, chronytest.CommandSources,
+		chronytest.CommandSourceData, chronytest.CommandSourceStats,
+		chronytest.CommandSourceData, chronytest.CommandSourceStats,
+		chronytest.CommandSources,
+		chronytest.CommandActivity, chronytest.CommandRTCReport,
+		chronytest.CommandServerStats, chronytest.CommandNTPData, chronytest.CommandClients,
+	}
+	if got := fake.Requests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("requests:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func TestOptionalReports(t *testing.T) {
+	fake := newFakeChronyd(t)
+	fake.SetRTC(nil)
+	fake.Script(chronytest.CommandActivity, chronytest.Response{Status: chronytest.StatusFailed})
+
+	// Without the Unix socket, there's no serverstats or ntpdata, and the RTC isn't tracked.
+	s, err := chronymon.Poll(fake.Addr, "")
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if s.Activity != nil || s.RTC != nil || s.ServerStats != nil || s.Sources[1].NTP != nil {
+		t.Errorf("unexpected reports: activity %v, rtc %v, serverstats %v, ntpdata %v", s.Activity, s.RTC, s.ServerStats, s.Sources[1].NTP)
+	}
+	if got, want := fmt.Sprint(s.Warnings), "[get activity: got status FAILED]"; got != want {
+		t.Errorf("warnings:\n  got: %v\n want: %v", got, want)
+	}
+
+	// A bad socket path only loses the privileged reports.
+	fake.SetNTPData()
+	s, err = chronymon.Poll(fake.Addr, fake.Addr)
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if got, want := len(s.Warnings), 1; got != want || !strings.Contains(s.Warnings[0].Error(), "dial") {
+		t.Errorf("warnings with the wrong socket: %v", s.Warnings)
+	}
+	if s.Activity == nil {
+		t.Error("activity is missing")
+	}
+	s, err = chronymon.Poll(fake.Addr, fake.Socket)
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Warnings), "[source 1: get ntpdata: got status NOSUCHSOURCE]"; got != want {
+		t.Errorf("warnings:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func TestClients(t *testing.T) {
+	fake := newFakeChronyd(t)
+	var clients []chronymon.Client
+	for i := 0; i < 10; i++ {
+		clients = append(clients, chronymon.Client{
+			IP:          net.IPv4(192, 0, 2, byte(10+i)),
+			NTPHits:     uint32(100 * i),
+			NTPDrops:    uint16(i),
+			NTPInterval: 6,
+			CmdInterval: 127,
+			LastNTP:     time.Duration(i) * time.Second,
+			LastNKE:     -1,
+			LastCmd:     -1,
+		})
+	}
+	clients[9].IP = net.ParseIP("2001:db8::1")
+	clients[9].CmdHits = 3
+	clients[9].CmdInterval = -2
+	clients[9].LastCmd = time.Minute
+	fake.SetClients(clients)
+
+	s, err := chronymon.Poll(fake.Addr, fake.Socket)
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if len(s.Warnings) > 0 {
+		t.Errorf("unexpected warnings: %v", s.Warnings)
+	}
+	if got, want := len(s.Clients), len(clients); got != want {
+		t.Fatalf("number of clients:\n  got: %v\n want: %v", got, want)
+	}
+	for i, c := range s.Clients {
+		if got, want := c.IP.String(), clients[i].IP.String(); got != want {
+			t.Errorf("client %d: ip:\n  got: %v\n want: %v", i, got, want)
+		}
+		c.IP = clients[i].IP
+		if got, want := c, clients[i]; !reflect.DeepEqual(got, want) {
+			t.Errorf("client %d:\n  got: %+v\n want: %+v", i, got, want)
+		}
+	}
+	var pages int
+	for _, r := range fake.Requests() {
+		if r == chronytest.CommandClients {
+			pages++
+		}
+	}
+	if got, want := pages, 2; got != want {
+		t.Errorf("clients requests:\n  got: %v\n want: %v", got, want)
+	}
+
+	// A failure partway through loses the clients, but not the rest of the snapshot.
+	fake.Script(chronytest.CommandClients, chronytest.Response{}, chronytest.Response{Status: chronytest.StatusFailed})
+	s, err = chronymon.Poll(fake.Addr, fake.Socket)
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Warnings), "[get clients: clients from index 8: got status FAILED]"; got != want {
+		t.Errorf("warnings:\n  got: %v\n want: %v", got, want)
+	}
+	if s.Clients != nil || s.ServerStats == nil {
+		t.Errorf("clients %v, serverstats %v", s.Clients, s.ServerStats)
+	}
+}
+
+func TestClientRates(t *testing.T) {
+	testData := []struct {
+		name     string
+		client   chronymon.Client
+		interval time.Duration
+		known    bool
+		rate     float64
+	}{
+		{name: "every 64s", client: chronymon.Client{NTPInterval: 6}, interval: 64 * time.Second, known: true, rate: 1.0 / 64},
+		{name: "4 per second", client: chronymon.Client{NTPInterval: -2}, interval: 250 * time.Millisecond, known: true, rate: 4},
+		{name: "unknown interval", client: chronymon.Client{NTPInterval: 127}},
+		{name: "no ntp packets", client: chronymon.Client{NTPInterval: 6, LastNTP: -1}, interval: 64 * time.Second, known: true},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			interval, known := chronymon.Interval(test.client.NTPInterval)
+			if interval != test.interval || known != test.known {
+				t.Errorf("interval:\n  got: %v, %v\n want: %v, %v", interval, known, test.interval, test.known)
+			}
+			if got, want := test.client.NTPRate(), test.rate; got != want {
+				t.Errorf("rate:\n  got: %v\n want: %v", got, want)
+			}
+		})
+	}
+}
+
+func TestPollErrors(t *testing.T) {
+	testData := []struct {
+		name    string
+		setup   func(s *chronytest.Server)
+		wantErr string
+	}{
+		{
+			name: "tracking failed",
+			setup: func(s *chronytest.Server) {
+				s.Script(chronytest.CommandTracking, chronytest.Response{Status: chronytest.StatusFailed})
+			},
+			wantErr: "get tracking info: got status FAILED",
+		},
+		{
+			name: "tracking reply is sources",
+			setup: func(s *chronytest.Server) {
+				s.Script(chronytest.CommandTracking, chronytest.Response{As: chronytest.CommandSources})
+			},
+			wantErr: "tracking reply was of unexpected type *chrony.ReplySources",
+		},
+		{
+			name: "sources unauthorized",
+			setup: func(s *chronytest.Server) {
+				s.Script(chronytest.CommandSources, chronytest.Response{Status: chronytest.StatusUnauth})
+			},
+			wantErr: "get sources: got status UNAUTH",
+		},
+		{
+			name: "sources reply is tracking",
+			setup: func(s *chronytest.Server) {
+				s.Script(chronytest.CommandSources, chronytest.Response{As: chronytest.CommandTracking})
+			},
+			wantErr: "sources reply was of unexpected type *chrony.ReplyTracking",
+		},
+		{
+			name: "source data reply is sourcestats",
+			setup: func(s *chronytest.Server) {
+				s.Script(chronytest.CommandSourceData, chronytest.Response{}, chronytest.Response{As: chronytest.CommandSourceStats})
+			},
+			wantErr: "source 1: source data reply was of unexpected type *chrony.ReplySourceStats",
+		},
+		{
+			name: "source went away",
+			setup: func(s *chronytest.Server) {
+				s.SetSources([]chrony.SourceData{{}, {}}, []chrony.SourceStats{{}})
+			},
+			wantErr: "source 1: get sourcestats: got status NOSUCHSOURCE",
+		},
+		{
+			name: "sources keep changing",
+			setup: func(s *chronytest.Server) {
+				s.SetSources([]chrony.SourceData{{IPAddr: net.IPv4(80, 80, 83, 0)}, {IPAddr: net.IPv4(192, 0, 2, 1)}}, []chrony.SourceStats{{RefID: 0x50505300}, {IPAddr: net.IPv4(192, 0, 2, 2)}})
+			},
+			wantErr: "sources changed while polling: no sourcestats for 192.0.2.1",
+		},
+		{
+			name: "sourcestats reply is source data",
+			setup: func(s *chronytest.Server) {
+				s.Script(chronytest.CommandSourceStats, chronytest.Response{As: chronytest.CommandSourceData})
+			},
+			wantErr: "source 0: sourcestats reply was of unexpected type *chrony.ReplySourceData",
+		},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			fake := newFakeChronyd(t)
+			test.setup(fake)
+			_, err := chronymon.Poll(fake.Addr, fake.Socket)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("poll: unexpected error:\n  got: %v\n want: ...%s...", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestPollReorderedSources(t *testing.T) {
+	pps := chrony.SourceData{IPAddr: net.IPv4(80, 80, 83, 0), Mode: chrony.SourceModeRef}
+	ntp := chrony.SourceData{IPAddr: net.IPv4(192, 0, 2, 1)}
+	ntp6 := chrony.SourceData{IPAddr: net.ParseIP("2001:db8::1")}
+	ppsStats := chrony.SourceStats{RefID: 0x50505300, NSamples: 16}
+	ntpStats := chrony.SourceStats{RefID: 0xc0000201, IPAddr: net.IPv4(192, 0, 2, 1), NSamples: 8}
+	ntp6Stats := chrony.SourceStats{RefID: 0x12345678, IPAddr: net.ParseIP("2001:db8::1"), NSamples: 4}
+
+	// sourceStats describes which sourcestats ended up with which source.
+	sourceStats := func(s *chronymon.Snapshot) string {
+		var parts []string
+		for _, src := range s.Sources {
+			parts = append(parts, fmt.Sprintf("%d:%s=%d", src.Index, chronymon.SourceName(src.Data.IPAddr), src.Stats.NSamples))
+		}
+		return strings.Join(parts, " ")
+	}
+
+	testData := []struct {
+		name         string
+		setup        func(s *chronytest.Server)
+		want         string
+		wantRequests int // How many sourcedata requests the poll took.
+	}{
+		{
+			name: "in order",
+			setup: func(s *chronytest.Server) {
+				s.SetSources([]chrony.SourceData{pps, ntp, ntp6}, []chrony.SourceStats{ppsStats, ntpStats, ntp6Stats})
+			},
+			want:         "0:PPS=16 1:192.0.2.1=8 2:2001:db8::1=4",
+			wantRequests: 3,
+		},
+		{
+			name: "different orders",
+			setup: func(s *chronytest.Server) {
+				s.SetSources([]chrony.SourceData{pps, ntp, ntp6}, []chrony.SourceStats{ntp6Stats, ppsStats, ntpStats})
+			},
+			want:         "0:PPS=16 1:192.0.2.1=8 2:2001:db8::1=4",
+			wantRequests: 3,
+		},
+		{
+			name: "reordered during the poll",
+			setup: func(s *chronytest.Server) {
+				s.SetSources([]chrony.SourceData{pps, ntp}, []chrony.SourceStats{ppsStats, ntpStats})
+				// After tracking, sources and the first sourcedata.
+				s.SetSourcesAfter(3, []chrony.SourceData{ntp, pps}, []chrony.SourceStats{ntpStats, ppsStats})
+			},
+			want:         "0:192.0.2.1=8 1:PPS=16",
+			wantRequests: 4,
+		},
+		{
+			name: "added during the poll",
+			setup: func(s *chronytest.Server) {
+				s.SetSources([]chrony.SourceData{pps, ntp}, []chrony.SourceStats{ppsStats, ntpStats})
+				s.SetSourcesAfter(4, []chrony.SourceData{ntp6, pps, ntp}, []chrony.SourceStats{ntp6Stats, ppsStats, ntpStats})
+			},
+			want:         "0:2001:db8::1=4 1:PPS=16 2:192.0.2.1=8",
+			wantRequests: 5,
+		},
+		{
+			name: "removed during the poll",
+			setup: func(s *chronytest.Server) {
+				s.SetSources([]chrony.SourceData{pps, ntp, ntp6}, []chrony.SourceStats{ppsStats, ntpStats, ntp6Stats})
+				// After the first source; asking for the third then finds no such source.
+				s.SetSourcesAfter(4, []chrony.SourceData{pps, ntp}, []chrony.SourceStats{ppsStats, ntpStats})
+			},
+			want:         "0:PPS=16 1:192.0.2.1=8",
+			wantRequests: 5,
+		},
+		{
+			name: "removed after being read",
+			setup: func(s *chronytest.Server) {
+				s.SetSources([]chrony.SourceData{pps, ntp}, []chrony.SourceStats{ppsStats, ntpStats})
+				// After every source; only the final count notices.
+				s.SetSourcesAfter(6, []chrony.SourceData{pps}, []chrony.SourceStats{ppsStats})
+			},
+			want:         "0:PPS=16",
+			wantRequests: 3,
+		},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			fake := newFakeChronyd(t)
+			test.setup(fake)
+			s, err := chronymon.Poll(fake.Addr, "")
+			if err != nil {
+				t.Fatalf("poll: %v", err)
+			}
+			if got, want := sourceStats(s), test.want; got != want {
+				t.Errorf("sources:\n  got: %v\n want: %v", got, want)
+			}
+			var n int
+			for _, r := range fake.Requests() {
+				if r == chronytest.CommandSourceData {
+					n++
+				}
+			}
+			if got, want := n, test.wantRequests; got != want {
+				t.Errorf("sourcedata requests:\n  got: %v\n want: %v", got, want)
+			}
+		})
+	}
+}
+
+// next waits for the next snapshot.
+func next(t *testing.T, ch <-chan *chronymon.Snapshot) *chronymon.Snapshot {
+	t.Helper()
+	select {
+	case s := <-ch:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a snapshot")
+		return nil
+	}
+}
+
+func TestRun(t *testing.T) {
+	first, second := newFakeChronyd(t), newFakeChronyd(t)
+	second.SetTracking(chrony.Tracking{RefID: 0xc0000201, Stratum: 3})
+	first.Script(chronytest.CommandTracking, chronytest.Response{}, chronytest.Response{Drop: true})
+
+	var addrMu sync.Mutex
+	addr := first.Addr
+	m := chronymon.New(func() string {
+		addrMu.Lock()
+		defer addrMu.Unlock()
+		return addr
+	}, nil)
+	m.Interval, m.Timeout, m.Retry = 10*time.Millisecond, 100*time.Millisecond, 10*time.Millisecond
+	snapshots, unsubscribe := m.Subscribe()
+	defer unsubscribe()
+	ctx, cancel := context.WithCancel(context.Background())
+	doneCh := make(chan error)
+	go func() { doneCh <- m.Run(ctx) }()
+
+	if s := next(t, snapshots); s.Err != nil || s.Tracking.RefID != 0x50505300 {
+		t.Errorf("first poll: err %v, refid %x", s.Err, s.Tracking.RefID)
+	}
+	// The second tracking request is dropped, so the poll times out.
+	if s := next(t, snapshots); s.Err == nil || s.Synchronized() {
+		t.Errorf("dropped poll: err %v", s.Err)
+	}
+	// The monitor reconnects and carries on.
+	if s := next(t, snapshots); s.Err != nil || s.Tracking.RefID != 0x50505300 {
+		t.Errorf("poll after reconnecting: err %v, refid %x", s.Err, s.Tracking.RefID)
+	}
+
+	addrMu.Lock()
+	addr = second.Addr
+	addrMu.Unlock()
+	for {
+		s := next(t, snapshots)
+		if s.Err != nil {
+			t.Errorf("poll while changing address: %v", s.Err)
+		}
+		if s.Tracking.RefID == 0xc0000201 {
+			break
+		}
+	}
+	if got, want := m.Latest().Tracking.Stratum, uint16(3); got != want {
+		t.Errorf("latest stratum:\n  got: %v\n want: %v", got, want)
+	}
+
+	cancel()
+	if err := <-doneCh; !errors.Is(err, context.Canceled) {
+		t.Errorf("run: unexpected error: %v", err)
+	}
+}
+
+func TestRunPoller(t *testing.T) {
+	var calls int
+	m := chronymon.NewPoller(func(timeout time.Duration) (*chronymon.Snapshot, error) {
+		calls++
+		if timeout != 100*time.Millisecond {
+			t.Errorf("timeout:\n  got: %v\n want: %v", timeout, 100*time.Millisecond)
+		}
+		if calls == 2 {
+			return nil, errors.New("ntpd went away")
+		}
+		return &chronymon.Snapshot{Time: time.Now(), Tracking: chrony.Tracking{Stratum: uint16(calls)}}, nil
+	})
+	m.Interval, m.Timeout, m.Retry = 10*time.Millisecond, 100*time.Millisecond, 10*time.Millisecond
+	snapshots, unsubscribe := m.Subscribe()
+	defer unsubscribe()
+	ctx, cancel := context.WithCancel(context.Background())
+	doneCh := make(chan error)
+	go func() { doneCh <- m.Run(ctx) }()
+
+	if s := next(t, snapshots); s.Err != nil || s.Tracking.Stratum != 1 {
+		t.Errorf("first poll: err %v, stratum %v", s.Err, s.Tracking.Stratum)
+	}
+	if s := next(t, snapshots); s.Err == nil || s.Err.Error() != "ntpd went away" {
+		t.Errorf("failed poll: err %v", s.Err)
+	}
+	if s := next(t, snapshots); s.Err != nil || s.Tracking.Stratum != 3 {
+		t.Errorf("poll after failure: err %v, stratum %v", s.Err, s.Tracking.Stratum)
+	}
+	cancel()
+	if err := <-doneCh; !errors.Is(err, context.Canceled) {
+		t.Errorf("run: unexpected error: %v", err)
+	}
+}
+
+func TestControl(t *testing.T) {
+	fake := newFakeChronyd(t)
+	ntp := net.IPv4(192, 0, 2, 1)
+	testData := []struct {
+		action  chronymon.Action
+		source  net.IP
+		want    *chronytest.Control
+		wantErr string
+	}{
+		{action: chronymon.ActionBurst, want: &chronytest.Control{Command: chronytest.CommandBurst, Good: 4, Total: 8}},
+		{action: chronymon.ActionBurst, source: ntp, want: &chronytest.Control{Command: chronytest.CommandBurst, Source: ntp, Good: 4, Total: 8}},
+		{action: chronymon.ActionMakeStep, want: &chronytest.Control{Command: chronytest.CommandMakeStep}},
+		{action: chronymon.ActionOnline, source: ntp, want: &chronytest.Control{Command: chronytest.CommandOnline, Source: ntp}},
+		{action: chronymon.ActionOffline, want: &chronytest.Control{Command: chronytest.CommandOffline}},
+		{action: chronymon.ActionReselect, want: &chronytest.Control{Command: chronytest.CommandReselect}},
+		{action: chronymon.ActionOffline, source: net.ParseIP("2001:db8::1"), wantErr: "offline: got status NOSUCHSOURCE"},
+		{action: chronymon.ActionMakeStep, source: ntp, wantErr: "makestep does not take a source"},
+		{action: "shutdown", wantErr: `unknown action "shutdown"`},
+	}
+	for _, test := range testData {
+		t.Run(fmt.Sprintf("%s %v", test.action, test.source), func(t *testing.T) {
+			before := len(fake.Controls())
+			err := chronymon.Control(fake.Socket, test.action, test.source, time.Second)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Errorf("error:\n  got: %v\n want: %v", err, test.wantErr)
+				}
+				if got := fake.Controls(); len(got) != before {
+					t.Errorf("unexpected control: %+v", got[before:])
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("control: %v", err)
+			}
+			got := fake.Controls()
+			if len(got) != before+1 {
+				t.Fatalf("controls:\n  got: %+v\n want: one more than %d", got, before)
+			}
+			if c := got[before]; c.Command != test.want.Command || !c.Source.Equal(test.want.Source) || c.Good != test.want.Good || c.Total != test.want.Total {
+				t.Errorf("control:\n  got: %+v\n want: %+v", c, test.want)
+			}
+		})
+	}
+
+	if err := chronymon.Control(fake.Addr, chronymon.ActionReselect, nil, time.Second); err == nil || !strings.Contains(err.Error(), "dial") {
+		t.Errorf("control without a socket: %v", err)
+	}
+}
+
+func TestChanges(t *testing.T) {
+	pps := chrony.SourceData{IPAddr: net.IPv4(80, 80, 83, 0), Mode: chrony.SourceModeRef, State: chrony.SourceStateSync, Reachability: 0o377}
+	ntp := chrony.SourceData{IPAddr: net.IPv4(192, 0, 2, 1), State: chrony.SourceStateCandidate, Reachability: 0o377}
+	pool := chrony.SourceData{IPAddr: net.IPv4(192, 0, 2, 2), State: chrony.SourceStateCandidate, Reachability: 0o17}
+	snapshot := func(stratum, leap uint16, sources ...chrony.SourceData) *chronymon.Snapshot {
+		s := &chronymon.Snapshot{Time: time.Unix(1633046400, 0), Tracking: chrony.Tracking{Stratum: stratum, LeapStatus: leap}}
+		for i, d := range sources {
+			s.Sources = append(s.Sources, chronymon.Source{Index: i, Data: d})
+		}
+		return s
+	}
+	with := func(d chrony.SourceData, state chrony.SourceStateType, reach uint16) chrony.SourceData {
+		d.State, d.Reachability = state, reach
+		return d
+	}
+	base := snapshot(1, 0, pps, ntp, pool)
+
+	testData := []struct {
+		name string
+		cur  *chronymon.Snapshot
+		want []string
+	}{
+		{name: "nothing", cur: snapshot(1, 0, pps, ntp, pool)},
+		{name: "reordered", cur: snapshot(1, 0, pool, pps, ntp)},
+		{name: "reachability shifts", cur: snapshot(1, 0, pps, with(ntp, chrony.SourceStateCandidate, 0o376), pool)},
+		{
+			name: "pps lost",
+			cur:  snapshot(2, 0, with(pps, chrony.SourceStateUnreach, 0), with(ntp, chrony.SourceStateSync, 0o377), pool),
+			want: []string{
+				"stratum: stratum changed from 1 to 2",
+				"selected: selected source changed from PPS to 192.0.2.1",
+				"state: PPS changed from selected to not selectable",
+				"reachability: PPS became unreachable",
+			},
+		},
+		{
+			name: "falseticker",
+			cur:  snapshot(1, 0, pps, with(ntp, chrony.SourceStateFalseTicket, 0o377), pool),
+			want: []string{"state: 192.0.2.1 changed from combined to falseticker"},
+		},
+		{
+			name: "unsynchronized",
+			cur:  snapshot(0, 3, with(pps, chrony.SourceStateUnreach, 0o377), ntp, pool),
+			want: []string{
+				"stratum: stratum changed from 1 to 0",
+				"leap: leap status changed from Normal to Unsynchronized",
+				"selected: selected source changed from PPS to none",
+				"state: PPS changed from selected to not selectable",
+			},
+		},
+		{
+			name: "sources replaced",
+			cur:  snapshot(1, 0, pps, ntp, chrony.SourceData{IPAddr: net.ParseIP("2001:db8::1"), State: chrony.SourceStateUnreach}),
+			want: []string{
+				"added: 2001:db8::1 was added",
+				"removed: 192.0.2.2 was removed",
+			},
+		},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, e := range chronymon.Changes(base, test.cur) {
+				if !e.Time.Equal(test.cur.Time) {
+					t.Errorf("event %v: time:\n  got: %v\n want: %v", e, e.Time, test.cur.Time)
+				}
+				got = append(got, fmt.Sprintf("%s: %v", e.Kind, e))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("events:\n  got: %q\n want: %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDetector(t *testing.T) {
+	fake := newFakeChronyd(t)
+	var d chronymon.Detector
+	poll := func() []chronymon.Event {
+		t.Helper()
+		s, err := chronymon.Poll(fake.Addr, "")
+		if err != nil {
+			t.Fatalf("poll: %v", err)
+		}
+		return d.Next(s)
+	}
+	if events := poll(); len(events) > 0 {
+		t.Errorf("first snapshot: unexpected events %v", events)
+	}
+	if events := d.Next(&chronymon.Snapshot{Err: errors.New("chronyd went away")}); len(events) > 0 {
+		t.Errorf("failed snapshot: unexpected events %v", events)
+	}
+	fake.SetSources([]chrony.SourceData{
+		{IPAddr: net.IPv4(80, 80, 83, 0), State: chrony.SourceStateFalseTicket, Mode: chrony.SourceModeRef, Reachability: 0o377},
+		{IPAddr: net.IPv4(192, 0, 2, 1), State: chrony.SourceStateSync, Reachability: 0o17},
+	}, []chrony.SourceStats{{RefID: 0x50505300}, {RefID: 0xc0000201, IPAddr: net.IPv4(192, 0, 2, 1)}})
+	events := poll()
+	if got, want := fmt.Sprint(events), "[selected source changed from PPS to 192.0.2.1 PPS changed from selected to falseticker]"; got != want {
+		t.Errorf("events:\n  got: %v\n want: %v", got, want)
+	}
+	if events := poll(); len(events) > 0 {
+		t.Errorf("unchanged: unexpected events %v", events)
+	}
+}
@@ -0,0 +1,799 @@
+// Package chronytest provides a fake chronyd for tests.  It speaks enough of chronyd's command
+// protocol (cmdmon) to answer the requests that chronymon makes, with scripted failures.  Like
+// chronyd, it listens on both a UDP port and a Unix socket, and only answers privileged requests
+// on the socket.
+package chronytest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/chrony"
+	"github.com/jrockway/beaglebone-gps-clock/control/chronymon"
+)
+
+// Commands that the fake understands, numbered as in chrony's candm.h.
+const (
+	CommandOnline      uint16 = 1
+	CommandOffline     uint16 = 2
+	CommandBurst       uint16 = 3
+	CommandSources     uint16 = 14
+	CommandSourceData  uint16 = 15
+	CommandTracking    uint16 = 33
+	CommandSourceStats uint16 = 34
+	CommandRTCReport   uint16 = 35
+	CommandMakeStep    uint16 = 43
+	CommandActivity    uint16 = 44
+	CommandReselect    uint16 = 48
+	CommandServerStats uint16 = 54
+	CommandNTPData     uint16 = 57
+	CommandClients     uint16 = 68 // CLIENT_ACCESSES_BY_INDEX3.
+)
+
+// replyTypes are the reply types that go with each command.
+var replyTypes = map[uint16]uint16{
+	CommandOnline:      1,
+	CommandOffline:     1,
+	CommandBurst:       1,
+	CommandMakeStep:    1,
+	CommandReselect:    1,
+	CommandSources:     2,
+	CommandSourceData:  3,
+	CommandTracking:    5,
+	CommandSourceStats: 6,
+	CommandRTCReport:   7,
+	CommandActivity:    12,
+	CommandServerStats: 22,
+	CommandNTPData:     16,
+	CommandClients:     21,
+}
+
+// privileged are the commands that chronyd only accepts over its Unix socket.
+var privileged = map[uint16]bool{
+	CommandOnline:      true,
+	CommandOffline:     true,
+	CommandBurst:       true,
+	CommandMakeStep:    true,
+	CommandReselect:    true,
+	CommandServerStats: true,
+	CommandNTPData:     true,
+	CommandClients:     true,
+}
+
+// Status codes, from candm.h.
+const (
+	StatusSuccess      uint16 = 0
+	StatusFailed       uint16 = 1
+	StatusUnauth       uint16 = 2
+	StatusInvalid      uint16 = 3
+	StatusNoSuchSource uint16 = 4
+	StatusNoRTC        uint16 = 13
+)
+
+// Response overrides the fake's usual reply to one request.  The zero Response is the usual reply.
+type Response struct {
+	Status uint16 // If not StatusSuccess, reply with this status and no data.
+	As     uint16 // If set, reply as if the request had been this command.
+	Drop   bool   // Don't reply at all.
+}
+
+// Control is a control command that the server carried out.
+type Control struct {
+	Command uint16
+	Source  net.IP // For online, offline and burst; nil for all sources.
+	// For burst, the number of good measurements to wait for and the most to take.
+	Good, Total int32
+}
+
+// Server is a fake chronyd.
+type Server struct {
+	Addr   string // The UDP command port, like "127.0.0.1:12345".
+	Socket string // The path of the Unix socket.
+
+	conn, unix net.PacketConn
+	dir        string
+
+	mu          sync.Mutex
+	tracking    chrony.Tracking
+	data        []chrony.SourceData
+	stats       []chrony.SourceStats
+	next        *nextSources
+	ntp         []chrony.NTPData
+	activity    chronymon.Activity
+	rtc         *chronymon.RTC
+	serverStats chrony.ServerStats2
+	clients     []chronymon.Client
+	script      map[uint16][]Response
+	requests    []uint16
+	controls    []Control
+}
+
+// NewServer starts a fake chronyd that reports no sources and no RTC.  Call Close when done with
+// it.
+func NewServer() (*Server, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	dir, err := os.MkdirTemp("", "chronytest")
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("make socket directory: %w", err)
+	}
+	socket := filepath.Join(dir, "chronyd.sock")
+	unix, err := net.ListenPacket("unixgram", socket)
+	if err != nil {
+		conn.Close()
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("listen on unix socket: %w", err)
+	}
+	s := &Server{Addr: conn.LocalAddr().String(), Socket: socket, conn: conn, unix: unix, dir: dir, script: map[uint16][]Response{}}
+	go s.serve(conn, false)
+	go s.serve(unix, true)
+	return s, nil
+}
+
+// Close stops the server.
+func (s *Server) Close() error {
+	err := s.conn.Close()
+	if uerr := s.unix.Close(); err == nil {
+		err = uerr
+	}
+	if rerr := os.RemoveAll(s.dir); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// SetTracking changes the reply to tracking requests.
+func (s *Server) SetTracking(t chrony.Tracking) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tracking = t
+}
+
+// SetSources changes the sources that the server reports.  The number of sources is len(data);
+// stats is indexed separately, so that tests can make the two disagree.
+func (s *Server) SetSources(data []chrony.SourceData, stats []chrony.SourceStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data, s.stats = data, stats
+}
+
+// nextSources are sources that replace the current ones after some requests.
+type nextSources struct {
+	after int
+	data  []chrony.SourceData
+	stats []chrony.SourceStats
+}
+
+// SetSourcesAfter changes the sources, like SetSources, once the server has answered the given
+// number of further requests, to test what happens when chronyd's sources change during a poll.
+func (s *Server) SetSourcesAfter(requests int, data []chrony.SourceData, stats []chrony.SourceStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next = &nextSources{after: requests, data: data, stats: stats}
+}
+
+// SetNTPData changes the replies to ntpdata requests, which are matched to each entry's
+// RemoteAddr.
+func (s *Server) SetNTPData(ntp ...chrony.NTPData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ntp = ntp
+}
+
+// SetActivity changes the reply to activity requests.
+func (s *Server) SetActivity(a chronymon.Activity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.activity = a
+}
+
+// SetRTC changes the reply to rtcdata requests; nil means that chronyd isn't tracking the RTC.
+func (s *Server) SetRTC(rtc *chronymon.RTC) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rtc = rtc
+}
+
+// SetServerStats changes the reply to serverstats requests.
+func (s *Server) SetServerStats(stats chrony.ServerStats2) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.serverStats = stats
+}
+
+// SetClients changes the client access log.  A client whose LastNTP, LastNKE or LastCmd is
+// negative is reported as never having sent that kind of packet.
+func (s *Server) SetClients(clients []chronymon.Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients = clients
+}
+
+// Script queues responses for the next requests of the given command.  Once they've been used,
+// the server replies as usual.
+func (s *Server) Script(command uint16, responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script[command] = append(s.script[command], responses...)
+}
+
+// Controls returns the control commands that the server has carried out, in order.
+func (s *Server) Controls() []Control {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Control(nil), s.controls...)
+}
+
+// Requests returns the commands that the server has received, in order.
+func (s *Server) Requests() []uint16 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]uint16(nil), s.requests...)
+}
+
+// requestHead is the start of every request.
+type requestHead struct {
+	Version  uint8
+	PKTType  uint8
+	Res1     uint8
+	Res2     uint8
+	Command  uint16
+	Attempt  uint16
+	Sequence uint32
+	Pad1     uint32
+	Pad2     uint32
+}
+
+// replyHead is the start of every reply.
+type replyHead struct {
+	Version  uint8
+	PKTType  uint8
+	Res1     uint8
+	Res2     uint8
+	Command  uint16
+	Reply    uint16
+	Status   uint16
+	Pad1     uint16
+	Pad2     uint16
+	Pad3     uint16
+	Sequence uint32
+	Pad4     uint32
+	Pad5     uint32
+}
+
+// serve answers requests on conn; root is true for the Unix socket.
+func (s *Server) serve(conn net.PacketConn, root bool) {
+	buf := make([]byte, 1024)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		reply, ok := s.handle(buf[:n], root)
+		if !ok {
+			continue
+		}
+		if _, err := conn.WriteTo(reply, addr); err != nil {
+			// The client may have gone away already.
+			continue
+		}
+	}
+}
+
+// handle returns the reply to one request packet, and false if there shouldn't be one.
+func (s *Server) handle(req []byte, root bool) ([]byte, bool) {
+	r := bytes.NewReader(req)
+	var head requestHead
+	if err := binary.Read(r, binary.BigEndian, &head); err != nil || head.PKTType != 1 {
+		return nil, false
+	}
+	var index int32
+	var ip ipAddr
+	var clients clientsRequest
+	var match matchRequest
+	var burst burstRequest
+	switch head.Command {
+	case CommandSourceData, CommandSourceStats:
+		if err := binary.Read(r, binary.BigEndian, &index); err != nil {
+			return nil, false
+		}
+	case CommandNTPData:
+		if err := binary.Read(r, binary.BigEndian, &ip); err != nil {
+			return nil, false
+		}
+	case CommandClients:
+		if err := binary.Read(r, binary.BigEndian, &clients); err != nil {
+			return nil, false
+		}
+	case CommandOnline, CommandOffline:
+		if err := binary.Read(r, binary.BigEndian, &match); err != nil {
+			return nil, false
+		}
+	case CommandBurst:
+		if err := binary.Read(r, binary.BigEndian, &burst); err != nil {
+			return nil, false
+		}
+		match = burst.matchRequest
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, head.Command)
+	if n := s.next; n != nil {
+		if n.after == 0 {
+			s.data, s.stats, s.next = n.data, n.stats, nil
+		} else {
+			n.after--
+		}
+	}
+	var resp Response
+	if script := s.script[head.Command]; len(script) > 0 {
+		resp, s.script[head.Command] = script[0], script[1:]
+	}
+	if resp.Drop {
+		return nil, false
+	}
+
+	command := head.Command
+	if resp.As != 0 {
+		command = resp.As
+	}
+	var content interface{}
+	status := resp.Status
+	if privileged[head.Command] && !root {
+		status = StatusUnauth
+	}
+	switch command {
+	case CommandSources:
+		content = sourcesReply{NSources: uint32(len(s.data))}
+	case CommandTracking:
+		content = newTrackingReply(s.tracking)
+	case CommandSourceData:
+		if index < 0 || int(index) >= len(s.data) {
+			status = StatusNoSuchSource
+			break
+		}
+		content = newSourceDataReply(s.data[index])
+	case CommandSourceStats:
+		if index < 0 || int(index) >= len(s.stats) {
+			status = StatusNoSuchSource
+			break
+		}
+		content = newSourceStatsReply(s.stats[index])
+	case CommandRTCReport:
+		if s.rtc == nil {
+			status = StatusNoRTC
+			break
+		}
+		content = newRTCReply(*s.rtc)
+	case CommandActivity:
+		a := s.activity
+		content = activityReply{Online: a.Online, Offline: a.Offline, BurstOnline: a.BurstOnline, BurstOffline: a.BurstOffline, Unresolved: a.Unresolved}
+	case CommandServerStats:
+		content = s.serverStats
+	case CommandNTPData:
+		status = StatusNoSuchSource
+		for _, ntp := range s.ntp {
+			if newIPAddr(ntp.RemoteAddr) == ip {
+				status = resp.Status
+				content = newNTPDataReply(ntp)
+			}
+		}
+	case CommandClients:
+		content = newClientsReply(s.clients, clients)
+	case CommandOnline, CommandOffline, CommandBurst, CommandMakeStep, CommandReselect:
+		if status != StatusSuccess {
+			break
+		}
+		source, ok := s.match(match)
+		if !ok {
+			status = StatusNoSuchSource
+			break
+		}
+		s.controls = append(s.controls, Control{Command: command, Source: source, Good: burst.Good, Total: burst.Total})
+	default:
+		status = StatusInvalid
+	}
+
+	out := new(bytes.Buffer)
+	rh := replyHead{
+		Version:  head.Version,
+		PKTType:  2,
+		Command:  head.Command,
+		Reply:    replyTypes[command],
+		Status:   status,
+		Sequence: head.Sequence,
+	}
+	binary.Write(out, binary.BigEndian, rh) // nolint:errcheck
+	if status == StatusSuccess && content != nil {
+		binary.Write(out, binary.BigEndian, content) // nolint:errcheck
+	}
+	return out.Bytes(), true
+}
+
+// match returns the address of the source that a control request selects, or nil if it selects
+// all of them, and false if it selects a source that doesn't exist.
+func (s *Server) match(r matchRequest) (net.IP, bool) {
+	if r.Address.Family == 0 {
+		return nil, true
+	}
+	for _, d := range s.data {
+		if newIPAddr(d.IPAddr) == r.Address {
+			return d.IPAddr, true
+		}
+	}
+	return nil, false
+}
+
+// The rest of this file encodes replies in chronyd's wire format, and decodes the requests that
+// have arguments.
+
+// matchRequest selects the sources that an online, offline or burst request applies to.
+type matchRequest struct {
+	Mask    ipAddr
+	Address ipAddr
+}
+
+type burstRequest struct {
+	matchRequest
+	Good  int32
+	Total int32
+}
+
+type ipAddr struct {
+	IP     [16]uint8
+	Family uint16
+	Pad    uint16
+}
+
+func newIPAddr(ip net.IP) ipAddr {
+	var a ipAddr
+	switch {
+	case ip == nil:
+	case ip.To4() != nil:
+		copy(a.IP[:], ip.To4())
+		a.Family = 1
+	default:
+		copy(a.IP[:], ip.To16())
+		a.Family = 2
+	}
+	return a
+}
+
+type timeSpec struct {
+	SecHigh uint32
+	SecLow  uint32
+	Nsec    uint32
+}
+
+func newTimeSpec(t time.Time) timeSpec {
+	if t.IsZero() {
+		return timeSpec{}
+	}
+	sec := uint64(t.Unix())
+	return timeSpec{SecHigh: uint32(sec >> 32), SecLow: uint32(sec), Nsec: uint32(t.Nanosecond())}
+}
+
+// chronyFloat is chrony's 32-bit floating point format: a 7-bit signed exponent and a 25-bit
+// signed coefficient.
+type chronyFloat uint32
+
+const (
+	floatExpBits  = 7
+	floatCoefBits = 32 - floatExpBits
+	floatExpMin   = -(1 << (floatExpBits - 1))
+	floatExpMax   = -floatExpMin - 1
+	floatCoefMax  = 1<<(floatCoefBits-1) - 1
+)
+
+// newFloat is UTI_FloatHostToNetwork from chrony's util.c.
+func newFloat(x float64) chronyFloat {
+	var exp, coef int32
+	var neg int32
+	if x < 0 {
+		x, neg = -x, 1
+	}
+	switch {
+	case x < 1e-100:
+		exp, coef = 0, 0
+	case x > 1e100:
+		exp, coef = floatExpMax, floatCoefMax+neg
+	default:
+		exp = int32(math.Log(x)/math.Log(2)) + 1
+		coef = int32(x*math.Pow(2, float64(-exp+floatCoefBits)) + 0.5)
+		for coef > floatCoefMax+neg {
+			coef >>= 1
+			exp++
+		}
+		if exp > floatExpMax {
+			exp, coef = floatExpMax, floatCoefMax+neg
+		} else if exp < floatExpMin {
+			if exp+floatCoefBits >= floatExpMin {
+				coef >>= uint(floatExpMin - exp)
+				exp = floatExpMin
+			} else {
+				exp, coef = 0, 0
+			}
+		}
+	}
+	c := uint32(coef)
+	if neg == 1 {
+		c = uint32(-coef) << floatExpBits >> floatExpBits
+	}
+	return chronyFloat(uint32(exp)<<floatCoefBits | c)
+}
+
+type sourcesReply struct {
+	NSources uint32
+	EOR      int32
+}
+
+type sourceDataReply struct {
+	IPAddr         ipAddr
+	Poll           int16
+	Stratum        uint16
+	State          uint16
+	Mode           uint16
+	Flags          uint16
+	Reachability   uint16
+	SinceSample    uint32
+	OrigLatestMeas chronyFloat
+	LatestMeas     chronyFloat
+	LatestMeasErr  chronyFloat
+	EOR            int32
+}
+
+func newSourceDataReply(d chrony.SourceData) sourceDataReply {
+	return sourceDataReply{
+		IPAddr:         newIPAddr(d.IPAddr),
+		Poll:           d.Poll,
+		Stratum:        d.Stratum,
+		State:          uint16(d.State),
+		Mode:           uint16(d.Mode),
+		Flags:          d.Flags,
+		Reachability:   d.Reachability,
+		SinceSample:    d.SinceSample,
+		OrigLatestMeas: newFloat(d.OrigLatestMeas),
+		LatestMeas:     newFloat(d.LatestMeas),
+		LatestMeasErr:  newFloat(d.LatestMeasErr),
+	}
+}
+
+type trackingReply struct {
+	RefID              uint32
+	IPAddr             ipAddr
+	Stratum            uint16
+	LeapStatus         uint16
+	RefTime            timeSpec
+	CurrentCorrection  chronyFloat
+	LastOffset         chronyFloat
+	RMSOffset          chronyFloat
+	FreqPPM            chronyFloat
+	ResidFreqPPM       chronyFloat
+	SkewPPM            chronyFloat
+	RootDelay          chronyFloat
+	RootDispersion     chronyFloat
+	LastUpdateInterval chronyFloat
+	EOR                int32
+}
+
+func newTrackingReply(t chrony.Tracking) trackingReply {
+	return trackingReply{
+		RefID:              t.RefID,
+		IPAddr:             newIPAddr(t.IPAddr),
+		Stratum:            t.Stratum,
+		LeapStatus:         t.LeapStatus,
+		RefTime:            newTimeSpec(t.RefTime),
+		CurrentCorrection:  newFloat(t.CurrentCorrection),
+		LastOffset:         newFloat(t.LastOffset),
+		RMSOffset:          newFloat(t.RMSOffset),
+		FreqPPM:            newFloat(t.FreqPPM),
+		ResidFreqPPM:       newFloat(t.ResidFreqPPM),
+		SkewPPM:            newFloat(t.SkewPPM),
+		RootDelay:          newFloat(t.RootDelay),
+		RootDispersion:     newFloat(t.RootDispersion),
+		LastUpdateInterval: newFloat(t.LastUpdateInterval),
+	}
+}
+
+type sourceStatsReply struct {
+	RefID              uint32
+	IPAddr             ipAddr
+	NSamples           uint32
+	NRuns              uint32
+	SpanSeconds        uint32
+	StandardDeviation  chronyFloat
+	ResidFreqPPM       chronyFloat
+	SkewPPM            chronyFloat
+	EstimatedOffset    chronyFloat
+	EstimatedOffsetErr chronyFloat
+	EOR                int32
+}
+
+func newSourceStatsReply(s chrony.SourceStats) sourceStatsReply {
+	return sourceStatsReply{
+		RefID:              s.RefID,
+		IPAddr:             newIPAddr(s.IPAddr),
+		NSamples:           s.NSamples,
+		NRuns:              s.NRuns,
+		SpanSeconds:        s.SpanSeconds,
+		StandardDeviation:  newFloat(s.StandardDeviation),
+		ResidFreqPPM:       newFloat(s.ResidFreqPPM),
+		SkewPPM:            newFloat(s.SkewPPM),
+		EstimatedOffset:    newFloat(s.EstimatedOffset),
+		EstimatedOffsetErr: newFloat(s.EstimatedOffsetErr),
+	}
+}
+
+type rtcReply struct {
+	RefTime     timeSpec
+	Samples     uint16
+	Runs        uint16
+	SpanSeconds uint32
+	SecondsFast chronyFloat
+	GainRatePPM chronyFloat
+	EOR         int32
+}
+
+func newRTCReply(r chronymon.RTC) rtcReply {
+	return rtcReply{
+		RefTime:     newTimeSpec(r.RefTime),
+		Samples:     r.Samples,
+		Runs:        r.Runs,
+		SpanSeconds: uint32(r.Span / time.Second),
+		SecondsFast: newFloat(r.SecondsFast),
+		GainRatePPM: newFloat(r.GainRatePPM),
+	}
+}
+
+type activityReply struct {
+	Online       uint32
+	Offline      uint32
+	BurstOnline  uint32
+	BurstOffline uint32
+	Unresolved   uint32
+	EOR          int32
+}
+
+type ntpDataReply struct {
+	RemoteAddr      ipAddr
+	LocalAddr       ipAddr
+	RemotePort      uint16
+	Leap            uint8
+	Version         uint8
+	Mode            uint8
+	Stratum         uint8
+	Poll            int8
+	Precision       int8
+	RootDelay       chronyFloat
+	RootDispersion  chronyFloat
+	RefID           uint32
+	RefTime         timeSpec
+	Offset          chronyFloat
+	PeerDelay       chronyFloat
+	PeerDispersion  chronyFloat
+	ResponseTime    chronyFloat
+	JitterAsymmetry chronyFloat
+	Flags           uint16
+	TXTssChar       uint8
+	RXTssChar       uint8
+	TotalTXCount    uint32
+	TotalRXCount    uint32
+	TotalValidCount uint32
+	Reserved        [4]uint32
+	EOR             int32
+}
+
+func newNTPDataReply(d chrony.NTPData) ntpDataReply {
+	return ntpDataReply{
+		RemoteAddr:      newIPAddr(d.RemoteAddr),
+		LocalAddr:       newIPAddr(d.LocalAddr),
+		RemotePort:      d.RemotePort,
+		Leap:            d.Leap,
+		Version:         d.Version,
+		Mode:            d.Mode,
+		Stratum:         d.Stratum,
+		Poll:            d.Poll,
+		Precision:       d.Precision,
+		RootDelay:       newFloat(d.RootDelay),
+		RootDispersion:  newFloat(d.RootDispersion),
+		RefID:           d.RefID,
+		RefTime:         newTimeSpec(d.RefTime),
+		Offset:          newFloat(d.Offset),
+		PeerDelay:       newFloat(d.PeerDelay),
+		PeerDispersion:  newFloat(d.PeerDispersion),
+		ResponseTime:    newFloat(d.ResponseTime),
+		JitterAsymmetry: newFloat(d.JitterAsymmetry),
+		Flags:           d.Flags,
+		TXTssChar:       d.TXTssChar,
+		RXTssChar:       d.RXTssChar,
+		TotalTXCount:    d.TotalTXCount,
+		TotalRXCount:    d.TotalRXCount,
+		TotalValidCount: d.TotalValidCount,
+	}
+}
+
+type clientsRequest struct {
+	FirstIndex uint32
+	NClients   uint32
+	MinHits    uint32
+	Reset      uint32
+}
+
+// clientsPerReply is the most clients that chronyd puts in one reply.
+const clientsPerReply = 8
+
+type clientReply struct {
+	IP                 ipAddr
+	NTPHits            uint32
+	NKEHits            uint32
+	CmdHits            uint32
+	NTPDrops           uint16
+	NKEDrops           uint16
+	CmdDrops           uint16
+	NTPInterval        int8
+	NKEInterval        int8
+	CmdInterval        int8
+	NTPTimeoutInterval int8
+	Pad                uint16
+	LastNTPHitAgo      uint32
+	LastNKEHitAgo      uint32
+	LastCmdHitAgo      uint32
+}
+
+type clientsReply struct {
+	NIndices  uint32
+	NextIndex uint32
+	NClients  uint32
+	Clients   [clientsPerReply]clientReply
+	EOR       int32
+}
+
+// hitAgo encodes how long ago a client's last packet was, in seconds.
+func hitAgo(d time.Duration) uint32 {
+	if d < 0 {
+		return math.MaxUint32
+	}
+	return uint32(d / time.Second)
+}
+
+// newClientsReply returns the page of clients that req asks for.  Each client gets one index,
+// rather than one slot in a hash table as in chronyd.
+func newClientsReply(clients []chronymon.Client, req clientsRequest) clientsReply {
+	r := clientsReply{NIndices: uint32(len(clients)), NextIndex: req.FirstIndex}
+	for r.NextIndex < r.NIndices && r.NClients < req.NClients && r.NClients < clientsPerReply {
+		c := clients[r.NextIndex]
+		r.NextIndex++
+		if c.NTPHits+c.NKEHits+c.CmdHits < req.MinHits {
+			continue
+		}
+		r.Clients[r.NClients] = clientReply{
+			IP:                 newIPAddr(c.IP),
+			NTPHits:            c.NTPHits,
+			NKEHits:            c.NKEHits,
+			CmdHits:            c.CmdHits,
+			NTPDrops:           c.NTPDrops,
+			NKEDrops:           c.NKEDrops,
+			CmdDrops:           c.CmdDrops,
+			NTPInterval:        c.NTPInterval,
+			NKEInterval:        c.NKEInterval,
+			CmdInterval:        c.CmdInterval,
+			NTPTimeoutInterval: c.NTPTimeoutInterval,
+			LastNTPHitAgo:      hitAgo(c.LastNTP),
+			LastNKEHitAgo:      hitAgo(c.LastNKE),
+			LastCmdHitAgo:      hitAgo(c.LastCmd),
+		}
+		r.NClients++
+	}
+	return r
+}
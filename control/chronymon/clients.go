@@ -0,0 +1,169 @@
+package chronymon
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/chrony"
+)
+
+// Client is an entry in chronyd's client access log, as shown by "chronyc clients": a host that
+// has sent chronyd NTP, NTS-KE or command packets.
+type Client struct {
+	IP net.IP
+
+	NTPHits, NKEHits, CmdHits    uint32 // Packets received.
+	NTPDrops, NKEDrops, CmdDrops uint16 // Packets dropped by rate limiting.
+
+	// The average interval between packets, as a base-2 logarithm of seconds; see Interval.
+	NTPInterval, NKEInterval, CmdInterval int8
+	// The average interval between NTP packets that were rate limited, like NTPInterval.
+	NTPTimeoutInterval int8
+
+	// How long ago the last packet arrived; negative if none ever has.
+	LastNTP, LastNKE, LastCmd time.Duration
+}
+
+// Interval converts one of a Client's log2 intervals to a duration, returning false if chronyd
+// doesn't know it.
+func Interval(log2 int8) (time.Duration, bool) {
+	if log2 <= -127 || log2 >= 127 {
+		return 0, false
+	}
+	return time.Duration(math.Pow(2, float64(log2)) * float64(time.Second)), true
+}
+
+// NTPRate returns how many NTP packets per second the client sends, on average.
+func (c *Client) NTPRate() float64 {
+	if c.LastNTP < 0 {
+		return 0
+	}
+	d, ok := Interval(c.NTPInterval)
+	if !ok || d <= 0 {
+		return 0
+	}
+	return float64(time.Second) / float64(d)
+}
+
+const (
+	reqClientAccessesByIndex3 chrony.CommandType = 68
+	rpyClientAccessesByIndex3 chrony.ReplyType   = 21
+
+	// clientsPerReply is how many clients chronyd sends in each reply.
+	clientsPerReply = 8
+
+	// MaxClients is the most clients that a snapshot will include, to bound how many requests
+	// each poll makes.
+	MaxClients = 1024
+)
+
+// clientsRequest is padded to the length of the reply, as chronyd requires.
+type clientsRequest struct {
+	chrony.RequestHead
+	FirstIndex uint32
+	NClients   uint32
+	MinHits    uint32
+	Reset      uint32
+	EOR        int32
+	data       [452]uint8 // nolint:unused,structcheck
+}
+
+type clientReply struct {
+	IP                 ipAddr
+	NTPHits            uint32
+	NKEHits            uint32
+	CmdHits            uint32
+	NTPDrops           uint16
+	NKEDrops           uint16
+	CmdDrops           uint16
+	NTPInterval        int8
+	NKEInterval        int8
+	CmdInterval        int8
+	NTPTimeoutInterval int8
+	Pad                uint16
+	LastNTPHitAgo      uint32
+	LastNKEHitAgo      uint32
+	LastCmdHitAgo      uint32
+}
+
+type clientsReply struct {
+	NIndices  uint32
+	NextIndex uint32
+	NClients  uint32
+	Clients   [clientsPerReply]clientReply
+	EOR       int32
+}
+
+// hitAgo converts the number of seconds since a client's last packet, which is all ones if
+// there hasn't been one.
+func hitAgo(s uint32) time.Duration {
+	if s == math.MaxUint32 {
+		return -1
+	}
+	return time.Duration(s) * time.Second
+}
+
+// getClients reads chronyd's client access log, a page at a time.
+func getClients(c *chrony.Client) ([]Client, error) {
+	clients := []Client{} // Not nil, since the log being empty is different from not having it.
+	var index uint32
+	for len(clients) < MaxClients {
+		c.Sequence++
+		req := clientsRequest{
+			RequestHead: chrony.RequestHead{Version: 6, PKTType: 1, Command: reqClientAccessesByIndex3, Sequence: c.Sequence},
+			FirstIndex:  index,
+			NClients:    clientsPerReply,
+		}
+		var r clientsReply
+		if err := exchange(c, &req, rpyClientAccessesByIndex3, &r); err != nil {
+			return nil, fmt.Errorf("clients from index %d: %w", index, err)
+		}
+		if r.NClients > clientsPerReply {
+			return nil, fmt.Errorf("clients from index %d: reply has %d clients", index, r.NClients)
+		}
+		for _, cr := range r.Clients[:r.NClients] {
+			clients = append(clients, Client{
+				IP:                 cr.IP.netIP(),
+				NTPHits:            cr.NTPHits,
+				NKEHits:            cr.NKEHits,
+				CmdHits:            cr.CmdHits,
+				NTPDrops:           cr.NTPDrops,
+				NKEDrops:           cr.NKEDrops,
+				CmdDrops:           cr.CmdDrops,
+				NTPInterval:        cr.NTPInterval,
+				NKEInterval:        cr.NKEInterval,
+				CmdInterval:        cr.CmdInterval,
+				NTPTimeoutInterval: cr.NTPTimeoutInterval,
+				LastNTP:            hitAgo(cr.LastNTPHitAgo),
+				LastNKE:            hitAgo(cr.LastNKEHitAgo),
+				LastCmd:            hitAgo(cr.LastCmdHitAgo),
+			})
+		}
+		if r.NextIndex <= index || r.NextIndex >= r.NIndices {
+			break
+		}
+		index = r.NextIndex
+	}
+	if len(clients) > MaxClients {
+		clients = clients[:MaxClients]
+	}
+	return clients, nil
+}
+
+type ipAddr struct {
+	IP     [16]uint8
+	Family uint16
+	Pad    uint16
+}
+
+func (a ipAddr) netIP() net.IP {
+	switch a.Family {
+	case 1:
+		return net.IP(append([]byte(nil), a.IP[:4]...))
+	case 2:
+		return net.IP(append([]byte(nil), a.IP[:]...))
+	}
+	return nil
+}
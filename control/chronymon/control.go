@@ -0,0 +1,132 @@
+package chronymon
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/chrony"
+)
+
+// Action is a command that changes what chronyd is doing, named like the chronyc command that
+// does the same thing.
+type Action string
+
+const (
+	// ActionBurst makes chronyd take a quick burst of measurements from its sources.
+	ActionBurst Action = "burst"
+	// ActionMakeStep makes chronyd step the clock to correct its offset at once, instead of
+	// slewing it.
+	ActionMakeStep Action = "makestep"
+	// ActionOnline tells chronyd that its sources are reachable again.
+	ActionOnline Action = "online"
+	// ActionOffline tells chronyd to stop polling its sources.
+	ActionOffline Action = "offline"
+	// ActionReselect makes chronyd forget which source it prefers and choose again.
+	ActionReselect Action = "reselect"
+)
+
+// Actions are all the actions, in the order that they're usually shown.
+var Actions = []Action{ActionBurst, ActionMakeStep, ActionOnline, ActionOffline, ActionReselect}
+
+// TakesSource returns true if the action can be limited to one source.
+func (a Action) TakesSource() bool {
+	return a == ActionBurst || a == ActionOnline || a == ActionOffline
+}
+
+// The number of good measurements that a burst waits for, and the most that it will take; the same
+// as the "iburst" option.
+const (
+	BurstGood  = 4
+	BurstTotal = 8
+)
+
+const (
+	reqOnline   chrony.CommandType = 1
+	reqOffline  chrony.CommandType = 2
+	reqBurst    chrony.CommandType = 3
+	reqMakeStep chrony.CommandType = 43
+	reqReselect chrony.CommandType = 48
+
+	rpyNull chrony.ReplyType = 1
+)
+
+// onlineRequest is an online or offline request.
+type onlineRequest struct {
+	chrony.RequestHead
+	Mask    ipAddr
+	Address ipAddr
+	EOR     int32
+	data    [396 - 44]uint8 // nolint:unused,structcheck
+}
+
+type burstRequest struct {
+	chrony.RequestHead
+	Mask          ipAddr
+	Address       ipAddr
+	NGoodSamples  int32
+	NTotalSamples int32
+	EOR           int32
+	data          [396 - 52]uint8 // nolint:unused,structcheck
+}
+
+// Control connects to chronyd's Unix socket and asks it to do action.  For actions that take a
+// source, source is the address of the one to act on, or nil for all of them; it must be nil for
+// the others.
+func Control(socket string, action Action, source net.IP, timeout time.Duration) error {
+	if source != nil && !action.TakesSource() {
+		return fmt.Errorf("%s does not take a source", action)
+	}
+	conn, err := dialUnix(socket)
+	if err != nil {
+		return fmt.Errorf("dial %s: %w", socket, err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return fmt.Errorf("set deadline: %w", err)
+	}
+	c := &chrony.Client{Sequence: 1, Connection: conn}
+
+	head := chrony.RequestHead{Version: 6, PKTType: 1, Sequence: c.Sequence}
+	mask, addr := matchAddr(source)
+	switch action {
+	case ActionOnline, ActionOffline:
+		head.Command = reqOnline
+		if action == ActionOffline {
+			head.Command = reqOffline
+		}
+		err = exchange(c, &onlineRequest{RequestHead: head, Mask: mask, Address: addr}, rpyNull, nil)
+	case ActionBurst:
+		head.Command = reqBurst
+		err = exchange(c, &burstRequest{RequestHead: head, Mask: mask, Address: addr, NGoodSamples: BurstGood, NTotalSamples: BurstTotal}, rpyNull, nil)
+	case ActionMakeStep:
+		head.Command = reqMakeStep
+		err = exchange(c, &rawRequest{RequestHead: head}, rpyNull, nil)
+	case ActionReselect:
+		head.Command = reqReselect
+		err = exchange(c, &rawRequest{RequestHead: head}, rpyNull, nil)
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", action, err)
+	}
+	return nil
+}
+
+// matchAddr returns the mask and address that select the source at ip, or all sources if ip is
+// nil, as chronyc does.
+func matchAddr(ip net.IP) (mask, addr ipAddr) {
+	switch {
+	case ip == nil:
+	case ip.To4() != nil:
+		copy(addr.IP[:], ip.To4())
+		copy(mask.IP[:], net.CIDRMask(32, 32))
+		addr.Family, mask.Family = 1, 1
+	default:
+		copy(addr.IP[:], ip.To16())
+		copy(mask.IP[:], net.CIDRMask(128, 128))
+		addr.Family, mask.Family = 2, 2
+	}
+	return mask, addr
+}
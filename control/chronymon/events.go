@@ -0,0 +1,137 @@
+package chronymon
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/chrony"
+)
+
+// EventKind says what changed between two snapshots.
+type EventKind string
+
+const (
+	EventSelected      EventKind = "selected"     // chronyd selected a different source, or none.
+	EventState         EventKind = "state"        // A source's selection state changed.
+	EventReachability  EventKind = "reachability" // A source became unreachable, or reachable again.
+	EventLeap          EventKind = "leap"         // The leap status changed.
+	EventStratum       EventKind = "stratum"      // chronyd's own stratum changed.
+	EventSourceAdded   EventKind = "added"
+	EventSourceRemoved EventKind = "removed"
+)
+
+// Event is a change in chronyd's state between two snapshots.
+type Event struct {
+	Time     time.Time // When the later snapshot was taken.
+	Kind     EventKind
+	Source   string // The name of the source that changed; empty for changes to chronyd as a whole.
+	From, To string // The old and new values, as shown on the status page; "none" for no source.
+}
+
+func (e Event) String() string {
+	switch e.Kind {
+	case EventSelected:
+		return fmt.Sprintf("selected source changed from %s to %s", e.From, e.To)
+	case EventState:
+		return fmt.Sprintf("%s changed from %s to %s", e.Source, e.From, e.To)
+	case EventReachability:
+		if e.To == "0" {
+			return fmt.Sprintf("%s became unreachable", e.Source)
+		}
+		return fmt.Sprintf("%s became reachable", e.Source)
+	case EventLeap:
+		return fmt.Sprintf("leap status changed from %s to %s", e.From, e.To)
+	case EventStratum:
+		return fmt.Sprintf("stratum changed from %s to %s", e.From, e.To)
+	case EventSourceAdded:
+		return fmt.Sprintf("%s was added", e.Source)
+	case EventSourceRemoved:
+		return fmt.Sprintf("%s was removed", e.Source)
+	}
+	return fmt.Sprintf("%s %s changed from %s to %s", e.Source, e.Kind, e.From, e.To)
+}
+
+// selectedSource returns the name of the source that chronyd selected, or "none".
+func selectedSource(s *Snapshot) string {
+	for _, src := range s.Sources {
+		if src.Data.State == chrony.SourceStateSync {
+			return SourceName(src.Data.IPAddr)
+		}
+	}
+	return "none"
+}
+
+// reselected returns true if a source went between being selected and being usable but not
+// selected, which the EventSelected already describes.
+func reselected(a, b chrony.SourceStateType) bool {
+	usable := func(x chrony.SourceStateType) bool {
+		return x == chrony.SourceStateCandidate || x == chrony.SourceStateOutlier
+	}
+	return a == chrony.SourceStateSync && usable(b) || usable(a) && b == chrony.SourceStateSync
+}
+
+// Changes returns what changed between prev and cur, which should both be successful snapshots.
+// Sources are matched by address, not by index.
+func Changes(prev, cur *Snapshot) []Event {
+	var events []Event
+	add := func(kind EventKind, source, from, to string) {
+		events = append(events, Event{Time: cur.Time, Kind: kind, Source: source, From: from, To: to})
+	}
+	if a, b := prev.Tracking.Stratum, cur.Tracking.Stratum; a != b {
+		add(EventStratum, "", strconv.Itoa(int(a)), strconv.Itoa(int(b)))
+	}
+	if a, b := prev.Tracking.LeapStatus, cur.Tracking.LeapStatus; a != b {
+		add(EventLeap, "", LeapStatusName(a), LeapStatusName(b))
+	}
+	if a, b := selectedSource(prev), selectedSource(cur); a != b {
+		add(EventSelected, "", a, b)
+	}
+
+	old := make(map[string]chrony.SourceData)
+	for _, src := range prev.Sources {
+		old[SourceName(src.Data.IPAddr)] = src.Data
+	}
+	seen := make(map[string]bool)
+	for _, src := range cur.Sources {
+		name := SourceName(src.Data.IPAddr)
+		seen[name] = true
+		was, ok := old[name]
+		if !ok {
+			add(EventSourceAdded, name, "", StateName(src.Data.State))
+			continue
+		}
+		if a, b := was.State, src.Data.State; a != b && !reselected(a, b) {
+			add(EventState, name, StateName(a), StateName(b))
+		}
+		if a, b := was.Reachability, src.Data.Reachability; (a == 0) != (b == 0) {
+			add(EventReachability, name, strconv.FormatUint(uint64(a), 8), strconv.FormatUint(uint64(b), 8))
+		}
+	}
+	for _, src := range prev.Sources {
+		if name := SourceName(src.Data.IPAddr); !seen[name] {
+			add(EventSourceRemoved, name, StateName(src.Data.State), "")
+		}
+	}
+	return events
+}
+
+// Detector finds what changed over a series of snapshots.
+type Detector struct {
+	prev *Snapshot
+}
+
+// Next returns what changed between the last successful snapshot passed to Next and s.  Failed
+// snapshots are ignored, so a source that changes while chronyd is unreachable is reported once
+// chronyd comes back.  The first successful snapshot has no changes.
+func (d *Detector) Next(s *Snapshot) []Event {
+	if s.Err != nil {
+		return nil
+	}
+	prev := d.prev
+	d.prev = s
+	if prev == nil {
+		return nil
+	}
+	return Changes(prev, s)
+}
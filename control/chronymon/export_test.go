@@ -0,0 +1,4 @@
+package chronymon
+
+// Publish lets tests send snapshots to subscribers without polling chronyd.
+var Publish = (*Monitor).publish
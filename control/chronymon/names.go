@@ -0,0 +1,78 @@
+package chronymon
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/facebookincubator/ntp/protocol/chrony"
+)
+
+// SourceName returns the name that chronyc shows for a source's address.  Reference clocks
+// report their refid as an IPv4 address, like 80.80.83.0 for "PPS", so those are turned back into
+// text.
+func SourceName(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		last := len(v4)
+		for i, b := range v4 {
+			if b == 0 && i > 0 {
+				last = i
+				break
+			}
+			if b < '0' || b > 'z' {
+				last = 0
+				break
+			}
+		}
+		if last > 0 {
+			return string(v4[0:last])
+		}
+	}
+	return ip.String()
+}
+
+// LeapStatusName describes the leap status in chronyd's tracking report, as chronyc does.
+func LeapStatusName(x uint16) string {
+	// From chrony/client.c and chrony/ntp.h
+	switch x {
+	case 0:
+		return "Normal"
+	case 1:
+		return "Insert second"
+	case 2:
+		return "Delete second"
+	case 3:
+		return "Unsynchronized"
+	default:
+		return fmt.Sprintf("Invalid (%v)", x)
+	}
+}
+
+// StateName describes a source's selection state, following chronyc's legend for the "sources"
+// report.
+//
+// The chrony package's names for the states don't match chrony's candm.h:
+//
+//	candm.h                   packet.go
+//	RPY_SD_ST_SELECTED      0 SourceStateSync
+//	RPY_SD_ST_NONSELECTABLE 1 SourceStateUnreach
+//	RPY_SD_ST_FALSETICKER   2 SourceStateFalseTicket
+//	RPY_SD_ST_JITTERY       3 SourceStateJittery
+//	RPY_SD_ST_UNSELECTED    4 SourceStateCandidate
+//	RPY_SD_ST_SELECTABLE    5 SourceStateOutlier
+func StateName(x chrony.SourceStateType) string {
+	switch x {
+	case chrony.SourceStateSync:
+		return "selected"
+	case chrony.SourceStateUnreach:
+		return "not selectable"
+	case chrony.SourceStateFalseTicket:
+		return "falseticker"
+	case chrony.SourceStateJittery:
+		return "too variable"
+	case chrony.SourceStateCandidate:
+		return "combined"
+	case chrony.SourceStateOutlier:
+		return "not combined"
+	}
+	return fmt.Sprintf("unknown state %d", x)
+}
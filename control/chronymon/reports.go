@@ -0,0 +1,264 @@
+package chronymon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/chrony"
+)
+
+// Activity is chronyd's "activity" report: how many sources are online and offline.
+type Activity struct {
+	Online       uint32
+	Offline      uint32
+	BurstOnline  uint32 // Doing a burst of measurements, then going back online.
+	BurstOffline uint32 // Doing a burst of measurements, then going back offline.
+	Unresolved   uint32 // Sources whose names haven't been resolved yet.
+}
+
+// RTC is chronyd's "rtcdata" report, about the battery-backed real-time clock.
+type RTC struct {
+	RefTime     time.Time // When the RTC was last measured.
+	Samples     uint16
+	Runs        uint16
+	Span        time.Duration
+	SecondsFast float64 // How far ahead of the system clock the RTC was at RefTime.
+	GainRatePPM float64 // How fast the RTC gains time.
+}
+
+// Commands and reply types that the chrony package doesn't know about, from chrony's candm.h.
+const (
+	reqRTCReport chrony.CommandType = 35
+	reqActivity  chrony.CommandType = 44
+
+	rpyRTC      chrony.ReplyType = 7
+	rpyActivity chrony.ReplyType = 12
+)
+
+// StatusError is a reply from chronyd that isn't a success.
+type StatusError struct {
+	Status chrony.ResponseStatusType
+}
+
+func (e *StatusError) Error() string {
+	if int(e.Status) < len(chrony.StatusDesc) {
+		return "got status " + chrony.StatusDesc[e.Status]
+	}
+	return fmt.Sprintf("got status %d", e.Status)
+}
+
+// statusNoRTC is the status that chronyd replies to rtcdata with when it isn't tracking the RTC.
+const statusNoRTC chrony.ResponseStatusType = 13
+
+// rawRequest is a request with no arguments, padded like the chrony package's requests.
+type rawRequest struct {
+	chrony.RequestHead
+	data [396]uint8
+}
+
+// communicate sends a request with no arguments that the chrony package doesn't support, and
+// decodes the reply's data into reply.
+func communicate(c *chrony.Client, command chrony.CommandType, want chrony.ReplyType, reply interface{}) error {
+	c.Sequence++
+	req := rawRequest{RequestHead: chrony.RequestHead{Version: 6, PKTType: 1, Command: command, Sequence: c.Sequence}}
+	return exchange(c, &req, want, reply)
+}
+
+// exchange sends req, which must start with a chrony.RequestHead, and decodes the data of a reply
+// of type want into reply, which is nil for replies without data.
+func exchange(c *chrony.Client, req interface{}, want chrony.ReplyType, reply interface{}) error {
+	if err := binary.Write(c.Connection, binary.BigEndian, req); err != nil {
+		return fmt.Errorf("send request: %w", err)
+	}
+	buf := make([]byte, 1024)
+	n, err := c.Connection.Read(buf)
+	if err != nil {
+		return fmt.Errorf("read reply: %w", err)
+	}
+	r := bytes.NewReader(buf[:n])
+	var head chrony.ReplyHead
+	if err := binary.Read(r, binary.BigEndian, &head); err != nil {
+		return fmt.Errorf("read reply header: %w", err)
+	}
+	if head.Status != 0 {
+		return &StatusError{Status: head.Status}
+	}
+	if head.Reply != want {
+		return fmt.Errorf("reply was of unexpected type %d", head.Reply)
+	}
+	if reply == nil {
+		return nil
+	}
+	if err := binary.Read(r, binary.BigEndian, reply); err != nil {
+		return fmt.Errorf("read reply data: %w", err)
+	}
+	return nil
+}
+
+type activityReply struct {
+	Online       uint32
+	Offline      uint32
+	BurstOnline  uint32
+	BurstOffline uint32
+	Unresolved   uint32
+	EOR          int32
+}
+
+func getActivity(c *chrony.Client) (*Activity, error) {
+	var r activityReply
+	if err := communicate(c, reqActivity, rpyActivity, &r); err != nil {
+		return nil, err
+	}
+	return &Activity{Online: r.Online, Offline: r.Offline, BurstOnline: r.BurstOnline, BurstOffline: r.BurstOffline, Unresolved: r.Unresolved}, nil
+}
+
+type rtcReply struct {
+	RefTime     [3]uint32 // Seconds (high and low words) and nanoseconds.
+	Samples     uint16
+	Runs        uint16
+	SpanSeconds uint32
+	SecondsFast uint32
+	GainRatePPM uint32
+	EOR         int32
+}
+
+// getRTC returns nil, without an error, if chronyd isn't tracking the RTC.
+func getRTC(c *chrony.Client) (*RTC, error) {
+	var r rtcReply
+	if err := communicate(c, reqRTCReport, rpyRTC, &r); err != nil {
+		var serr *StatusError
+		if errors.As(err, &serr) && serr.Status == statusNoRTC {
+			return nil, nil
+		}
+		return nil, err
+	}
+	high := uint64(r.RefTime[0])
+	if high == 0x7fffffff {
+		high = 0
+	}
+	return &RTC{
+		RefTime:     time.Unix(int64(high<<32|uint64(r.RefTime[1])), int64(r.RefTime[2])),
+		Samples:     r.Samples,
+		Runs:        r.Runs,
+		Span:        time.Duration(r.SpanSeconds) * time.Second,
+		SecondsFast: toFloat(r.SecondsFast),
+		GainRatePPM: toFloat(r.GainRatePPM),
+	}, nil
+}
+
+// toFloat decodes chrony's 32-bit floating point format: a 7-bit signed exponent and a 25-bit
+// signed coefficient.
+func toFloat(x uint32) float64 {
+	exp := int32(x >> 25)
+	if exp >= 1<<6 {
+		exp -= 1 << 7
+	}
+	coef := int32(x % (1 << 25))
+	if coef >= 1<<24 {
+		coef -= 1 << 25
+	}
+	return float64(coef) * math.Pow(2, float64(exp-25))
+}
+
+// getServerStats returns chronyd's NTP server statistics, in the newer format whichever one
+// chronyd sends.
+func getServerStats(c *chrony.Client) (*chrony.ServerStats2, error) {
+	res, err := c.Communicate(chrony.NewServerStatsPacket())
+	if err != nil {
+		return nil, err
+	}
+	switch r := res.(type) {
+	case *chrony.ReplyServerStats2:
+		return &r.ServerStats2, nil
+	case *chrony.ReplyServerStats:
+		return &chrony.ServerStats2{NTPHits: r.NTPHits, CMDHits: r.CMDHits, NTPDrops: r.NTPDrops, CMDDrops: r.CMDDrops, LogDrops: r.LogDrops}, nil
+	}
+	return nil, fmt.Errorf("reply was of unexpected type %T", res)
+}
+
+func getNTPData(c *chrony.Client, ip net.IP) (*chrony.NTPData, error) {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	res, err := c.Communicate(chrony.NewNTPDataPacket(ip))
+	if err != nil {
+		return nil, err
+	}
+	r, ok := res.(*chrony.ReplyNTPData)
+	if !ok {
+		return nil, fmt.Errorf("reply was of unexpected type %T", res)
+	}
+	return &r.NTPData, nil
+}
+
+// localSockets counts the client sockets that dialUnix has made, to give each a unique name.
+var localSockets int64
+
+// dialUnix connects to chronyd's Unix socket at path.  Like chronyc, it binds a socket of its own
+// next to chronyd's, for the replies to come back to; closing the connection removes it.
+func dialUnix(path string) (net.Conn, error) {
+	local := filepath.Join(filepath.Dir(path), fmt.Sprintf("chronymon.%d.%d.sock", os.Getpid(), atomic.AddInt64(&localSockets, 1)))
+	os.Remove(local) // nolint:errcheck
+	conn, err := net.DialUnix("unixgram", &net.UnixAddr{Name: local, Net: "unixgram"}, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		os.Remove(local) // nolint:errcheck
+		return nil, err
+	}
+	return &unixConn{UnixConn: conn, local: local}, nil
+}
+
+type unixConn struct {
+	*net.UnixConn
+	local string
+}
+
+func (c *unixConn) Close() error {
+	err := c.UnixConn.Close()
+	os.Remove(c.local) // nolint:errcheck
+	return err
+}
+
+// pollPrivileged adds the reports that chronyd only gives out over its Unix socket to s.
+func pollPrivileged(socket string, s *Snapshot, timeout time.Duration) error {
+	conn, err := dialUnix(socket)
+	if err != nil {
+		return fmt.Errorf("dial %s: %w", socket, err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return fmt.Errorf("set deadline: %w", err)
+	}
+	c := &chrony.Client{Sequence: 1, Connection: conn}
+
+	stats, err := getServerStats(c)
+	if err != nil {
+		s.Warnings = append(s.Warnings, fmt.Errorf("get serverstats: %w", err))
+	}
+	s.ServerStats = stats
+	for i := range s.Sources {
+		src := &s.Sources[i]
+		if src.Data.Mode == chrony.SourceModeRef {
+			continue
+		}
+		ntp, err := getNTPData(c, src.Data.IPAddr)
+		if err != nil {
+			s.Warnings = append(s.Warnings, fmt.Errorf("source %d: get ntpdata: %w", i, err))
+			continue
+		}
+		src.NTP = ntp
+	}
+	clients, err := getClients(c)
+	if err != nil {
+		s.Warnings = append(s.Warnings, fmt.Errorf("get clients: %w", err))
+	}
+	s.Clients = clients
+	return nil
+}
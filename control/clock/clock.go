@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
+	"log"
+	"sync"
 	"time"
 
 	"github.com/jrockway/beaglebone-gps-clock/control/fixed58"
@@ -64,62 +67,418 @@ func Tick(ctx context.Context, ch chan time.Time) error {
 	}
 }
 
-func renderTime(img *image.NRGBA64, t time.Time, c color.Color) {
+// face5x8 is the font that faces draw with.
+var face5x8 = &basicfont.Face{
+	Advance: 5,
+	Width:   5,
+	Height:  8,
+	Ascent:  8,
+	Descent: 0,
+	Mask:    fixed58.Mask5x8,
+	Ranges: []basicfont.Range{
+		{Low: '\u0020', High: '\u007f', Offset: 0},
+		{Low: '\ufffd', High: '\ufffe', Offset: 95},
+	},
+}
+
+// renderText draws s in the 5x8 font, starting at the left edge of the display.  Text that doesn't
+// fit is cut off.
+func renderText(img *image.NRGBA64, s string, c color.Color) {
+	renderTextAt(img, s, 0, c)
+}
+
+// renderTextAt draws s in the 5x8 font, starting x pixels from the left edge of the display.
+func renderTextAt(img *image.NRGBA64, s string, x int, c color.Color) {
 	drawer := &font.Drawer{
-		Dst: img,
-		Src: image.NewUniform(c),
-		Face: &basicfont.Face{
-			Advance: 5,
-			Width:   5,
-			Height:  8,
-			Ascent:  8,
-			Descent: 0,
-			Mask:    fixed58.Mask5x8,
-			Ranges: []basicfont.Range{
-				{'\u0020', '\u007f', 0},
-				{'\ufffd', '\ufffe', 95},
-			},
-		},
-		Dot: fixed.P(0, 8),
+		Dst:  img,
+		Src:  image.NewUniform(c),
+		Face: face5x8,
+		Dot:  fixed.P(x, 8),
 	}
-	drawer.DrawString(t.Format("15:04:05"))
+	drawer.DrawString(s)
+}
+
+func renderTime(img *image.NRGBA64, t time.Time, c color.Color) {
+	renderText(img, t.Format("15:04:05"), c)
+}
+
+func renderDate(img *image.NRGBA64, t time.Time, c color.Color) {
+	renderText(img, t.Format("02 Jan 06"), c)
+}
+
+// renderAlarm draws the label of a ringing alarm, alternating every second between normal and
+// inverse video.
+func renderAlarm(img *image.NRGBA64, label string, t time.Time, c color.NRGBA64) {
+	if t.Unix()%2 == 0 {
+		renderText(img, label, c)
+		return
+	}
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	renderText(img, label, color.NRGBA64{A: 0xffff})
+}
+
+// Face draws the clock face for time t onto img, using color c.
+type Face func(img *image.NRGBA64, t time.Time, c color.Color)
+
+// Faces are the faces that can be selected by name with Clock.FaceCh.
+var Faces = map[string]Face{
+	"time": renderTime,
+	"date": renderDate,
+}
+
+// DefaultFace is the face that a new Clock shows.
+const DefaultFace = "time"
+
+// messageDuration is how long a message sent to Clock.MessageCh is shown before returning to the
+// selected face.  Messages that are too long to fit on the display scroll.
+const messageDuration = 10 * time.Second
+
+// State is the part of the clock that can be changed at runtime.
+type State struct {
+	Face       string
+	Brightness uint16
+	Message    string // Empty if no message is being shown.
+	Timer      Timer  // Shown instead of the face, unless it's NoTimer.
+	Alarm      string // The label of the ringing alarm; empty if no alarm is ringing.
+}
+
+// Alarms decides whether an alarm is ringing.  Run calls Tick on every tick, with the tick time in
+// the clock's location.
+type Alarms interface {
+	Tick(t time.Time) (label string, ringing bool)
 }
 
 // Clock represents a clock face with parameters that can be changed at runtime.
 type Clock struct {
 	display      *screen.Screen
 	BrightnessCh chan uint16
+	FaceCh       chan string // Selects one of Faces, or a face from the face file, by name.
+	MessageCh    chan string // Shows a message for a while instead of the face; see MessageReadTime.
+	TimerCh      chan Timer  // Starts, changes or (with the zero Timer) stops a timer.
+
+	timerUpdateCh chan timerUpdate // Changes the current timer atomically; see TimerHandler.
+
+	stateMu sync.Mutex
+	state   State                // must hold stateMu to read or write.
+	loc     *time.Location       // time zone that faces are drawn in; must hold stateMu.
+	onState func(State)          // called when state changes; must hold stateMu.
+	alarms  Alarms               // must hold stateMu.
+	trans   Transitions          // must hold stateMu.
+	custom  map[string]*FaceSpec // faces from the face file; must hold stateMu.
+	synced  bool                 // must hold stateMu.
+	theme   Theme                // colors the built-in faces; must hold stateMu.
+
+	lastTick time.Time // when a tick was last successfully displayed; must hold stateMu.
 }
 
 func New(d *screen.Screen) *Clock {
-	return &Clock{display: d, BrightnessCh: make(chan uint16)}
+	return &Clock{
+		display:       d,
+		BrightnessCh:  make(chan uint16),
+		FaceCh:        make(chan string),
+		MessageCh:     make(chan string),
+		TimerCh:       make(chan Timer),
+		timerUpdateCh: make(chan timerUpdate),
+		state:         State{Face: DefaultFace, Brightness: 0xffff},
+		loc:           time.Local,
+		trans:         DefaultTransitions,
+	}
+}
+
+// SetLocation changes the time zone that faces are drawn in, starting with the next tick.
+func (c *Clock) SetLocation(loc *time.Location) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	c.loc = loc
+}
+
+// Location returns the time zone that faces are drawn in.
+func (c *Clock) Location() *time.Location {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.loc
+}
+
+// State returns the current state of the clock.
+func (c *Clock) State() State {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.state
+}
+
+// LastTick returns the time of the last tick that was successfully drawn to the display.  Callers
+// can use this to check that the clock is actually running.
+func (c *Clock) LastTick() time.Time {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.lastTick
+}
+
+// SetStateListener arranges for f to be called from Run whenever the state changes.  f must not
+// block.  Passing nil removes the listener.
+func (c *Clock) SetStateListener(f func(State)) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	c.onState = f
+}
+
+// SetAlarms arranges for a to be consulted on every tick.  Passing nil disables alarms.
+func (c *Clock) SetAlarms(a Alarms) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	c.alarms = a
+}
+
+// SetTransitions changes how the clock animates brightness changes and changes of the image.
+func (c *Clock) SetTransitions(tr Transitions) error {
+	if err := tr.Validate(); err != nil {
+		return fmt.Errorf("set transitions: %w", err)
+	}
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	c.trans = tr
+	return nil
+}
+
+// Transitions returns the clock's current animation settings.
+func (c *Clock) Transitions() Transitions {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.trans
+}
+
+// SetFaceFile makes the faces in ff available to FaceCh, replacing those from any previous face
+// file.  Passing nil removes them.  If the face being shown is removed, the clock switches to
+// DefaultFace on its next tick.
+func (c *Clock) SetFaceFile(ff *FaceFile) {
+	custom := map[string]*FaceSpec{}
+	if ff != nil {
+		for _, f := range ff.Faces {
+			custom[f.Name] = f
+		}
+	}
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	c.custom = custom
+}
+
+// HasFace returns true if name is one of Faces or a face from the face file.
+func (c *Clock) HasFace(name string) bool {
+	if _, ok := Faces[name]; ok {
+		return true
+	}
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	_, ok := c.custom[name]
+	return ok
+}
+
+// SetSynced tells the clock whether its time source is synchronized, for faces from the face file
+// that only draw some regions when it is or isn't.
+func (c *Clock) SetSynced(synced bool) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	c.synced = synced
+}
+
+// renderFace draws the face called name, or DefaultFace if there's no such face.
+func (c *Clock) renderFace(img *image.NRGBA64, name string, t time.Time, fg color.Color) {
+	c.stateMu.Lock()
+	spec, synced, loc, theme := c.custom[name], c.synced, c.loc, c.theme
+	c.stateMu.Unlock()
+	if spec != nil {
+		spec.Render(img, t.In(loc), synced)
+		return
+	}
+	f, ok := Faces[name]
+	if !ok {
+		f = Faces[DefaultFace]
+	}
+	f(img, t.In(loc), fg)
+	applyTheme(img, theme, t.In(loc))
+}
+
+// setState changes the state and notifies the state listener.
+func (c *Clock) setState(f func(s *State)) State {
+	c.stateMu.Lock()
+	f(&c.state)
+	s, onState := c.state, c.onState
+	c.stateMu.Unlock()
+	if onState != nil {
+		onState(s)
+	}
+	return s
 }
 
 // Run runs the clock until the context is cancelled.
 func (c *Clock) Run(ctx context.Context) error {
-	brightness := uint16(0xffff)
+	state := c.State()
 	t := time.Now()
+	var messageExpiry, frameCh <-chan time.Time
+	var frameAt, messageStart time.Time
+
+	// The display fades in from black when the clock starts.
+	bright := fade{to: state.Brightness, start: t, duration: c.Transitions().BrightnessFade}
+	var shown, target *image.NRGBA64 // what's on the display, and what it's heading towards.
+	var anim *animation
 
-	tickErrCh := make(chan error)
+	tickErrCh := make(chan error, 1)
 	tickCh := make(chan time.Time)
 	go func() {
-		err := Tick(ctx, tickCh)
-		select {
-		case tickErrCh <- err:
-		case <-ctx.Done():
-		}
+		tickErrCh <- Tick(ctx, tickCh)
 		close(tickErrCh)
-		close(tickCh)
 	}()
 	for {
+		ticked, framed := false, false
 		select {
+		case <-ctx.Done():
+			return fmt.Errorf("clock loop: %w", ctx.Err())
 		case t = <-tickCh:
+			ticked = true
+			c.stateMu.Lock()
+			alarms, loc := c.alarms, c.loc
+			c.stateMu.Unlock()
+			var label string
+			if alarms != nil {
+				if l, ringing := alarms.Tick(t.In(loc)); ringing {
+					label = l
+					if label == "" {
+						label = "ALARM"
+					}
+				}
+			}
+			if label != state.Alarm {
+				state = c.setState(func(s *State) { s.Alarm = label })
+			}
 		case err := <-tickErrCh:
 			return fmt.Errorf("ticker: %w", err)
-		case brightness = <-c.BrightnessCh:
+		case b := <-c.BrightnessCh:
+			now := time.Now()
+			bright = fade{from: bright.value(now), to: b, start: now, duration: c.Transitions().BrightnessFade}
+			state = c.setState(func(s *State) { s.Brightness = b })
+		case f := <-c.FaceCh:
+			if !c.HasFace(f) {
+				log.Printf("clock: ignoring request for unknown face %q", f)
+				continue
+			}
+			state = c.setState(func(s *State) { s.Face = f })
+		case m := <-c.MessageCh:
+			state = c.setState(func(s *State) { s.Message = m })
+			messageExpiry = nil
+			messageStart = time.Now()
+			if m != "" {
+				messageExpiry = time.After(messageDuration)
+			}
+		case <-messageExpiry:
+			state = c.setState(func(s *State) { s.Message = "" })
+			messageExpiry = nil
+		case tm := <-c.TimerCh:
+			if err := tm.Validate(); err != nil {
+				log.Printf("clock: ignoring invalid timer: %v", err)
+				continue
+			}
+			state = c.setState(func(s *State) { s.Timer = tm })
+		case u := <-c.timerUpdateCh:
+			tm, err := u.f(state.Timer)
+			if err == nil {
+				err = tm.Validate()
+			}
+			if err == nil {
+				state = c.setState(func(s *State) { s.Timer = tm })
+			}
+			u.resultCh <- timerResult{tm, err}
+		case <-frameCh:
+			framed = true
+		}
+
+		// Timers are drawn with the system time, rather than the last tick, and redrawn often
+		// enough to show tenths of a second.  Never draw a frame as of before the instant it
+		// was scheduled for, so that rounding can't show the previous tenth again.
+		now := time.Now()
+		if framed && now.Before(frameAt) {
+			now = frameAt
+		}
+		frameCh = nil
+		if !c.HasFace(state.Face) {
+			// The face file was reloaded without the face being shown.
+			state = c.setState(func(s *State) { s.Face = DefaultFace })
 		}
+		if state.Timer.Done(now) {
+			state = c.setState(func(s *State) { s.Timer = Timer{} })
+		}
+
+		// Everything is drawn at full brightness, and the (possibly fading) brightness applied
+		// to the final frame.
 		img := c.display.EmptyCanvas()
-		renderTime(img, t, color.NRGBA64{R: 0xffff, G: 0xffff, B: 0xffff, A: brightness})
-		c.display.Display(img)
+		fg := color.NRGBA64{R: 0xffff, G: 0xffff, B: 0xffff, A: 0xffff}
+		switch {
+		case state.Alarm != "":
+			renderAlarm(img, state.Alarm, t, fg)
+		case state.Message != "":
+			renderMessage(img, state.Message, now.Sub(messageStart), fg)
+		case state.Timer.Kind != NoTimer:
+			renderTimer(img, state.Timer, now, fg)
+		default:
+			c.renderFace(img, state.Face, t, fg)
+		}
+
+		// Animate changes of the image, except while a timer is running or a message is
+		// scrolling; they change more often than every second.  An animation caused by a tick
+		// starts exactly at the tick, so that the change is never late; one caused by anything
+		// else starts now.  Frames drawn for an animation don't change the target image, so
+		// only events can start one.
+		tr := c.Transitions()
+		scrolling := state.Alarm == "" && state.Message != "" && scrolls(state.Message, img.Bounds().Dx())
+		switch {
+		case tr.Style == NoTransition || state.Timer.Kind != NoTimer || scrolling || shown == nil:
+			anim = nil
+		case !framed && !sameImage(img, target):
+			start := now
+			if ticked {
+				start = t
+			}
+			anim = &animation{style: tr.Style, from: shown, to: img, start: start, duration: tr.Duration}
+		}
+		if !framed || anim == nil {
+			target = img
+		}
+		if anim != nil {
+			if anim.done(now) {
+				anim = nil
+			} else {
+				img = anim.frame(now)
+			}
+		}
+		shown = img
+
+		var next time.Time
+		if state.Timer.Kind != NoTimer {
+			next = state.Timer.nextFrame(now)
+		}
+		if scrolling {
+			if n := nextScrollFrame(messageStart, now); next.IsZero() || n.Before(next) {
+				next = n
+			}
+		}
+		if anim != nil || bright.active(now) {
+			if n := now.Truncate(transitionFrame).Add(transitionFrame); next.IsZero() || n.Before(next) {
+				next = n
+			}
+		}
+		if !next.IsZero() {
+			frameAt = next
+			frameCh = time.After(time.Until(frameAt))
+		}
+
+		img = withBrightness(img, bright.value(now))
+		if err := c.display.Display(img); err != nil {
+			log.Printf("clock: %v", err)
+			continue
+		}
+		if ticked {
+			c.stateMu.Lock()
+			c.lastTick = t
+			c.stateMu.Unlock()
+		}
 	}
 }
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"testing"
 	"time"
+
+	"github.com/jrockway/beaglebone-gps-clock/control/screen"
 )
 
 func TestTick(t *testing.T) {
@@ -75,3 +77,131 @@ func TestTick(t *testing.T) {
 		}
 	}
 }
+
+func TestRunState(t *testing.T) {
+	d, err := screen.NewScreen(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cl := New(d)
+	changes := make(chan State, 10)
+	cl.SetStateListener(func(s State) { changes <- s })
+	errCh := make(chan error)
+	go func() { errCh <- cl.Run(ctx) }()
+
+	cl.FaceCh <- "no-such-face"
+	cl.FaceCh <- "date"
+	if got, want := (<-changes).Face, "date"; got != want {
+		t.Errorf("face after change:\n  got: %v\n want: %v", got, want)
+	}
+	cl.MessageCh <- "hello"
+	if got, want := <-changes, (State{Face: "date", Brightness: 0xffff, Message: "hello"}); got != want {
+		t.Errorf("state after message:\n  got: %#v\n want: %#v", got, want)
+	}
+	cl.BrightnessCh <- 0x1000
+	if got, want := (<-changes).Brightness, uint16(0x1000); got != want {
+		t.Errorf("brightness after change:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := cl.State(), (State{Face: "date", Brightness: 0x1000, Message: "hello"}); got != want {
+		t.Errorf("final state:\n  got: %#v\n want: %#v", got, want)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for cl.LastTick().IsZero() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for a tick to be displayed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-errCh; !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error after cancel: %v", err)
+	}
+}
+
+func TestRunFaceRemoved(t *testing.T) {
+	d, err := screen.NewScreen(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cl := New(d)
+	ff, err := ParseFaceFile([]byte(`{"faces": [{"name": "mine"}]}`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	cl.SetFaceFile(ff)
+	changes := make(chan State, 10)
+	cl.SetStateListener(func(s State) { changes <- s })
+	go cl.Run(ctx) // nolint:errcheck
+
+	cl.FaceCh <- "mine"
+	if got, want := (<-changes).Face, "mine"; got != want {
+		t.Errorf("face after change:\n  got: %v\n want: %v", got, want)
+	}
+	cl.SetFaceFile(nil)
+	select {
+	case s := <-changes:
+		if got, want := s.Face, DefaultFace; got != want {
+			t.Errorf("face after removing it:\n  got: %v\n want: %v", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for the face to be reset")
+	}
+	if got, want := cl.State().Face, DefaultFace; got != want {
+		t.Errorf("final face:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+type alarmFunc func(t time.Time) (string, bool)
+
+func (f alarmFunc) Tick(t time.Time) (string, bool) { return f(t) }
+
+func TestRunAlarm(t *testing.T) {
+	d, err := screen.NewScreen(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cl := New(d)
+	cl.SetLocation(time.UTC)
+	ticks := make(chan time.Time, 10)
+	cl.SetAlarms(alarmFunc(func(t time.Time) (string, bool) {
+		ticks <- t
+		return "", true
+	}))
+	changes := make(chan State, 10)
+	cl.SetStateListener(func(s State) { changes <- s })
+	go cl.Run(ctx) // nolint:errcheck
+
+	select {
+	case s := <-changes:
+		if got, want := s.Alarm, "ALARM"; got != want {
+			t.Errorf("alarm label:\n  got: %v\n want: %v", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for alarm")
+	}
+	tick := <-ticks
+	if tick.Location() != time.UTC || tick.Nanosecond() != 0 {
+		t.Errorf("alarms ticked with %v; want a whole second in UTC", tick)
+	}
+
+	cl.SetAlarms(nil)
+	select {
+	case s := <-changes:
+		if s.Alarm != "" {
+			t.Errorf("alarm still ringing after removing alarms: %#v", s)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for alarm to stop")
+	}
+}
@@ -0,0 +1,398 @@
+package clock
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FaceFile describes faces without writing Go; run-clock loads one from the file named by
+// display.faces in its configuration.  A face file looks like this:
+//
+//	{"faces": [{
+//	    "name": "utc",
+//	    "regions": [
+//	        {"text": "{{.Time.Format \"15:04\"}}", "location": "UTC", "color": "#40c0ff"},
+//	        {"x": 32, "y": 2, "font": "3x5", "text": "UTC"},
+//	        {"x": 44, "width": 4, "text": "?", "color": "#ff0000", "when": "unsynced"}
+//	    ]
+//	}]}
+//
+// Each region's text is a text/template executed with FaceData.
+type FaceFile struct {
+	Faces []*FaceSpec `json:"faces"`
+}
+
+// FaceSpec is one face in a FaceFile.
+type FaceSpec struct {
+	Name    string    `json:"name"`
+	Regions []*Region `json:"regions"`
+}
+
+// Region is text drawn in a rectangle of a face.
+type Region struct {
+	X      int `json:"x"`      // Left edge.
+	Y      int `json:"y"`      // Top edge.
+	Width  int `json:"width"`  // Zero extends the region to the right edge of the display.
+	Height int `json:"height"` // Zero extends the region to the bottom edge of the display.
+
+	Text     string `json:"text"`     // A text/template, executed with FaceData.
+	Color    string `json:"color"`    // Like "#ff8000"; empty for white.
+	Font     string `json:"font"`     // "5x8" (the default, which the built-in faces use) or "3x5"; see fonts.
+	Align    string `json:"align"`    // "left" (the default), "center" or "right".
+	Location string `json:"location"` // The time zone of FaceData.Time; empty for the clock's.
+	When     string `json:"when"`     // Draw only when the clock is "synced" or "unsynced"; empty for always.
+
+	tmpl  *template.Template
+	color color.NRGBA64
+	font  *bitmapFont
+	loc   *time.Location
+}
+
+// FaceData is what region templates are executed with.
+type FaceData struct {
+	Time   time.Time // The time being shown, in the region's location.
+	Synced bool      // Whether the clock's time source is synchronized; see Clock.SetSynced.
+}
+
+// ParseFaceFile parses and validates a face file.
+func ParseFaceFile(data []byte) (*FaceFile, error) {
+	ff := new(FaceFile)
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err := d.Decode(ff); err != nil {
+		return nil, fmt.Errorf("decode face file: %w", err)
+	}
+	if err := ff.compile(); err != nil {
+		return nil, fmt.Errorf("validate face file: %w", err)
+	}
+	return ff, nil
+}
+
+// LoadFaceFile reads, parses and validates the face file at path.
+func LoadFaceFile(path string) (*FaceFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read face file: %w", err)
+	}
+	return ParseFaceFile(data)
+}
+
+// compile checks every face, and prepares its regions for drawing.
+func (ff *FaceFile) compile() error {
+	var errs []string
+	seen := map[string]bool{}
+	for i, f := range ff.Faces {
+		name := fmt.Sprintf("faces[%d]", i)
+		switch _, builtin := Faces[f.Name]; {
+		case f.Name == "":
+			errs = append(errs, name+": name must not be empty")
+		case builtin:
+			errs = append(errs, fmt.Sprintf("%s: %q is a built-in face", name, f.Name))
+		case seen[f.Name]:
+			errs = append(errs, fmt.Sprintf("%s: duplicate face %q", name, f.Name))
+		}
+		seen[f.Name] = true
+		for j, r := range f.Regions {
+			if err := r.compile(); err != nil {
+				errs = append(errs, fmt.Sprintf("%s.regions[%d]: %v", name, j, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (r *Region) compile() error {
+	if r.X < 0 || r.Y < 0 || r.Width < 0 || r.Height < 0 {
+		return fmt.Errorf("position and size must not be negative")
+	}
+	tmpl, err := template.New("text").Option("missingkey=error").Parse(r.Text)
+	if err != nil {
+		return fmt.Errorf("parse text: %w", err)
+	}
+	r.tmpl = tmpl
+	r.color = color.NRGBA64{R: 0xffff, G: 0xffff, B: 0xffff, A: 0xffff}
+	if r.Color != "" {
+		c, err := parseColor(r.Color)
+		if err != nil {
+			return err
+		}
+		r.color = c
+	}
+	name := r.Font
+	if name == "" {
+		name = "5x8"
+	}
+	f, ok := fonts[name]
+	if !ok {
+		return fmt.Errorf("unknown font %q", r.Font)
+	}
+	r.font = f
+	switch r.Align {
+	case "", "left", "center", "right":
+	default:
+		return fmt.Errorf("unknown alignment %q", r.Align)
+	}
+	if r.Location != "" {
+		loc, err := time.LoadLocation(r.Location)
+		if err != nil {
+			return fmt.Errorf("location: %w", err)
+		}
+		r.loc = loc
+	}
+	switch r.When {
+	case "", "synced", "unsynced":
+	default:
+		return fmt.Errorf("unknown condition %q; want synced or unsynced", r.When)
+	}
+	// Catch mistakes like {{.Tim}} now, rather than on every tick.
+	if _, err := r.text(FaceData{Time: time.Now()}); err != nil {
+		return err
+	}
+	return r.checkGlyphs()
+}
+
+// checkGlyphs returns an error if the region's text could contain characters that its font can't
+// draw.  The text is tried at a time in every month, on every day of the week, in the morning and
+// afternoon, so that names from Time.Format are all seen.  Zone abbreviations are only checked for
+// the region's own location, but they're made of letters, digits, "+" and "-", which both fonts
+// have.
+func (r *Region) checkGlyphs() error {
+	loc := r.loc
+	if loc == nil {
+		loc = time.UTC
+	}
+	missing := map[rune]bool{}
+	var list []string
+	for month := time.January; month <= time.December; month++ {
+		for day := 1; day <= 7; day++ {
+			for _, hour := range []int{1, 13} {
+				for _, synced := range []bool{false, true} {
+					s, err := r.text(FaceData{Time: time.Date(2021, month, day, hour, 0, 0, 0, loc), Synced: synced})
+					if err != nil {
+						return err
+					}
+					for _, c := range s {
+						if _, ok := r.font.glyph(c); !ok && !missing[c] {
+							missing[c] = true
+							list = append(list, fmt.Sprintf("%q", c))
+						}
+					}
+				}
+			}
+		}
+	}
+	if len(list) > 0 {
+		return fmt.Errorf("text can contain characters that the font doesn't have: %s", strings.Join(list, ", "))
+	}
+	return nil
+}
+
+// parseColor parses a color like "#ff8000".
+func parseColor(s string) (color.NRGBA64, error) {
+	if len(s) != 7 || s[0] != '#' {
+		return color.NRGBA64{}, fmt.Errorf("color %q must look like #rrggbb", s)
+	}
+	v, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return color.NRGBA64{}, fmt.Errorf("color %q must look like #rrggbb", s)
+	}
+	return color.NRGBA64{
+		R: uint16(v>>16&0xff) * 0x101,
+		G: uint16(v>>8&0xff) * 0x101,
+		B: uint16(v&0xff) * 0x101,
+		A: 0xffff,
+	}, nil
+}
+
+func (r *Region) text(data FaceData) (string, error) {
+	buf := new(strings.Builder)
+	if err := r.tmpl.Execute(buf, data); err != nil {
+		return "", fmt.Errorf("execute text: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// Render draws the face for time t, in the clock's location, onto img.  Regions whose templates
+// fail are left out.
+func (f *FaceSpec) Render(img *image.NRGBA64, t time.Time, synced bool) {
+	for _, r := range f.Regions {
+		if (r.When == "synced" && !synced) || (r.When == "unsynced" && synced) {
+			continue
+		}
+		data := FaceData{Time: t, Synced: synced}
+		if r.loc != nil {
+			data.Time = t.In(r.loc)
+		}
+		s, err := r.text(data)
+		if err != nil {
+			continue
+		}
+		bounds := img.Bounds()
+		clip := image.Rect(r.X, r.Y, bounds.Max.X, bounds.Max.Y)
+		if r.Width > 0 {
+			clip.Max.X = r.X + r.Width
+		}
+		if r.Height > 0 {
+			clip.Max.Y = r.Y + r.Height
+		}
+		clip = clip.Intersect(bounds)
+		if clip.Empty() {
+			continue
+		}
+		x := clip.Min.X
+		switch w := r.font.textWidth(s); r.Align {
+		case "center":
+			x += (clip.Dx() - w) / 2
+		case "right":
+			x += clip.Dx() - w
+		}
+		r.font.draw(img.SubImage(clip).(*image.NRGBA64), s, x, clip.Min.Y, r.color)
+	}
+}
+
+// bitmapFont is a fixed-width font whose glyphs are rows of bits, most significant bit on the
+// left.
+type bitmapFont struct {
+	width, height int
+	advance       int // Distance between the left edges of characters.
+	glyphs        map[rune][]uint8
+}
+
+// fonts are the fonts that regions can use.  "5x8" has every printable ASCII character.  "3x5" has
+// digits, capital letters (lowercase letters are drawn as capitals), space, and . : - + / ? , ' % ( )
+// _ = !.
+var fonts = map[string]*bitmapFont{
+	"5x8": convertFace5x8(),
+	"3x5": {
+		width:   3,
+		height:  5,
+		advance: 4,
+		glyphs: map[rune][]uint8{
+			' ':  {0, 0, 0, 0, 0},
+			'0':  {7, 5, 5, 5, 7},
+			'1':  {2, 6, 2, 2, 7},
+			'2':  {7, 1, 7, 4, 7},
+			'3':  {7, 1, 7, 1, 7},
+			'4':  {5, 5, 7, 1, 1},
+			'5':  {7, 4, 7, 1, 7},
+			'6':  {7, 4, 7, 5, 7},
+			'7':  {7, 1, 1, 1, 1},
+			'8':  {7, 5, 7, 5, 7},
+			'9':  {7, 5, 7, 1, 7},
+			':':  {0, 2, 0, 2, 0},
+			'.':  {0, 0, 0, 0, 2},
+			'-':  {0, 0, 7, 0, 0},
+			'+':  {0, 2, 7, 2, 0},
+			'/':  {1, 1, 2, 4, 4},
+			'?':  {7, 1, 3, 0, 2},
+			',':  {0, 0, 0, 2, 4},
+			'\'': {2, 2, 0, 0, 0},
+			'%':  {5, 1, 2, 4, 5},
+			'(':  {1, 2, 2, 2, 1},
+			')':  {4, 2, 2, 2, 4},
+			'_':  {0, 0, 0, 0, 7},
+			'=':  {0, 7, 0, 7, 0},
+			'!':  {2, 2, 2, 0, 2},
+			'A':  {2, 5, 7, 5, 5},
+			'B':  {6, 5, 6, 5, 6},
+			'C':  {7, 4, 4, 4, 7},
+			'D':  {6, 5, 5, 5, 6},
+			'E':  {7, 4, 6, 4, 7},
+			'F':  {7, 4, 6, 4, 4},
+			'G':  {7, 4, 5, 5, 7},
+			'H':  {5, 5, 7, 5, 5},
+			'I':  {7, 2, 2, 2, 7},
+			'J':  {1, 1, 1, 5, 7},
+			'K':  {5, 5, 6, 5, 5},
+			'L':  {4, 4, 4, 4, 7},
+			'M':  {5, 7, 7, 5, 5},
+			'N':  {6, 5, 5, 5, 5},
+			'O':  {2, 5, 5, 5, 2},
+			'P':  {7, 5, 7, 4, 4},
+			'Q':  {2, 5, 5, 6, 3},
+			'R':  {6, 5, 6, 5, 5},
+			'S':  {7, 4, 7, 1, 7},
+			'T':  {7, 2, 2, 2, 2},
+			'U':  {5, 5, 5, 5, 7},
+			'V':  {5, 5, 5, 5, 2},
+			'W':  {5, 5, 7, 7, 5},
+			'X':  {5, 5, 2, 5, 5},
+			'Y':  {5, 5, 2, 2, 2},
+			'Z':  {7, 1, 2, 4, 7},
+		},
+	},
+}
+
+// convertFace5x8 converts the font that the built-in faces use, so that face files look the same.
+func convertFace5x8() *bitmapFont {
+	f := &bitmapFont{width: face5x8.Width, height: face5x8.Height, advance: face5x8.Advance, glyphs: map[rune][]uint8{}}
+	for r := face5x8.Ranges[0].Low; r < face5x8.Ranges[0].High; r++ {
+		img := image.NewNRGBA64(image.Rect(0, 0, f.width, f.height))
+		renderText(img, string(r), color.White)
+		rows := make([]uint8, f.height)
+		for y := 0; y < f.height; y++ {
+			for x := 0; x < f.width; x++ {
+				if _, _, _, a := img.At(x, y).RGBA(); a > 0 {
+					rows[y] |= 1 << uint(f.width-1-x)
+				}
+			}
+		}
+		f.glyphs[r] = rows
+	}
+	return f
+}
+
+// textWidth returns the width of s in pixels, not counting the space after the last character.
+func (f *bitmapFont) textWidth(s string) int {
+	n := utf8.RuneCountInString(s)
+	if n == 0 {
+		return 0
+	}
+	return n*f.advance - (f.advance - f.width)
+}
+
+// glyph returns the glyph for r, using the capital letter for a lowercase one that the font
+// doesn't have.
+func (f *bitmapFont) glyph(r rune) ([]uint8, bool) {
+	if g, ok := f.glyphs[r]; ok {
+		return g, true
+	}
+	g, ok := f.glyphs[unicode.ToUpper(r)]
+	return g, ok
+}
+
+// draw draws s with its top left corner at (x, y).  Characters that the font doesn't have are
+// drawn as "?", or left blank if it doesn't have that either.
+func (f *bitmapFont) draw(img *image.NRGBA64, s string, x, y int, c color.NRGBA64) {
+	for _, r := range s {
+		g, ok := f.glyph(r)
+		if !ok {
+			g = f.glyphs['?']
+		}
+		for dy, row := range g {
+			for dx := 0; dx < f.width; dx++ {
+				if row&(1<<uint(f.width-1-dx)) == 0 {
+					continue
+				}
+				if p := image.Pt(x+dx, y+dy); p.In(img.Rect) {
+					img.SetNRGBA64(p.X, p.Y, c)
+				}
+			}
+		}
+		x += f.advance
+	}
+}
@@ -0,0 +1,199 @@
+package clock
+
+import (
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jrockway/beaglebone-gps-clock/control/screen"
+)
+
+// ascii draws the first w columns of img as text, with "#" for lit pixels.
+func ascii(img *image.NRGBA64, w int) string {
+	var b strings.Builder
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Min.X+w; x++ {
+			if c := img.NRGBA64At(x, y); c.R|c.G|c.B != 0 {
+				b.WriteByte('#')
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func TestParseFaceFile(t *testing.T) {
+	testData := []struct {
+		name    string
+		in      string
+		wantErr string
+	}{
+		{
+			name: "ok",
+			in:   `{"faces": [{"name": "utc", "regions": [{"text": "{{.Time.Format \"15:04\"}}", "location": "UTC", "color": "#40c0ff", "font": "3x5", "align": "right", "when": "synced"}]}]}`,
+		},
+		{
+			name:    "typo",
+			in:      `{"faces": [{"name": "x", "regions": [{"txet": "hi"}]}]}`,
+			wantErr: `unknown field "txet"`,
+		},
+		{
+			name:    "built-in name",
+			in:      `{"faces": [{"name": "time"}]}`,
+			wantErr: `faces[0]: "time" is a built-in face`,
+		},
+		{
+			name:    "duplicate",
+			in:      `{"faces": [{"name": "x"}, {"name": "x"}]}`,
+			wantErr: `faces[1]: duplicate face "x"`,
+		},
+		{
+			name:    "bad template",
+			in:      `{"faces": [{"name": "x", "regions": [{"text": "{{.Time.Format"}]}]}`,
+			wantErr: "faces[0].regions[0]: parse text",
+		},
+		{
+			name:    "bad field",
+			in:      `{"faces": [{"name": "x", "regions": [{"text": "{{.Tim}}"}]}]}`,
+			wantErr: "faces[0].regions[0]: execute text",
+		},
+		{
+			name: "names in small font",
+			in:   `{"faces": [{"name": "x", "regions": [{"text": "{{.Time.Format \"Mon Jan 2 3:04PM MST\"}}", "location": "America/New_York", "font": "3x5"}]}]}`,
+		},
+		{
+			name:    "missing glyphs",
+			in:      `{"faces": [{"name": "x", "regions": [{"text": "#{{if .Synced}}*{{end}}{{.Time.Format \"15\"}}", "font": "3x5"}]}]}`,
+			wantErr: `faces[0].regions[0]: text can contain characters that the font doesn't have: '#', '*'`,
+		},
+		{
+			name:    "several problems",
+			in:      `{"faces": [{"name": "x", "regions": [{"color": "orange"}, {"font": "comic sans"}, {"location": "Mars/Olympus_Mons"}, {"when": "sometimes"}, {"x": -1}]}]}`,
+			wantErr: `faces[0].regions[0]: color "orange" must look like #rrggbb; faces[0].regions[1]: unknown font "comic sans"; faces[0].regions[2]: location: unknown time zone Mars/Olympus_Mons; faces[0].regions[3]: unknown condition "sometimes"; want synced or unsynced; faces[0].regions[4]: position and size must not be negative`,
+		},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseFaceFile([]byte(test.in))
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("parse: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("parse: unexpected error:\n  got: %v\n want: ...%s...", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestRenderFace(t *testing.T) {
+	ff, err := ParseFaceFile([]byte(`{"faces": [{"name": "test", "regions": [
+		{"font": "3x5", "text": "{{.Time.Format \"4\"}}"},
+		{"font": "3x5", "width": 11, "align": "right", "text": "{{.Time.Format \"15\"}}", "location": "Asia/Tokyo"},
+		{"y": 5, "width": 3, "font": "3x5", "text": "-", "when": "unsynced"},
+		{"y": 5, "width": 3, "font": "3x5", "text": "+", "when": "synced"}
+	]}]}`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	at := time.Date(2021, 10, 1, 12, 7, 0, 0, time.UTC)
+	testData := []struct {
+		synced bool
+		want   string
+	}{
+		{
+			synced: true,
+			want: "" +
+				"###.###..#.\n" +
+				"..#...#.##.\n" +
+				"..#.###..#.\n" +
+				"..#.#....#.\n" +
+				"..#.###.###\n" +
+				"...........\n" +
+				".#.........\n" +
+				"###........\n",
+		},
+		{
+			synced: false,
+			want: "" +
+				"###.###..#.\n" +
+				"..#...#.##.\n" +
+				"..#.###..#.\n" +
+				"..#.#....#.\n" +
+				"..#.###.###\n" +
+				"...........\n" +
+				"...........\n" +
+				"###........\n",
+		},
+	}
+	for _, test := range testData {
+		img := image.NewNRGBA64(image.Rect(0, 0, 48, 8))
+		ff.Faces[0].Render(img, at, test.synced)
+		if got, want := ascii(img, 11), test.want; got != want {
+			t.Errorf("synced=%v:\n  got:\n%s\n want:\n%s", test.synced, got, want)
+		}
+	}
+}
+
+func TestFaceHandler(t *testing.T) {
+	s, err := screen.NewScreen(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := New(s)
+	ff, err := ParseFaceFile([]byte(`{"faces": [{"name": "mine"}]}`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	cl.SetFaceFile(ff)
+	if !cl.HasFace("mine") || !cl.HasFace("date") || cl.HasFace("yours") {
+		t.Error("HasFace doesn't know about the face file")
+	}
+	h := cl.FaceHandler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got, want := rec.Body.String(), `["date","mine","time"]`+"\n"; got != want {
+		t.Errorf("face names:\n  got: %v\n want: %v", got, want)
+	}
+
+	body := `{"faces": [{"name": "a"}, {"name": "b", "regions": [{"text": "{{.Time.Format \"15:04\"}}"}]}]}`
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/preview?face=b&scale=2&time=2021-10-01T12:00:00Z", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("preview: status %d: %s", rec.Code, rec.Body.String())
+	}
+	img, err := png.Decode(rec.Body)
+	if err != nil {
+		t.Fatalf("decode preview: %v", err)
+	}
+	if got, want := img.Bounds(), image.Rect(0, 0, 96, 16); got != want {
+		t.Errorf("preview size:\n  got: %v\n want: %v", got, want)
+	}
+
+	for _, target := range []string{"/preview?face=c", "/preview?time=noon", "/preview?scale=0"} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, strings.NewReader(body)))
+		if got, want := rec.Code, http.StatusBadRequest; got != want {
+			t.Errorf("%s: status:\n  got: %v\n want: %v", target, got, want)
+		}
+	}
+}
+
+func TestExampleFaceFile(t *testing.T) {
+	ff, err := LoadFaceFile("../../etc/gps-clock-faces.json")
+	if err != nil {
+		t.Fatalf("load example: %v", err)
+	}
+	if len(ff.Faces) == 0 {
+		t.Error("example has no faces")
+	}
+}
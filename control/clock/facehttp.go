@@ -0,0 +1,128 @@
+package clock
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// maxFaceFileSize is the largest face file that the preview endpoint accepts.
+const maxFaceFileSize = 1 << 20
+
+// FaceHandler returns an HTTP handler for working on face files, meant to be mounted with
+// http.StripPrefix.  Previews never touch the display.
+//
+//	GET  /         the names of the faces that FaceCh accepts, as JSON
+//	POST /preview  render a face file, sent as the body, as a PNG; optional form values are
+//	               face=<name> (default the first face), time=<RFC3339 time> (default now),
+//	               synced=false (default true) and scale=<pixels per LED> (default 10)
+func (c *Clock) FaceHandler() http.Handler {
+	return http.HandlerFunc(c.serveFaces)
+}
+
+func (c *Clock) serveFaces(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "", "/":
+		if req.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var names []string
+		for name := range Faces {
+			names = append(names, name)
+		}
+		c.stateMu.Lock()
+		for name := range c.custom {
+			names = append(names, name)
+		}
+		c.stateMu.Unlock()
+		sort.Strings(names)
+		w.Header().Set("content-type", "application/json")
+		if err := json.NewEncoder(w).Encode(names); err != nil {
+			log.Printf("faces: encode response: %v", err)
+		}
+	case "/preview":
+		if req.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		img, err := c.preview(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("content-type", "image/png")
+		if err := png.Encode(w, img); err != nil {
+			log.Printf("faces: encode preview: %v", err)
+		}
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// preview renders the face that a preview request asks for.
+func (c *Clock) preview(req *http.Request) (image.Image, error) {
+	data, err := io.ReadAll(io.LimitReader(req.Body, maxFaceFileSize))
+	if err != nil {
+		return nil, fmt.Errorf("read face file: %w", err)
+	}
+	ff, err := ParseFaceFile(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(ff.Faces) == 0 {
+		return nil, fmt.Errorf("face file has no faces")
+	}
+	spec := ff.Faces[0]
+	if name := req.URL.Query().Get("face"); name != "" {
+		spec = nil
+		for _, f := range ff.Faces {
+			if f.Name == name {
+				spec = f
+			}
+		}
+		if spec == nil {
+			return nil, fmt.Errorf("face file has no face %q", name)
+		}
+	}
+	t := time.Now()
+	if v := req.URL.Query().Get("time"); v != "" {
+		if t, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, fmt.Errorf("parse time: %w", err)
+		}
+	}
+	synced := true
+	if v := req.URL.Query().Get("synced"); v != "" {
+		if synced, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("parse synced: %w", err)
+		}
+	}
+	scale := 10
+	if v := req.URL.Query().Get("scale"); v != "" {
+		if scale, err = strconv.Atoi(v); err != nil || scale < 1 || scale > 50 {
+			return nil, fmt.Errorf("scale %q must be a number from 1 to 50", v)
+		}
+	}
+	img := c.display.EmptyCanvas()
+	spec.Render(img, t.In(c.Location()), synced)
+	return scaleImage(img, scale), nil
+}
+
+// scaleImage enlarges img by an integer factor, so that each LED is easy to see.
+func scaleImage(img *image.NRGBA64, scale int) *image.NRGBA64 {
+	b := img.Bounds()
+	out := image.NewNRGBA64(image.Rect(0, 0, b.Dx()*scale, b.Dy()*scale))
+	for y := 0; y < out.Rect.Dy(); y++ {
+		for x := 0; x < out.Rect.Dx(); x++ {
+			out.SetNRGBA64(x, y, img.NRGBA64At(b.Min.X+x/scale, b.Min.Y+y/scale))
+		}
+	}
+	return out
+}
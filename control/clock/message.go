@@ -0,0 +1,72 @@
+package clock
+
+import (
+	"image"
+	"image/color"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// scrollStep is how long a scrolling message takes to move one pixel.
+	scrollStep = 50 * time.Millisecond
+	// scrollPause is how long a scrolling message stays still at each end.
+	scrollPause = time.Second
+	// minReadTime is how long it takes to read a message that fits on the display.
+	minReadTime = 2 * time.Second
+)
+
+// textWidth returns the width of s in pixels.
+func textWidth(s string) int {
+	return utf8.RuneCountInString(s) * face5x8.Advance
+}
+
+// scrollPeriod returns how long one pass of scrolling a message by travel pixels takes, including
+// the pauses at each end; the message then jumps back to the start.
+func scrollPeriod(travel int) time.Duration {
+	return 2*scrollPause + time.Duration(travel)*scrollStep
+}
+
+// scrollOffset returns how far left a message that's shown on a display width pixels wide should
+// be drawn, elapsed after it was first shown.  Messages that fit aren't scrolled.  Messages that
+// don't fit pause at the start, scroll left until their end is visible, pause again, and then
+// start over.
+func scrollOffset(s string, width int, elapsed time.Duration) int {
+	travel := textWidth(s) - width
+	if travel <= 0 {
+		return 0
+	}
+	e := elapsed % scrollPeriod(travel)
+	switch {
+	case e < scrollPause:
+		return 0
+	case e >= scrollPause+time.Duration(travel)*scrollStep:
+		return travel
+	}
+	return int((e - scrollPause) / scrollStep)
+}
+
+// scrolls returns true if s is too wide to fit on a display width pixels wide.
+func scrolls(s string, width int) bool {
+	return textWidth(s) > width
+}
+
+// nextScrollFrame returns when a message that was first shown at start next moves.
+func nextScrollFrame(start, now time.Time) time.Time {
+	return start.Add((now.Sub(start)/scrollStep + 1) * scrollStep)
+}
+
+// renderMessage draws a message that was first shown elapsed ago.
+func renderMessage(img *image.NRGBA64, s string, elapsed time.Duration, c color.Color) {
+	renderTextAt(img, s, -scrollOffset(s, img.Bounds().Dx(), elapsed), c)
+}
+
+// MessageReadTime returns how long a message sent to MessageCh should be left on the display for
+// someone to read it; for a message that scrolls, that's long enough for it to scroll past once.
+func (c *Clock) MessageReadTime(s string) time.Duration {
+	travel := textWidth(s) - c.display.EmptyCanvas().Bounds().Dx()
+	if travel <= 0 {
+		return minReadTime
+	}
+	return scrollPeriod(travel)
+}
@@ -0,0 +1,47 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScrollOffset(t *testing.T) {
+	const width = 48
+	long := "IP 192.168.100.200" // 90 pixels wide; travels 42.
+	testData := []struct {
+		name    string
+		msg     string
+		elapsed time.Duration
+		want    int
+	}{
+		{"fits", "HELLO", time.Hour, 0},
+		{"exactly fits", "123456789", time.Hour, 0},
+		{"pause at start", long, 999 * time.Millisecond, 0},
+		{"first step", long, scrollPause + scrollStep, 1},
+		{"half way", long, scrollPause + 21*scrollStep, 21},
+		{"end", long, scrollPause + 42*scrollStep, 42},
+		{"pause at end", long, 2*scrollPause + 42*scrollStep - 1, 42},
+		{"start over", long, 2*scrollPause + 42*scrollStep, 0},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			if got, want := scrollOffset(test.msg, width, test.elapsed), test.want; got != want {
+				t.Errorf("offset after %v:\n  got: %v\n want: %v", test.elapsed, got, want)
+			}
+		})
+	}
+}
+
+func TestNextScrollFrame(t *testing.T) {
+	for _, test := range []struct {
+		at, want time.Duration
+	}{
+		{0, scrollStep},
+		{scrollStep - 1, scrollStep},
+		{scrollStep, 2 * scrollStep},
+	} {
+		if got, want := nextScrollFrame(epoch, epoch.Add(test.at)), epoch.Add(test.want); !got.Equal(want) {
+			t.Errorf("next frame after %v:\n  got: %v\n want: %v", test.at, got, want)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package clock
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"time"
+)
+
+// Theme colors the built-in faces, which are drawn in white.  It returns the color of column x of
+// a display width pixels wide when showing time t; lit pixels are multiplied by it.  The screen
+// package's color correction and power limiting apply to the result like any other image.
+type Theme func(x, width int, t time.Time) color.NRGBA64
+
+// Themes are the themes that can be selected with Clock.SetTheme.
+var Themes = map[string]Theme{
+	"white":    nil,
+	"digits":   digitsTheme,
+	"gradient": gradientTheme,
+	"daylight": daylightTheme,
+}
+
+// DefaultTheme is the theme that a new Clock uses.
+const DefaultTheme = "white"
+
+// ThemeNames returns the names of the themes, sorted.
+func ThemeNames() []string {
+	var names []string
+	for name := range Themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetTheme changes the theme of the built-in faces.
+func (c *Clock) SetTheme(name string) error {
+	theme, ok := Themes[name]
+	if !ok {
+		return fmt.Errorf("unknown theme %q", name)
+	}
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	c.theme = theme
+	return nil
+}
+
+// applyTheme multiplies every pixel of img by the theme's color.
+func applyTheme(img *image.NRGBA64, theme Theme, t time.Time) {
+	if theme == nil {
+		return
+	}
+	b := img.Bounds()
+	for x := b.Min.X; x < b.Max.X; x++ {
+		c := theme(x-b.Min.X, b.Dx(), t)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			p := img.NRGBA64At(x, y)
+			if p.R|p.G|p.B == 0 {
+				continue
+			}
+			img.SetNRGBA64(x, y, color.NRGBA64{
+				R: uint16(uint32(p.R) * uint32(c.R) / 0xffff),
+				G: uint16(uint32(p.G) * uint32(c.G) / 0xffff),
+				B: uint16(uint32(p.B) * uint32(c.B) / 0xffff),
+				A: p.A,
+			})
+		}
+	}
+}
+
+// digitPalette colors the characters of the time face: warm hours, green minutes and cool
+// seconds, with dimmer separators.
+var digitPalette = []color.NRGBA64{
+	{R: 0xffff, G: 0x6000, B: 0x0000, A: 0xffff},
+	{R: 0xffff, G: 0xa000, B: 0x0000, A: 0xffff},
+	{R: 0x8000, G: 0x8000, B: 0x8000, A: 0xffff},
+	{R: 0x4000, G: 0xffff, B: 0x2000, A: 0xffff},
+	{R: 0x0000, G: 0xffff, B: 0x8000, A: 0xffff},
+	{R: 0x8000, G: 0x8000, B: 0x8000, A: 0xffff},
+	{R: 0x0000, G: 0xa000, B: 0xffff, A: 0xffff},
+	{R: 0x6000, G: 0x4000, B: 0xffff, A: 0xffff},
+}
+
+// digitsTheme gives each character of the face its own color.
+func digitsTheme(x, width int, t time.Time) color.NRGBA64 {
+	return digitPalette[(x/face5x8.Advance)%len(digitPalette)]
+}
+
+// gradientStart and gradientEnd are the colors at the left and right edges of the gradient theme.
+var (
+	gradientStart = color.NRGBA64{R: 0xffff, G: 0x2000, B: 0x8000, A: 0xffff}
+	gradientEnd   = color.NRGBA64{R: 0x2000, G: 0x8000, B: 0xffff, A: 0xffff}
+)
+
+// gradientTheme fades from one color to another from left to right.
+func gradientTheme(x, width int, t time.Time) color.NRGBA64 {
+	var p float64
+	if width > 1 {
+		p = float64(x) / float64(width-1)
+	}
+	mix := func(a, b uint16) uint16 {
+		return uint16(float64(a) + p*(float64(b)-float64(a)))
+	}
+	return color.NRGBA64{
+		R: mix(gradientStart.R, gradientEnd.R),
+		G: mix(gradientStart.G, gradientEnd.G),
+		B: mix(gradientStart.B, gradientEnd.B),
+		A: 0xffff,
+	}
+}
+
+const (
+	// nightKelvin and noonKelvin are the color temperatures of the daylight theme at midnight
+	// and noon.
+	nightKelvin = 1900
+	noonKelvin  = 6500
+)
+
+// daylightTheme shifts the color temperature through the day, from warm at midnight to cool at
+// noon, following a cosine so that it changes slowly near the extremes.
+func daylightTheme(x, width int, t time.Time) color.NRGBA64 {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	day := t.Sub(midnight).Hours() / 24
+	k := nightKelvin + (noonKelvin-nightKelvin)*(1-math.Cos(2*math.Pi*day))/2
+	return kelvin(k)
+}
+
+// kelvin returns the color of a black body at temperature k, scaled so that the brightest
+// channel is fully on.  It uses Tanner Helland's fit to the CIE data, which is good between 1000K
+// and 40000K.
+func kelvin(k float64) color.NRGBA64 {
+	t := k / 100
+	var r, g, b float64
+	if t <= 66 {
+		r = 255
+		g = 99.4708025861*math.Log(t) - 161.1195681661
+	} else {
+		r = 329.698727446 * math.Pow(t-60, -0.1332047592)
+		g = 288.1221695283 * math.Pow(t-60, -0.0755148492)
+	}
+	switch {
+	case t >= 66:
+		b = 255
+	case t <= 19:
+		b = 0
+	default:
+		b = 138.5177312231*math.Log(t-10) - 305.0447927307
+	}
+	clamp := func(v float64) uint16 {
+		return uint16(math.Max(0, math.Min(255, v)) / 255 * 0xffff)
+	}
+	return color.NRGBA64{R: clamp(r), G: clamp(g), B: clamp(b), A: 0xffff}
+}
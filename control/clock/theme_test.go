@@ -0,0 +1,77 @@
+package clock
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+)
+
+func TestKelvin(t *testing.T) {
+	warm, neutral, cool := kelvin(nightKelvin), kelvin(noonKelvin), kelvin(10000)
+	if warm.R != 0xffff || warm.B > warm.G || warm.G > warm.R {
+		t.Errorf("%vK isn't orange: %v", nightKelvin, warm)
+	}
+	if neutral.R < 0xf000 || neutral.G < 0xe000 || neutral.B < 0xe000 {
+		t.Errorf("%vK isn't nearly white: %v", noonKelvin, neutral)
+	}
+	if cool.B != 0xffff || cool.R > cool.B {
+		t.Errorf("10000K isn't blue: %v", cool)
+	}
+}
+
+func TestDaylightTheme(t *testing.T) {
+	day := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	midnight, morning, noon := daylightTheme(0, 48, day), daylightTheme(0, 48, day.Add(6*time.Hour)), daylightTheme(0, 48, day.Add(12*time.Hour))
+	if got, want := midnight, kelvin(nightKelvin); got != want {
+		t.Errorf("midnight:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := noon, kelvin(noonKelvin); got != want {
+		t.Errorf("noon:\n  got: %v\n want: %v", got, want)
+	}
+	if !(midnight.B < morning.B && morning.B < noon.B) {
+		t.Errorf("blue doesn't increase through the morning: %v, %v, %v", midnight.B, morning.B, noon.B)
+	}
+}
+
+func TestApplyTheme(t *testing.T) {
+	img := image.NewNRGBA64(image.Rect(0, 0, 48, 1))
+	img.SetNRGBA64(0, 0, color.NRGBA64{R: 0xffff, G: 0xffff, B: 0xffff, A: 0xffff})
+	img.SetNRGBA64(6, 0, color.NRGBA64{R: 0x8000, G: 0x8000, B: 0x8000, A: 0xffff})
+	img.SetNRGBA64(47, 0, color.NRGBA64{R: 0xffff, G: 0xffff, B: 0xffff, A: 0xffff})
+
+	digits := image.NewNRGBA64(img.Rect)
+	copy(digits.Pix, img.Pix)
+	applyTheme(digits, digitsTheme, epoch)
+	if got, want := digits.NRGBA64At(0, 0), digitPalette[0]; got != want {
+		t.Errorf("first digit:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := digits.NRGBA64At(6, 0), (color.NRGBA64{R: 0x8000, G: 0x5000, B: 0, A: 0xffff}); got != want {
+		t.Errorf("dim pixel in second digit:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := digits.NRGBA64At(1, 0), (color.NRGBA64{}); got != want {
+		t.Errorf("unlit pixel:\n  got: %v\n want: %v", got, want)
+	}
+
+	gradient := image.NewNRGBA64(img.Rect)
+	copy(gradient.Pix, img.Pix)
+	applyTheme(gradient, gradientTheme, epoch)
+	if got, want := gradient.NRGBA64At(0, 0), gradientStart; got != want {
+		t.Errorf("left edge of gradient:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := gradient.NRGBA64At(47, 0), gradientEnd; got != want {
+		t.Errorf("right edge of gradient:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func TestSetTheme(t *testing.T) {
+	cl := &Clock{}
+	for _, name := range ThemeNames() {
+		if err := cl.SetTheme(name); err != nil {
+			t.Errorf("theme %q: %v", name, err)
+		}
+	}
+	if err := cl.SetTheme("plaid"); err == nil {
+		t.Error("unknown theme: expected error")
+	}
+}
@@ -0,0 +1,195 @@
+package clock
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"time"
+)
+
+// TimerKind selects what a Timer measures.
+type TimerKind string
+
+const (
+	// NoTimer means that the clock shows its face.
+	NoTimer TimerKind = ""
+	// Countdown counts down to Target, then flashes.
+	Countdown TimerKind = "countdown"
+	// Stopwatch counts up from Start.
+	Stopwatch TimerKind = "stopwatch"
+	// Interval counts down to the next multiple of Every after Start, forever, changing color
+	// briefly each time it reaches zero.
+	Interval TimerKind = "interval"
+)
+
+const (
+	// timerFrame is how often the display is redrawn while a timer is running, so that tenths of
+	// a second are shown accurately.
+	timerFrame = 100 * time.Millisecond
+	// expiredFlashDuration is how long an expired countdown flashes before the clock goes back
+	// to its face.
+	expiredFlashDuration = 30 * time.Second
+	// flashPeriod is the on/off period of the expired countdown's flashing.
+	flashPeriod = 500 * time.Millisecond
+	// intervalHighlight is how long an interval timer changes color after reaching zero.
+	intervalHighlight = time.Second
+)
+
+// Timer is a countdown, stopwatch, or interval timer shown instead of the clock face.  The zero
+// value is no timer.
+type Timer struct {
+	Kind   TimerKind     `json:"kind"`
+	Target time.Time     `json:"target,omitempty"` // Countdown: when the countdown reaches zero.
+	Start  time.Time     `json:"start,omitempty"`  // Stopwatch, Interval: when the timer started.
+	Every  time.Duration `json:"every,omitempty"`  // Interval: the period.
+	// Stopped is when a stopwatch was paused; zero while it's running.
+	Stopped time.Time `json:"stopped,omitempty"`
+}
+
+// NewCountdown returns a timer that counts down to target.
+func NewCountdown(target time.Time) Timer {
+	return Timer{Kind: Countdown, Target: target}
+}
+
+// NewStopwatch returns a running stopwatch that started at start.
+func NewStopwatch(start time.Time) Timer {
+	return Timer{Kind: Stopwatch, Start: start}
+}
+
+// NewInterval returns a timer that reaches zero every period, starting at start.
+func NewInterval(start time.Time, every time.Duration) Timer {
+	return Timer{Kind: Interval, Start: start, Every: every}
+}
+
+// Validate returns an error if the timer can't be displayed.
+func (tm Timer) Validate() error {
+	switch tm.Kind {
+	case NoTimer:
+	case Countdown:
+		if tm.Target.IsZero() {
+			return fmt.Errorf("countdown: no target")
+		}
+	case Stopwatch:
+		if tm.Start.IsZero() {
+			return fmt.Errorf("stopwatch: no start time")
+		}
+	case Interval:
+		if tm.Start.IsZero() {
+			return fmt.Errorf("interval: no start time")
+		}
+		if tm.Every < time.Second {
+			return fmt.Errorf("interval: period %v is shorter than one second", tm.Every)
+		}
+	default:
+		return fmt.Errorf("unknown timer kind %q", tm.Kind)
+	}
+	return nil
+}
+
+// Pause stops a running stopwatch at now.
+func (tm Timer) Pause(now time.Time) Timer {
+	if tm.Kind == Stopwatch && tm.Stopped.IsZero() {
+		tm.Stopped = now
+	}
+	return tm
+}
+
+// Resume restarts a paused stopwatch, without counting the time that it was paused.
+func (tm Timer) Resume(now time.Time) Timer {
+	if tm.Kind == Stopwatch && !tm.Stopped.IsZero() {
+		tm.Start = tm.Start.Add(now.Sub(tm.Stopped))
+		tm.Stopped = time.Time{}
+	}
+	return tm
+}
+
+// Done returns true if the timer has nothing more to show at now; an expired countdown that has
+// finished flashing.
+func (tm Timer) Done(now time.Time) bool {
+	return tm.Kind == Countdown && now.Sub(tm.Target) >= expiredFlashDuration
+}
+
+// Remaining returns the time left on a countdown or interval timer, or the elapsed time on a
+// stopwatch.
+func (tm Timer) Remaining(now time.Time) time.Duration {
+	switch tm.Kind {
+	case Countdown:
+		if d := tm.Target.Sub(now); d > 0 {
+			return d
+		}
+		return 0
+	case Stopwatch:
+		if !tm.Stopped.IsZero() {
+			now = tm.Stopped
+		}
+		if d := now.Sub(tm.Start); d > 0 {
+			return d
+		}
+		return 0
+	case Interval:
+		since := now.Sub(tm.Start)
+		if since < 0 {
+			return -since
+		}
+		return tm.Every - since%tm.Every
+	}
+	return 0
+}
+
+// nextFrame returns when the display next needs to be redrawn after now; the next tenth of a
+// second, or the instant a countdown expires if that's sooner.
+func (tm Timer) nextFrame(now time.Time) time.Time {
+	next := now.Truncate(timerFrame).Add(timerFrame)
+	if tm.Kind == Countdown && now.Before(tm.Target) && tm.Target.Before(next) {
+		return tm.Target
+	}
+	return next
+}
+
+// formatTimerDuration formats d to fit on the display; tenths of a second are shown for durations
+// under an hour.  Countdowns round up, so that "0:00.0" appears at the instant the countdown ends.
+func formatTimerDuration(d time.Duration, roundUp bool) string {
+	if roundUp {
+		d = (d + timerFrame - 1).Truncate(timerFrame)
+	} else {
+		d = d.Truncate(timerFrame)
+	}
+	h, m, s := int(d/time.Hour), int(d/time.Minute)%60, int(d/time.Second)%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%02d:%02d.%d", m, s, int(d/timerFrame)%10)
+}
+
+var (
+	timerExpiredColor = color.NRGBA64{R: 0xffff, A: 0xffff}
+	timerHighlight    = color.NRGBA64{G: 0xffff, A: 0xffff}
+)
+
+// renderTimer draws the timer as of now.
+func renderTimer(img *image.NRGBA64, tm Timer, now time.Time, c color.NRGBA64) {
+	var text string
+	switch tm.Kind {
+	case Countdown:
+		text = formatTimerDuration(tm.Remaining(now), true)
+		if since := now.Sub(tm.Target); since >= 0 {
+			if since%flashPeriod >= flashPeriod/2 {
+				return
+			}
+			c = withAlpha(timerExpiredColor, c.A)
+		}
+	case Stopwatch:
+		text = formatTimerDuration(tm.Remaining(now), false)
+	case Interval:
+		text = formatTimerDuration(tm.Remaining(now), true)
+		if since := now.Sub(tm.Start); since >= 0 && since%tm.Every < intervalHighlight {
+			c = withAlpha(timerHighlight, c.A)
+		}
+	}
+	renderText(img, text, c)
+}
+
+func withAlpha(c color.NRGBA64, a uint16) color.NRGBA64 {
+	c.A = a
+	return c
+}
@@ -0,0 +1,163 @@
+package clock
+
+import (
+	"context"
+	"encoding/json"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jrockway/beaglebone-gps-clock/control/screen"
+)
+
+var epoch = time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+
+func TestTimerText(t *testing.T) {
+	testData := []struct {
+		name string
+		tm   Timer
+		now  time.Time
+		want string
+	}{
+		{"countdown start", NewCountdown(epoch.Add(90 * time.Second)), epoch, "01:30.0"},
+		{"countdown rounds up", NewCountdown(epoch.Add(90 * time.Second)), epoch.Add(time.Millisecond), "01:30.0"},
+		{"countdown tenths", NewCountdown(epoch.Add(90 * time.Second)), epoch.Add(250 * time.Millisecond), "01:29.8"},
+		{"countdown last instant", NewCountdown(epoch.Add(time.Second)), epoch.Add(time.Second - time.Nanosecond), "00:00.1"},
+		{"countdown expired", NewCountdown(epoch), epoch.Add(time.Second), "00:00.0"},
+		{"countdown hours", NewCountdown(epoch.Add(2*time.Hour + 3*time.Minute)), epoch, "2:03:00"},
+		{"stopwatch", NewStopwatch(epoch), epoch.Add(61*time.Second + 999*time.Millisecond), "01:01.9"},
+		{"paused stopwatch", NewStopwatch(epoch).Pause(epoch.Add(5 * time.Second)), epoch.Add(time.Hour), "00:05.0"},
+		{"resumed stopwatch", NewStopwatch(epoch).Pause(epoch.Add(5 * time.Second)).Resume(epoch.Add(time.Minute)), epoch.Add(time.Minute + time.Second), "00:06.0"},
+		{"interval", NewInterval(epoch, time.Minute), epoch.Add(150 * time.Second), "00:30.0"},
+		{"interval boundary", NewInterval(epoch, time.Minute), epoch.Add(2 * time.Minute), "01:00.0"},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			kind := test.tm.Kind
+			got := formatTimerDuration(test.tm.Remaining(test.now), kind != Stopwatch)
+			if want := test.want; got != want {
+				t.Errorf("text:\n  got: %v\n want: %v", got, want)
+			}
+		})
+	}
+}
+
+func TestNextFrame(t *testing.T) {
+	tm := NewCountdown(epoch.Add(1234 * time.Millisecond))
+	if got, want := tm.nextFrame(epoch.Add(1150*time.Millisecond)), epoch.Add(1200*time.Millisecond); !got.Equal(want) {
+		t.Errorf("next frame before target:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := tm.nextFrame(epoch.Add(1200*time.Millisecond)), epoch.Add(1234*time.Millisecond); !got.Equal(want) {
+		t.Errorf("next frame at target:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := tm.nextFrame(epoch.Add(1234*time.Millisecond)), epoch.Add(1300*time.Millisecond); !got.Equal(want) {
+		t.Errorf("next frame after target:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+// lit returns true if any pixel in img is not black.
+func lit(img *image.NRGBA64) bool {
+	for i := 0; i < len(img.Pix); i += 8 {
+		if img.Pix[i] != 0 || img.Pix[i+2] != 0 || img.Pix[i+4] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func TestExpiredCountdownFlashes(t *testing.T) {
+	d, err := screen.NewScreen(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm := NewCountdown(epoch)
+	white := withAlpha(timerHighlight, 0xffff)
+	for _, test := range []struct {
+		now  time.Time
+		want bool
+	}{
+		{epoch.Add(-time.Second), true},
+		{epoch, true},
+		{epoch.Add(flashPeriod / 2), false},
+		{epoch.Add(flashPeriod), true},
+	} {
+		img := d.EmptyCanvas()
+		renderTimer(img, tm, test.now, white)
+		if got := lit(img); got != test.want {
+			t.Errorf("at %v: lit: got %v, want %v", test.now.Sub(epoch), got, test.want)
+		}
+	}
+	if tm.Done(epoch.Add(expiredFlashDuration - time.Nanosecond)) {
+		t.Error("countdown done before it finished flashing")
+	}
+	if !tm.Done(epoch.Add(expiredFlashDuration)) {
+		t.Error("countdown not done after it finished flashing")
+	}
+}
+
+func TestTimerHandler(t *testing.T) {
+	d, err := screen.NewScreen(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cl := New(d)
+	go cl.Run(ctx) // nolint:errcheck
+	h := http.StripPrefix("/timer", cl.TimerHandler())
+
+	do := func(method, path string, form url.Values) (int, timerStatus) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("content-type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		var status timerStatus
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+				t.Fatalf("%s %s: decode response: %v", method, path, err)
+			}
+		}
+		return rec.Code, status
+	}
+
+	code, status := do("POST", "/timer/countdown", url.Values{"duration": {"5m"}})
+	if code != http.StatusOK {
+		t.Fatalf("start countdown: status %d", code)
+	}
+	if got, want := status.RemainingSeconds, 300.0; got > want || got < want-1 {
+		t.Errorf("countdown remaining:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := cl.State().Timer.Kind, Countdown; got != want {
+		t.Errorf("timer kind:\n  got: %v\n want: %v", got, want)
+	}
+
+	if code, _ := do("POST", "/timer/pause", nil); code != http.StatusBadRequest {
+		t.Errorf("pause countdown: got status %d, want %d", code, http.StatusBadRequest)
+	}
+	if code, _ := do("POST", "/timer/stopwatch", nil); code != http.StatusOK {
+		t.Errorf("start stopwatch: status %d", code)
+	}
+	if code, status := do("POST", "/timer/pause", nil); code != http.StatusOK || status.Timer.Stopped.IsZero() {
+		t.Errorf("pause stopwatch: status %d, timer %#v", code, status.Timer)
+	}
+	if code, _ := do("POST", "/timer/interval", url.Values{"every": {"10ms"}}); code != http.StatusBadRequest {
+		t.Errorf("too-short interval: got status %d, want %d", code, http.StatusBadRequest)
+	}
+	if code, _ := do("POST", "/timer/snooze", nil); code != http.StatusNotFound {
+		t.Errorf("unknown action: got status %d, want %d", code, http.StatusNotFound)
+	}
+	if code, _ := do("GET", "/timer/clear", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET clear: got status %d, want %d", code, http.StatusMethodNotAllowed)
+	}
+	if code, _ := do("POST", "/timer/clear", nil); code != http.StatusOK {
+		t.Errorf("clear: status %d", code)
+	}
+	if code, status := do("GET", "/timer", nil); code != http.StatusOK || status.Timer.Kind != NoTimer {
+		t.Errorf("get after clear: status %d, timer %#v", code, status.Timer)
+	}
+}
@@ -0,0 +1,144 @@
+package clock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// TimerHandler returns an HTTP handler for controlling timers, meant to be mounted with
+// http.StripPrefix.  All changes are POSTs:
+//
+//	GET  /             the current timer, as JSON
+//	POST /countdown    start a countdown; form value duration=90s or until=<RFC3339 time>
+//	POST /stopwatch    start a stopwatch from zero
+//	POST /pause        pause the stopwatch
+//	POST /resume       resume the stopwatch
+//	POST /interval     start an interval timer; form value every=5m
+//	POST /clear        stop showing the timer
+//
+// Timers start at the instant the request is handled, according to the system clock.
+func (c *Clock) TimerHandler() http.Handler {
+	return http.HandlerFunc(c.serveTimer)
+}
+
+// timerUpdate asks the Run loop to replace the current timer with f(current timer).  The result
+// is sent on resultCh, which must be buffered.
+type timerUpdate struct {
+	f        func(Timer) (Timer, error)
+	resultCh chan timerResult
+}
+
+type timerResult struct {
+	tm  Timer
+	err error
+}
+
+type timerStatus struct {
+	Timer            Timer   `json:"timer"`
+	RemainingSeconds float64 `json:"remaining_seconds"`
+}
+
+func (c *Clock) serveTimer(w http.ResponseWriter, req *http.Request) {
+	now := time.Now()
+	if req.URL.Path == "" || req.URL.Path == "/" {
+		if req.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		c.writeTimerStatus(w, c.State().Timer, now)
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Read the request here; the update runs on the Run loop, which must not wait for a slow
+	// client to send its body.
+	f, err := timerUpdateFromRequest(req, now)
+	if errors.Is(err, errUnknownTimerAction) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	u := timerUpdate{f: f, resultCh: make(chan timerResult, 1)}
+	select {
+	case c.timerUpdateCh <- u:
+	case <-req.Context().Done():
+		http.Error(w, "clock is not running", http.StatusServiceUnavailable)
+		return
+	}
+	result := <-u.resultCh
+	if result.err != nil {
+		http.Error(w, result.err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.writeTimerStatus(w, result.tm, now)
+}
+
+var errUnknownTimerAction = errors.New("unknown timer action")
+
+// timerUpdateFromRequest parses a POST request into a function that returns the timer it asks
+// for, given the current timer.
+func timerUpdateFromRequest(req *http.Request, now time.Time) (func(Timer) (Timer, error), error) {
+	if err := req.ParseForm(); err != nil {
+		return nil, fmt.Errorf("parse form: %w", err)
+	}
+	set := func(tm Timer) func(Timer) (Timer, error) {
+		return func(Timer) (Timer, error) { return tm, nil }
+	}
+	switch req.URL.Path {
+	case "/countdown":
+		if until := req.Form.Get("until"); until != "" {
+			target, err := time.Parse(time.RFC3339Nano, until)
+			if err != nil {
+				return nil, fmt.Errorf("parse until: %w", err)
+			}
+			return set(NewCountdown(target)), nil
+		}
+		d, err := time.ParseDuration(req.Form.Get("duration"))
+		if err != nil {
+			return nil, fmt.Errorf("parse duration: %w", err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("duration %v must be positive", d)
+		}
+		return set(NewCountdown(now.Add(d))), nil
+	case "/stopwatch":
+		return set(NewStopwatch(now)), nil
+	case "/pause", "/resume":
+		pause := req.URL.Path == "/pause"
+		return func(current Timer) (Timer, error) {
+			if current.Kind != Stopwatch {
+				return Timer{}, fmt.Errorf("no stopwatch is running")
+			}
+			if pause {
+				return current.Pause(now), nil
+			}
+			return current.Resume(now), nil
+		}, nil
+	case "/interval":
+		every, err := time.ParseDuration(req.Form.Get("every"))
+		if err != nil {
+			return nil, fmt.Errorf("parse every: %w", err)
+		}
+		return set(NewInterval(now, every)), nil
+	case "/clear":
+		return set(Timer{}), nil
+	}
+	return nil, fmt.Errorf("%w %q", errUnknownTimerAction, req.URL.Path)
+}
+
+func (c *Clock) writeTimerStatus(w http.ResponseWriter, tm Timer, now time.Time) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(timerStatus{Timer: tm, RemainingSeconds: tm.Remaining(now).Seconds()}); err != nil {
+		log.Printf("encoding timer status: %v", err)
+	}
+}
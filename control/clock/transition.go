@@ -0,0 +1,178 @@
+package clock
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"time"
+)
+
+// TransitionStyle selects how the display animates from one image to the next, when the second
+// changes or a different face or message is selected.
+type TransitionStyle string
+
+const (
+	// NoTransition switches images instantly.
+	NoTransition TransitionStyle = ""
+	// Fade cross-fades from the old image to the new one.
+	Fade TransitionStyle = "fade"
+	// Slide rolls each column that changed upwards, like a mechanical counter.
+	Slide TransitionStyle = "slide"
+)
+
+// Transitions configures the clock's animations.
+type Transitions struct {
+	Style TransitionStyle `json:"style"`
+	// Duration is how long a Style animation takes.  Animations start exactly when the second
+	// changes.
+	Duration time.Duration `json:"duration"`
+	// BrightnessFade is how long a brightness change takes; zero changes instantly.
+	BrightnessFade time.Duration `json:"brightness_fade"`
+}
+
+// DefaultTransitions are the transitions that a new Clock uses.
+var DefaultTransitions = Transitions{
+	Style:          NoTransition,
+	Duration:       250 * time.Millisecond,
+	BrightnessFade: 500 * time.Millisecond,
+}
+
+// transitionFrame is how often the display is redrawn while something is animating.
+const transitionFrame = 40 * time.Millisecond
+
+// Validate returns an error if the transitions can't be used.
+func (tr Transitions) Validate() error {
+	switch tr.Style {
+	case NoTransition, Fade, Slide:
+	default:
+		return fmt.Errorf("unknown transition style %q", tr.Style)
+	}
+	if tr.Duration < 0 || tr.Duration >= time.Second {
+		return fmt.Errorf("transition duration %v must be between 0 and 1s", tr.Duration)
+	}
+	if tr.BrightnessFade < 0 {
+		return fmt.Errorf("brightness fade %v must not be negative", tr.BrightnessFade)
+	}
+	return nil
+}
+
+// progress returns how far an animation that started at start and lasts d has got at now, from 0
+// to 1.
+func progress(start, now time.Time, d time.Duration) float64 {
+	if d <= 0 {
+		return 1
+	}
+	p := float64(now.Sub(start)) / float64(d)
+	switch {
+	case p < 0:
+		return 0
+	case p > 1:
+		return 1
+	}
+	return p
+}
+
+// fade is a brightness that changes linearly over time.
+type fade struct {
+	from, to uint16
+	start    time.Time
+	duration time.Duration
+}
+
+func (f fade) value(now time.Time) uint16 {
+	p := progress(f.start, now, f.duration)
+	return uint16(float64(f.from) + p*(float64(f.to)-float64(f.from)))
+}
+
+func (f fade) active(now time.Time) bool {
+	return f.value(now) != f.to
+}
+
+// animation is a transition from one image to another.
+type animation struct {
+	style    TransitionStyle
+	from, to *image.NRGBA64
+	start    time.Time
+	duration time.Duration
+}
+
+func (a *animation) done(now time.Time) bool {
+	return progress(a.start, now, a.duration) >= 1
+}
+
+// frame returns the image to show at now.
+func (a *animation) frame(now time.Time) *image.NRGBA64 {
+	p := progress(a.start, now, a.duration)
+	switch {
+	case p >= 1:
+		return a.to
+	case a.style == Fade:
+		return crossFade(a.from, a.to, p)
+	case a.style == Slide:
+		return slide(a.from, a.to, p)
+	}
+	return a.to
+}
+
+// crossFade mixes from and to; p=0 is from, p=1 is to.
+func crossFade(from, to *image.NRGBA64, p float64) *image.NRGBA64 {
+	out := image.NewNRGBA64(to.Rect)
+	for i := 0; i+1 < len(out.Pix); i += 2 {
+		a := float64(uint16(from.Pix[i])<<8 | uint16(from.Pix[i+1]))
+		b := float64(uint16(to.Pix[i])<<8 | uint16(to.Pix[i+1]))
+		v := uint16(a + p*(b-a))
+		out.Pix[i], out.Pix[i+1] = uint8(v>>8), uint8(v)
+	}
+	return out
+}
+
+// slide scrolls the columns that differ between from and to upwards, so that the new column comes
+// in from the bottom; p=0 is from, p=1 is to.
+func slide(from, to *image.NRGBA64, p float64) *image.NRGBA64 {
+	out := image.NewNRGBA64(to.Rect)
+	b := to.Rect
+	h := b.Dy()
+	offset := int(p * float64(h))
+	for x := b.Min.X; x < b.Max.X; x++ {
+		changed := false
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			if from.NRGBA64At(x, y) != to.NRGBA64At(x, y) {
+				changed = true
+				break
+			}
+		}
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			switch {
+			case !changed:
+				out.SetNRGBA64(x, y, to.NRGBA64At(x, y))
+			case y+offset < b.Max.Y:
+				out.SetNRGBA64(x, y, from.NRGBA64At(x, y+offset))
+			default:
+				out.SetNRGBA64(x, y, to.NRGBA64At(x, y+offset-h))
+			}
+		}
+	}
+	return out
+}
+
+// withBrightness returns a copy of img with every color scaled by b/0xffff.  This looks the same
+// as drawing with an alpha of b over black, which is how brightness used to be applied.
+func withBrightness(img *image.NRGBA64, b uint16) *image.NRGBA64 {
+	out := image.NewNRGBA64(img.Rect)
+	copy(out.Pix, img.Pix)
+	if b == 0xffff {
+		return out
+	}
+	for i := 0; i+7 < len(out.Pix); i += 8 {
+		for c := i; c < i+6; c += 2 {
+			v := uint32(out.Pix[c])<<8 | uint32(out.Pix[c+1])
+			v = v * uint32(b) / 0xffff
+			out.Pix[c], out.Pix[c+1] = uint8(v>>8), uint8(v)
+		}
+	}
+	return out
+}
+
+func sameImage(a, b *image.NRGBA64) bool {
+	return a != nil && b != nil && a.Rect == b.Rect && bytes.Equal(a.Pix, b.Pix)
+}
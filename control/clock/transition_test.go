@@ -0,0 +1,131 @@
+package clock
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+)
+
+func TestFade(t *testing.T) {
+	f := fade{from: 0x1000, to: 0x3000, start: epoch, duration: time.Second}
+	testData := []struct {
+		at         time.Duration
+		want       uint16
+		wantActive bool
+	}{
+		{-time.Second, 0x1000, true},
+		{0, 0x1000, true},
+		{500 * time.Millisecond, 0x2000, true},
+		{time.Second, 0x3000, false},
+		{time.Minute, 0x3000, false},
+	}
+	for _, test := range testData {
+		now := epoch.Add(test.at)
+		if got, want := f.value(now), test.want; got != want {
+			t.Errorf("value at %v:\n  got: %#x\n want: %#x", test.at, got, want)
+		}
+		if got, want := f.active(now), test.wantActive; got != want {
+			t.Errorf("active at %v:\n  got: %v\n want: %v", test.at, got, want)
+		}
+	}
+	if got, want := (fade{from: 0, to: 0xffff, start: epoch}).value(epoch), uint16(0xffff); got != want {
+		t.Errorf("instant fade:\n  got: %#x\n want: %#x", got, want)
+	}
+}
+
+// column returns an image 1 pixel wide whose rows are the given gray levels.
+func column(levels ...uint16) *image.NRGBA64 {
+	img := image.NewNRGBA64(image.Rect(0, 0, 1, len(levels)))
+	for y, l := range levels {
+		img.SetNRGBA64(0, y, color.NRGBA64{R: l, G: l, B: l, A: 0xffff})
+	}
+	return img
+}
+
+func levels(img *image.NRGBA64) []uint16 {
+	var result []uint16
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		result = append(result, img.NRGBA64At(0, y).R)
+	}
+	return result
+}
+
+func equalLevels(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestAnimation(t *testing.T) {
+	from, to := column(0, 0xffff, 0, 0xffff), column(0xffff, 0xffff, 0, 0)
+	testData := []struct {
+		name  string
+		style TransitionStyle
+		at    time.Duration
+		want  []uint16
+	}{
+		{"fade start", Fade, 0, []uint16{0, 0xffff, 0, 0xffff}},
+		{"fade middle", Fade, 50 * time.Millisecond, []uint16{0x7fff, 0xffff, 0, 0x7fff}},
+		{"fade end", Fade, 100 * time.Millisecond, []uint16{0xffff, 0xffff, 0, 0}},
+		{"slide start", Slide, 0, []uint16{0, 0xffff, 0, 0xffff}},
+		{"slide quarter", Slide, 25 * time.Millisecond, []uint16{0xffff, 0, 0xffff, 0xffff}},
+		{"slide middle", Slide, 50 * time.Millisecond, []uint16{0, 0xffff, 0xffff, 0xffff}},
+		{"slide end", Slide, 100 * time.Millisecond, []uint16{0xffff, 0xffff, 0, 0}},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			a := &animation{style: test.style, from: from, to: to, start: epoch, duration: 100 * time.Millisecond}
+			if got, want := levels(a.frame(epoch.Add(test.at))), test.want; !equalLevels(got, want) {
+				t.Errorf("frame at %v:\n  got: %#x\n want: %#x", test.at, got, want)
+			}
+		})
+	}
+}
+
+func TestSlideOnlyMovesChangedColumns(t *testing.T) {
+	from := image.NewNRGBA64(image.Rect(0, 0, 2, 2))
+	from.SetNRGBA64(0, 0, color.NRGBA64{R: 0xffff, A: 0xffff})
+	from.SetNRGBA64(1, 0, color.NRGBA64{G: 0xffff, A: 0xffff})
+	to := image.NewNRGBA64(from.Rect)
+	copy(to.Pix, from.Pix)
+	to.SetNRGBA64(1, 1, color.NRGBA64{B: 0xffff, A: 0xffff})
+
+	got := slide(from, to, 0.5)
+	if got, want := got.NRGBA64At(0, 0), from.NRGBA64At(0, 0); got != want {
+		t.Errorf("unchanged column moved:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := got.NRGBA64At(1, 0), from.NRGBA64At(1, 1); got != want {
+		t.Errorf("changed column didn't move:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func TestWithBrightness(t *testing.T) {
+	img := image.NewNRGBA64(image.Rect(0, 0, 1, 1))
+	img.SetNRGBA64(0, 0, color.NRGBA64{R: 0xffff, G: 0x8000, B: 0, A: 0xffff})
+	got := withBrightness(img, 0x8000).NRGBA64At(0, 0)
+	if want := (color.NRGBA64{R: 0x8000, G: 0x4000, B: 0, A: 0xffff}); got != want {
+		t.Errorf("half brightness:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := img.NRGBA64At(0, 0).R, uint16(0xffff); got != want {
+		t.Errorf("original image was modified:\n  got: %#x\n want: %#x", got, want)
+	}
+}
+
+func TestTransitionsValidate(t *testing.T) {
+	if err := DefaultTransitions.Validate(); err != nil {
+		t.Errorf("default transitions: %v", err)
+	}
+	if err := (Transitions{Style: "wipe"}).Validate(); err == nil {
+		t.Error("unknown style: expected error")
+	}
+	if err := (Transitions{Style: Fade, Duration: 2 * time.Second}).Validate(); err == nil {
+		t.Error("transition longer than a second: expected error")
+	}
+}
@@ -0,0 +1,109 @@
+package max7219
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Fake is a Conn that behaves like a MAX7219, for testing code that drives one.
+type Fake struct {
+	mu        sync.Mutex
+	registers [16]byte
+	writes    int
+	err       error
+}
+
+// Xfer implements Conn.
+func (f *Fake) Xfer(tx []byte) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	if len(tx) != 2 {
+		return nil, fmt.Errorf("fake max7219: got %d-byte transfer, want 2 bytes", len(tx))
+	}
+	f.registers[tx[0]&0x0F] = tx[1]
+	f.writes++
+	return make([]byte, len(tx)), nil
+}
+
+// SetError causes future transfers to fail with err; nil makes them succeed again.
+func (f *Fake) SetError(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+// Register returns the last value written to a register.
+func (f *Fake) Register(r Register) byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.registers[r&0x0F]
+}
+
+// Writes returns the number of successful register writes.
+func (f *Fake) Writes() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.writes
+}
+
+var (
+	bcdChars = "0123456789-EHLP "
+	segChars = map[Segments]byte{
+		0:                                              ' ',
+		SegA | SegB | SegC | SegD | SegE | SegF:        '0',
+		SegB | SegC:                                    '1',
+		SegA | SegB | SegG | SegE | SegD:               '2',
+		SegA | SegB | SegG | SegC | SegD:               '3',
+		SegF | SegG | SegB | SegC:                      '4',
+		SegA | SegF | SegG | SegC | SegD:               '5',
+		SegA | SegF | SegG | SegE | SegC | SegD:        '6',
+		SegA | SegB | SegC:                             '7',
+		SegA | SegB | SegC | SegD | SegE | SegF | SegG: '8',
+		SegA | SegB | SegC | SegD | SegF | SegG:        '9',
+		SegG:                                           '-',
+		SegA | SegF | SegE | SegD:                      'C',
+		SegA | SegF | SegG | SegE | SegD:               'E',
+		SegF | SegE | SegG | SegB | SegC:               'H',
+		SegF | SegE | SegD:                             'L',
+		SegA | SegB | SegF | SegG | SegE:               'P',
+		SegB | SegC | SegD | SegE | SegF:               'U',
+		SegD:                                           '_',
+	}
+)
+
+// Text returns what the display shows, reading the highest-numbered digit first (which is how
+// the display-clock is wired), with a '.' after digits whose decimal point is lit.  Raw segment
+// patterns that don't look like a character are shown as '?'.
+func (f *Fake) Text() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.registers[Shutdown] == 0 {
+		return ""
+	}
+	n := int(f.registers[ScanLimit]&0x07) + 1
+	var b strings.Builder
+	for i := n - 1; i >= 0; i-- {
+		v := f.registers[Digit(i)]
+		if f.registers[DisplayTest]&1 != 0 {
+			v = 0xFF
+			if f.registers[DecodeMode]&(1<<uint(i)) != 0 {
+				v = 0x88
+			}
+		}
+		if f.registers[DecodeMode]&(1<<uint(i)) != 0 {
+			b.WriteByte(bcdChars[v&0x0F])
+		} else if c, ok := segChars[Segments(v)&^SegDP]; ok {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('?')
+		}
+		if v&byte(SegDP) != 0 {
+			b.WriteByte('.')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,221 @@
+// Package max7219 drives a MAX7219 LED display driver wired to seven-segment digits.
+//
+// Every command is a 16-bit SPI transfer: a register address followed by a value.  Digit
+// registers hold either a BCD code, which the chip decodes into segments, or raw segments,
+// depending on the decode mode.
+package max7219
+
+import (
+	"fmt"
+)
+
+// Conn is the SPI connection to the chip.  *spidev.SPIDevice implements it.
+type Conn interface {
+	Xfer(tx []byte) ([]byte, error)
+}
+
+// Register is a MAX7219 register address.
+type Register byte
+
+const (
+	NoOp        Register = 0x00
+	Digit0      Register = 0x01 // Digits 1-7 follow in order.
+	DecodeMode  Register = 0x09
+	Intensity   Register = 0x0A
+	ScanLimit   Register = 0x0B
+	Shutdown    Register = 0x0C
+	DisplayTest Register = 0x0F
+)
+
+// MaxDigits is the number of digits that one chip can drive.
+const MaxDigits = 8
+
+// MaxBrightness is the brightest intensity setting.
+const MaxBrightness = 15
+
+// Digit returns the register for digit i; digit 0 is whichever digit is wired to DIG0.
+func Digit(i int) Register {
+	return Digit0 + Register(i)
+}
+
+func (r Register) String() string {
+	switch {
+	case r == NoOp:
+		return "no-op"
+	case r >= Digit0 && r < Digit0+MaxDigits:
+		return fmt.Sprintf("digit %d", r-Digit0)
+	case r == DecodeMode:
+		return "decode mode"
+	case r == Intensity:
+		return "intensity"
+	case r == ScanLimit:
+		return "scan limit"
+	case r == Shutdown:
+		return "shutdown"
+	case r == DisplayTest:
+		return "display test"
+	}
+	return fmt.Sprintf("register 0x%02x", byte(r))
+}
+
+// BCD codes, for digits in decode mode.  0-9 are themselves.
+const (
+	BCDDash  byte = 0x0A
+	BCDE     byte = 0x0B
+	BCDH     byte = 0x0C
+	BCDL     byte = 0x0D
+	BCDP     byte = 0x0E
+	BCDBlank byte = 0x0F
+	// BCDPoint lights the decimal point along with any code.
+	BCDPoint byte = 0x80
+)
+
+// Segments is a digit's segments, for digits that aren't in decode mode.
+type Segments byte
+
+const (
+	SegG  Segments = 1 << iota // middle
+	SegF                       // top left
+	SegE                       // bottom left
+	SegD                       // bottom
+	SegC                       // bottom right
+	SegB                       // top right
+	SegA                       // top
+	SegDP                      // decimal point
+)
+
+// Device is a MAX7219 driving up to 8 seven-segment digits.
+type Device struct {
+	conn   Conn
+	digits int
+	decode byte // digits in decode mode, as a bitmask.
+}
+
+// New initializes the chip to drive the given number of digits: not shut down, not in test mode,
+// every digit blank and in raw segment mode, and at minimum brightness.
+func New(conn Conn, digits int) (*Device, error) {
+	if digits < 1 || digits > MaxDigits {
+		return nil, fmt.Errorf("max7219: %d digits is out of range 1-%d", digits, MaxDigits)
+	}
+	d := &Device{conn: conn, digits: digits}
+	for _, w := range []struct {
+		reg Register
+		v   byte
+	}{
+		{DisplayTest, 0},
+		{ScanLimit, byte(digits - 1)},
+		{DecodeMode, 0},
+		{Intensity, 0},
+	} {
+		if err := d.Write(w.reg, w.v); err != nil {
+			return nil, fmt.Errorf("max7219: init: %w", err)
+		}
+	}
+	if err := d.Clear(); err != nil {
+		return nil, fmt.Errorf("max7219: init: %w", err)
+	}
+	if err := d.SetShutdown(false); err != nil {
+		return nil, fmt.Errorf("max7219: init: %w", err)
+	}
+	return d, nil
+}
+
+// Digits returns the number of digits that the device drives.
+func (d *Device) Digits() int {
+	return d.digits
+}
+
+// Write writes v to a register.
+func (d *Device) Write(reg Register, v byte) error {
+	if _, err := d.conn.Xfer([]byte{byte(reg), v}); err != nil {
+		return fmt.Errorf("write %v: %w", reg, err)
+	}
+	return nil
+}
+
+// SetBrightness sets the intensity, from 0 (dimmest, but still on) to MaxBrightness.
+func (d *Device) SetBrightness(level int) error {
+	if level < 0 || level > MaxBrightness {
+		return fmt.Errorf("brightness %d is out of range 0-%d", level, MaxBrightness)
+	}
+	return d.Write(Intensity, byte(level))
+}
+
+// SetTestMode turns every segment on at full brightness, regardless of the other registers, or
+// goes back to normal operation.
+func (d *Device) SetTestMode(on bool) error {
+	var v byte
+	if on {
+		v = 1
+	}
+	return d.Write(DisplayTest, v)
+}
+
+// SetShutdown turns the display off, retaining the digits, or back on.
+func (d *Device) SetShutdown(off bool) error {
+	var v byte = 1
+	if off {
+		v = 0
+	}
+	return d.Write(Shutdown, v)
+}
+
+// SetDecodeMode selects which digits (as a bitmask; bit 0 is digit 0) are BCD decoded.  The rest
+// show raw segments.
+func (d *Device) SetDecodeMode(mask byte) error {
+	if err := d.Write(DecodeMode, mask); err != nil {
+		return err
+	}
+	d.decode = mask
+	return nil
+}
+
+func (d *Device) checkDigit(i int, decode bool) error {
+	if i < 0 || i >= d.digits {
+		return fmt.Errorf("digit %d is out of range 0-%d", i, d.digits-1)
+	}
+	if isDecoded := d.decode&(1<<uint(i)) != 0; isDecoded != decode {
+		if decode {
+			return fmt.Errorf("digit %d is not in decode mode", i)
+		}
+		return fmt.Errorf("digit %d is in decode mode", i)
+	}
+	return nil
+}
+
+// SetBCD shows a BCD code (0-9 or one of the BCD constants) on a decoded digit, optionally with
+// the decimal point.
+func (d *Device) SetBCD(i int, code byte, point bool) error {
+	if err := d.checkDigit(i, true); err != nil {
+		return err
+	}
+	if code&^BCDPoint > 0x0F {
+		return fmt.Errorf("invalid bcd code 0x%02x", code)
+	}
+	if point {
+		code |= BCDPoint
+	}
+	return d.Write(Digit(i), code)
+}
+
+// SetSegments lights exactly the given segments of a digit that isn't decoded.
+func (d *Device) SetSegments(i int, segs Segments) error {
+	if err := d.checkDigit(i, false); err != nil {
+		return err
+	}
+	return d.Write(Digit(i), byte(segs))
+}
+
+// Clear blanks every digit.
+func (d *Device) Clear() error {
+	for i := 0; i < d.digits; i++ {
+		v := byte(0)
+		if d.decode&(1<<uint(i)) != 0 {
+			v = BCDBlank
+		}
+		if err := d.Write(Digit(i), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
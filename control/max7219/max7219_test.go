@@ -0,0 +1,109 @@
+package max7219
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDevice(t *testing.T) {
+	f := new(Fake)
+	d, err := New(f, 4)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if got, want := f.Text(), "    "; got != want {
+		t.Errorf("after init:\n  got: %q\n want: %q", got, want)
+	}
+	if got, want := f.Register(ScanLimit), byte(3); got != want {
+		t.Errorf("scan limit:\n  got: %v\n want: %v", got, want)
+	}
+
+	if err := d.SetDecodeMode(0x0C); err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []error{
+		d.SetBCD(3, 1, false),
+		d.SetBCD(2, BCDE, true),
+		d.SetSegments(1, SegA|SegF|SegE|SegD),
+		d.SetSegments(0, SegG|SegDP),
+	} {
+		if step != nil {
+			t.Fatal(step)
+		}
+	}
+	if got, want := f.Text(), "1E.C-."; got != want {
+		t.Errorf("mixed modes:\n  got: %q\n want: %q", got, want)
+	}
+
+	if err := d.SetTestMode(true); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.Text(), "8.8.8.8."; got != want {
+		t.Errorf("test mode:\n  got: %q\n want: %q", got, want)
+	}
+	if err := d.SetTestMode(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetShutdown(true); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.Text(), ""; got != want {
+		t.Errorf("shut down:\n  got: %q\n want: %q", got, want)
+	}
+	if err := d.SetShutdown(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.Text(), "    "; got != want {
+		t.Errorf("after clear:\n  got: %q\n want: %q", got, want)
+	}
+
+	if err := d.SetBrightness(MaxBrightness); err != nil {
+		t.Errorf("max brightness: %v", err)
+	}
+	if got, want := f.Register(Intensity), byte(MaxBrightness); got != want {
+		t.Errorf("intensity:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := New(new(Fake), 9); err == nil {
+		t.Error("new with 9 digits: expected error")
+	}
+	f := new(Fake)
+	d, err := New(f, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetDecodeMode(0x01); err != nil {
+		t.Fatal(err)
+	}
+	testData := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"brightness", d.SetBrightness(16), "out of range"},
+		{"digit", d.SetSegments(8, SegA), "digit 8 is out of range"},
+		{"bcd on raw digit", d.SetBCD(1, 1, false), "not in decode mode"},
+		{"segments on bcd digit", d.SetSegments(0, SegA), "is in decode mode"},
+		{"bcd code", d.SetBCD(0, 0x10, false), "invalid bcd code"},
+	}
+	for _, test := range testData {
+		if test.err == nil || !strings.Contains(test.err.Error(), test.want) {
+			t.Errorf("%s: unexpected error:\n  got: %v\n want: ...%s...", test.name, test.err, test.want)
+		}
+	}
+
+	broken := errors.New("spi is broken")
+	f.SetError(broken)
+	if err := d.SetBCD(0, 1, false); !errors.Is(err, broken) {
+		t.Errorf("write with broken spi:\n  got: %v\n want: %v", err, broken)
+	}
+	if _, err := New(f, 8); !errors.Is(err, broken) {
+		t.Errorf("init with broken spi:\n  got: %v\n want: %v", err, broken)
+	}
+}
@@ -0,0 +1,254 @@
+package mqtt
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+)
+
+// testBroker is an in-process MQTT broker that understands just enough of the protocol to exercise
+// the client: it routes QoS 0 publishes between connected clients, keeps retained messages, and
+// publishes wills when a connection dies.
+type testBroker struct {
+	t *testing.T
+	l net.Listener
+
+	mu       sync.Mutex
+	conns    map[net.Conn]*brokerConn
+	retained map[string]Message
+	connects int
+	connAuth []string        // username:password of each CONNECT.
+	refuse   map[string]bool // filters to refuse subscriptions to.
+}
+
+type brokerConn struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+	filters []string
+	will    *Message
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	b := &testBroker{t: t, l: l, conns: make(map[net.Conn]*brokerConn), retained: make(map[string]Message), refuse: make(map[string]bool)}
+	go b.serve()
+	t.Cleanup(b.Close)
+	return b
+}
+
+func (b *testBroker) Addr() string { return b.l.Addr().String() }
+
+func (b *testBroker) Close() {
+	b.l.Close()
+	b.DropAll()
+}
+
+// DropAll closes every client connection, as if the broker restarted.
+func (b *testBroker) DropAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.conns {
+		c.Close()
+	}
+}
+
+// Connects returns the number of CONNECT packets that the broker has accepted.
+func (b *testBroker) Connects() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.connects
+}
+
+// Refuse makes the broker refuse subscriptions to filter.
+func (b *testBroker) Refuse(filter string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refuse[filter] = true
+}
+
+// Retained returns the retained message for topic.
+func (b *testBroker) Retained(topic string) (Message, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m, ok := b.retained[topic]
+	return m, ok
+}
+
+func (b *testBroker) serve() {
+	for {
+		c, err := b.l.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(c)
+	}
+}
+
+func (b *testBroker) write(bc *brokerConn, p *packet) {
+	buf, err := p.bytes()
+	if err != nil {
+		b.t.Errorf("broker: serialize packet: %v", err)
+		return
+	}
+	bc.writeMu.Lock()
+	defer bc.writeMu.Unlock()
+	bc.conn.Write(buf) // nolint:errcheck
+}
+
+func (b *testBroker) handle(c net.Conn) {
+	bc := &brokerConn{conn: c}
+	r := bufio.NewReader(c)
+	defer func() {
+		c.Close()
+		b.mu.Lock()
+		delete(b.conns, c)
+		b.mu.Unlock()
+		if bc.will != nil {
+			b.route(*bc.will)
+		}
+	}()
+
+	p, err := readPacket(r)
+	if err != nil || p.kind != typeConnect {
+		return
+	}
+	if err := b.parseConnect(bc, p.body); err != nil {
+		b.t.Errorf("broker: parse connect: %v", err)
+		return
+	}
+	b.mu.Lock()
+	b.conns[c] = bc
+	b.connects++
+	b.mu.Unlock()
+	b.write(bc, &packet{kind: typeConnack, body: []byte{0, 0}})
+
+	for {
+		p, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		switch p.kind {
+		case typePublish:
+			m, _, err := parsePublish(p)
+			if err != nil {
+				b.t.Errorf("broker: parse publish: %v", err)
+				return
+			}
+			b.route(m)
+		case typeSubscribe:
+			id, rest := p.body[:2], p.body[2:]
+			var filters []string
+			codes := append([]byte{}, id...)
+			for len(rest) > 0 {
+				var f string
+				f, rest, err = readString(rest)
+				if err != nil || len(rest) < 1 {
+					b.t.Errorf("broker: parse subscribe: %v", err)
+					return
+				}
+				rest = rest[1:] // QoS
+				b.mu.Lock()
+				refuse := b.refuse[f]
+				b.mu.Unlock()
+				if refuse {
+					codes = append(codes, 0x80)
+					continue
+				}
+				codes = append(codes, 0)
+				filters = append(filters, f)
+			}
+			b.mu.Lock()
+			bc.filters = append(bc.filters, filters...)
+			var retained []Message
+			for _, m := range b.retained {
+				for _, f := range filters {
+					if MatchTopic(f, m.Topic) {
+						retained = append(retained, m)
+						break
+					}
+				}
+			}
+			b.mu.Unlock()
+			b.write(bc, &packet{kind: typeSuback, body: codes})
+			for _, m := range retained {
+				b.write(bc, publishPacket(m))
+			}
+		case typePingreq:
+			b.write(bc, &packet{kind: typePingresp})
+		case typeDisconnect:
+			bc.will = nil
+			return
+		default:
+			b.t.Errorf("broker: unexpected packet type %d", p.kind)
+			return
+		}
+	}
+}
+
+func (b *testBroker) parseConnect(bc *brokerConn, body []byte) error {
+	proto, rest, err := readString(body)
+	if err != nil {
+		return err
+	}
+	if proto != "MQTT" || len(rest) < 4 || rest[0] != 4 {
+		return errors.New("not mqtt 3.1.1")
+	}
+	flags := rest[1]
+	rest = rest[4:]
+	if _, rest, err = readString(rest); err != nil {
+		return err
+	}
+	if flags&0x04 != 0 {
+		var topic, payload string
+		if topic, rest, err = readString(rest); err != nil {
+			return err
+		}
+		if payload, rest, err = readString(rest); err != nil {
+			return err
+		}
+		bc.will = &Message{Topic: topic, Payload: []byte(payload), Retain: flags&0x20 != 0}
+	}
+	var user, pass string
+	if flags&0x80 != 0 {
+		if user, rest, err = readString(rest); err != nil {
+			return err
+		}
+	}
+	if flags&0x40 != 0 {
+		if pass, _, err = readString(rest); err != nil {
+			return err
+		}
+	}
+	b.mu.Lock()
+	b.connAuth = append(b.connAuth, user+":"+pass)
+	b.mu.Unlock()
+	return nil
+}
+
+// route delivers a message to every subscriber.
+func (b *testBroker) route(m Message) {
+	b.mu.Lock()
+	if m.Retain {
+		b.retained[m.Topic] = m
+	}
+	var targets []*brokerConn
+	for _, bc := range b.conns {
+		for _, f := range bc.filters {
+			if MatchTopic(f, m.Topic) {
+				targets = append(targets, bc)
+				break
+			}
+		}
+	}
+	b.mu.Unlock()
+	m.Retain = false // Retain is only set on delivery of a stored message.
+	for _, bc := range targets {
+		b.write(bc, publishPacket(m))
+	}
+}
@@ -0,0 +1,218 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Packet types from section 2.2.1 of the MQTT 3.1.1 spec.  We only implement the ones that a QoS
+// 0 client needs, plus PUBACK so that we can politely acknowledge a broker that ignores the QoS we
+// asked for.
+const (
+	typeConnect    = 1
+	typeConnack    = 2
+	typePublish    = 3
+	typePuback     = 4
+	typeSubscribe  = 8
+	typeSuback     = 9
+	typePingreq    = 12
+	typePingresp   = 13
+	typeDisconnect = 14
+)
+
+// maxRemainingLength is the largest value that the variable-length encoding can represent.
+const maxRemainingLength = 268435455
+
+// packet is a raw MQTT control packet; the fixed header split into its parts, and everything after
+// the remaining length.
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+// readPacket reads one control packet from r.
+func readPacket(r *bufio.Reader) (*packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("read fixed header: %w", err)
+	}
+	var length, shift int
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("read remaining length: %w", err)
+		}
+		length |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+		if shift > 21 {
+			return nil, errors.New("read remaining length: malformed variable-length integer")
+		}
+	}
+	p := &packet{kind: header >> 4, flags: header & 0x0f, body: make([]byte, length)}
+	if _, err := io.ReadFull(r, p.body); err != nil {
+		return nil, fmt.Errorf("read packet body: %w", err)
+	}
+	return p, nil
+}
+
+// bytes serializes the packet for the wire.
+func (p *packet) bytes() ([]byte, error) {
+	if len(p.body) > maxRemainingLength {
+		return nil, fmt.Errorf("packet too large (%d bytes)", len(p.body))
+	}
+	buf := make([]byte, 1, len(p.body)+5)
+	buf[0] = p.kind<<4 | p.flags&0x0f
+	n := len(p.body)
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if n > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(buf, p.body...), nil
+}
+
+// appendString appends a length-prefixed UTF-8 string to buf.
+func appendString(buf []byte, s string) []byte {
+	buf = appendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+// readString reads a length-prefixed string from the front of buf, returning the string and the
+// rest of the buffer.
+func readString(buf []byte) (string, []byte, error) {
+	if len(buf) < 2 {
+		return "", nil, errors.New("string length truncated")
+	}
+	n := int(binary.BigEndian.Uint16(buf))
+	if len(buf) < n+2 {
+		return "", nil, fmt.Errorf("string truncated (want %d bytes, have %d)", n, len(buf)-2)
+	}
+	return string(buf[2 : n+2]), buf[n+2:], nil
+}
+
+// connectPacket builds a CONNECT packet for a clean session.
+func connectPacket(o *connectOptions) *packet {
+	var flags byte = 0x02 // Clean session.
+	body := appendString(nil, "MQTT")
+	body = append(body, 4) // Protocol level 4 is MQTT 3.1.1.
+	if o.willTopic != "" {
+		flags |= 0x04
+		if o.willRetain {
+			flags |= 0x20
+		}
+	}
+	if o.username != "" {
+		flags |= 0x80
+		if o.password != "" {
+			flags |= 0x40
+		}
+	}
+	body = append(body, flags)
+	body = appendUint16(body, uint16(o.keepAlive.Seconds()))
+	body = appendString(body, o.clientID)
+	if o.willTopic != "" {
+		body = appendString(body, o.willTopic)
+		body = appendString(body, string(o.willPayload))
+	}
+	if o.username != "" {
+		body = appendString(body, o.username)
+		if o.password != "" {
+			body = appendString(body, o.password)
+		}
+	}
+	return &packet{kind: typeConnect, body: body}
+}
+
+// publishPacket builds a QoS 0 PUBLISH packet.
+func publishPacket(m Message) *packet {
+	var flags byte
+	if m.Retain {
+		flags |= 0x01
+	}
+	return &packet{kind: typePublish, flags: flags, body: append(appendString(nil, m.Topic), m.Payload...)}
+}
+
+// parsePublish extracts the message from a PUBLISH packet, along with the packet identifier if the
+// message was sent with QoS > 0.
+func parsePublish(p *packet) (Message, uint16, error) {
+	topic, rest, err := readString(p.body)
+	if err != nil {
+		return Message{}, 0, fmt.Errorf("read topic: %w", err)
+	}
+	var id uint16
+	if qos := (p.flags >> 1) & 0x03; qos > 0 {
+		if len(rest) < 2 {
+			return Message{}, 0, errors.New("packet identifier truncated")
+		}
+		id = binary.BigEndian.Uint16(rest)
+		rest = rest[2:]
+	}
+	return Message{Topic: topic, Payload: rest, Retain: p.flags&0x01 != 0}, id, nil
+}
+
+// subscribePacket builds a SUBSCRIBE packet that requests QoS 0 for each filter.
+func subscribePacket(id uint16, filters []string) *packet {
+	body := appendUint16(nil, id)
+	for _, f := range filters {
+		body = appendString(body, f)
+		body = append(body, 0)
+	}
+	return &packet{kind: typeSubscribe, flags: 0x02, body: body}
+}
+
+// connackError translates a CONNACK return code into an error.
+func connackError(code byte) error {
+	switch code {
+	case 0:
+		return nil
+	case 1:
+		return errors.New("unacceptable protocol version")
+	case 2:
+		return errors.New("identifier rejected")
+	case 3:
+		return errors.New("server unavailable")
+	case 4:
+		return errors.New("bad user name or password")
+	case 5:
+		return errors.New("not authorized")
+	default:
+		return fmt.Errorf("unknown return code %d", code)
+	}
+}
+
+// MatchTopic returns true if the topic name matches the subscription filter, interpreting the '+'
+// and '#' wildcards as described in section 4.7 of the spec.
+func MatchTopic(filter, topic string) bool {
+	fs, ts := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, f := range fs {
+		if f == "#" {
+			// "a/#" matches "a" as well as everything below it.
+			return true
+		}
+		if i >= len(ts) {
+			return false
+		}
+		if f != "+" && f != ts[i] {
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
+
+func appendUint16(buf []byte, x uint16) []byte {
+	return append(buf, byte(x>>8), byte(x))
+}
@@ -0,0 +1,307 @@
+package ntpdmon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NTP control messages (mode 6), from RFC 1305 appendix B and ntpd's ntp_control.h.
+const (
+	modeControl = 6
+	version     = 2 // What ntpq sends.
+
+	opReadStat = 1 // Read the system status word, or the list of associations and their status.
+	opReadVar  = 2 // Read the system's or an association's variables.
+
+	flagResponse = 0x80
+	flagError    = 0x40
+	flagMore     = 0x20
+	opcodeMask   = 0x1f
+
+	// maxData is the most data that one fragment of a response can carry.
+	maxData = 468
+
+	// maxFragments is how many fragments a response can have before request gives up on it.
+	maxFragments = 64
+)
+
+// header is the start of every control message.
+type header struct {
+	LIVNMode uint8 // Leap indicator, version and mode.
+	ROpcode  uint8 // Response, error and more bits, and the opcode.
+	Sequence uint16
+	Status   uint16
+	AssocID  uint16
+	Offset   uint16 // Where this fragment's data goes in the whole response.
+	Count    uint16 // How much data this fragment has.
+}
+
+// ControlError is an error response from ntpd.
+type ControlError struct {
+	Code uint8
+}
+
+var errorNames = []string{"unspecified", "authentication failure", "invalid message length or format", "invalid opcode", "unknown association", "unknown variable", "invalid variable value", "administratively prohibited"}
+
+func (e *ControlError) Error() string {
+	if int(e.Code) < len(errorNames) {
+		return "got error: " + errorNames[e.Code]
+	}
+	return fmt.Sprintf("got error %d", e.Code)
+}
+
+// client sends control messages over one connection.
+type client struct {
+	conn     net.Conn
+	sequence uint16
+}
+
+// request sends a request with no data, and returns the status word and data of the reply, put
+// back together from its fragments.
+func (c *client) request(opcode uint8, assoc uint16) (uint16, []byte, error) {
+	c.sequence++
+	req := header{LIVNMode: version<<3 | modeControl, ROpcode: opcode, Sequence: c.sequence, AssocID: assoc}
+	if err := binary.Write(c.conn, binary.BigEndian, req); err != nil {
+		return 0, nil, fmt.Errorf("write request: %w", err)
+	}
+
+	fragments := make(map[uint16][]byte)
+	end := -1 // The length of the whole response, once the last fragment has arrived.
+	var status uint16
+	buf := make([]byte, 2048)
+	for n := 0; n < maxFragments; {
+		size, err := c.conn.Read(buf)
+		if err != nil {
+			return 0, nil, fmt.Errorf("read reply: %w", err)
+		}
+		var head header
+		if err := binary.Read(bytes.NewReader(buf[:size]), binary.BigEndian, &head); err != nil {
+			continue
+		}
+		// Ignore replies to earlier requests that timed out.
+		if head.LIVNMode&7 != modeControl || head.ROpcode&flagResponse == 0 || head.ROpcode&opcodeMask != opcode || head.Sequence != req.Sequence {
+			continue
+		}
+		if head.ROpcode&flagError != 0 {
+			return 0, nil, &ControlError{Code: uint8(head.Status >> 8)}
+		}
+		data := buf[binary.Size(head):size]
+		if int(head.Count) > len(data) || head.Count > maxData {
+			return 0, nil, fmt.Errorf("fragment at offset %d: count %d doesn't fit in %d bytes", head.Offset, head.Count, len(data))
+		}
+		n++
+		status = head.Status
+		if head.Count > 0 {
+			fragments[head.Offset] = append([]byte(nil), data[:head.Count]...)
+		}
+		if head.ROpcode&flagMore == 0 {
+			end = int(head.Offset) + int(head.Count)
+		}
+		if end < 0 {
+			continue
+		}
+		var result []byte
+		for len(result) < end {
+			f, ok := fragments[uint16(len(result))]
+			if !ok {
+				break
+			}
+			result = append(result, f...)
+		}
+		if len(result) == end {
+			return status, result, nil
+		}
+	}
+	return 0, nil, fmt.Errorf("reply has more than %d fragments", maxFragments)
+}
+
+// association is one of ntpd's associations, from the reply to readstat.
+type association struct {
+	ID     uint16
+	Status uint16 // The peer status word.
+}
+
+// selection returns the peer selection field of the status word, which says what ntpd made of the
+// peer, like ntpq's tally codes.
+func (a association) selection() uint8 {
+	return uint8(a.Status>>8) & 7
+}
+
+// Peer selection codes.
+const (
+	selectReject    = 0 // ' ': not valid.
+	selectFalsetick = 1 // 'x': rejected by the intersection algorithm.
+	selectExcess    = 2 // '.': not among the best peers.
+	selectOutlier   = 3 // '-': rejected by the clustering algorithm.
+	selectCandidate = 4 // '+': combined.
+	selectBackup    = 5 // '#': good, but not combined.
+	selectSysPeer   = 6 // '*': the system peer.
+	selectPPSPeer   = 7 // 'o': the system peer, with PPS.
+)
+
+func parseAssociations(data []byte) ([]association, error) {
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("association list has odd length %d", len(data))
+	}
+	result := make([]association, len(data)/4)
+	if err := binary.Read(bytes.NewReader(data), binary.BigEndian, result); err != nil {
+		return nil, fmt.Errorf("read association list: %w", err)
+	}
+	return result, nil
+}
+
+// parseVars parses the text that readvar returns, like `version="ntpd 4.2.8", leap=0, stratum=1`,
+// into a map.  Quotes around values are removed.
+func parseVars(data []byte) map[string]string {
+	result := make(map[string]string)
+	var items []string
+	inQuote := false
+	start := 0
+	for i, b := range data {
+		switch {
+		case b == '"':
+			inQuote = !inQuote
+		case b == ',' && !inQuote:
+			items = append(items, string(data[start:i]))
+			start = i + 1
+		}
+	}
+	items = append(items, string(data[start:]))
+	for _, item := range items {
+		item = strings.TrimSpace(strings.TrimRight(item, "\x00"))
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		name, value := strings.TrimSpace(parts[0]), ""
+		if len(parts) == 2 {
+			value = strings.TrimSpace(parts[1])
+			if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+				value = value[1 : len(value)-1]
+			}
+		}
+		result[name] = value
+	}
+	return result
+}
+
+// vars converts variables from their text form.  Missing variables are zero, since ntpd's
+// versions don't all have the same ones; the first unparseable one is kept in err.
+type vars struct {
+	m   map[string]string
+	err error
+}
+
+func (v *vars) fail(name string, err error) {
+	if v.err == nil {
+		v.err = fmt.Errorf("%s=%q: %w", name, v.m[name], err)
+	}
+}
+
+func (v *vars) float(name string) float64 {
+	s, ok := v.m[name]
+	if !ok {
+		return 0
+	}
+	x, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		v.fail(name, err)
+	}
+	return x
+}
+
+// millis converts a variable in milliseconds, as ntpd reports offsets and delays, to seconds.
+func (v *vars) millis(name string) float64 {
+	return v.float(name) / 1000
+}
+
+func (v *vars) int(name string) int64 {
+	s, ok := v.m[name]
+	if !ok {
+		return 0
+	}
+	x, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		v.fail(name, err)
+	}
+	return x
+}
+
+// uint parses an unsigned variable, which ntpd writes in hex for some, like reach=0xff.
+func (v *vars) uint(name string) uint64 {
+	s, ok := v.m[name]
+	if !ok {
+		return 0
+	}
+	x, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		v.fail(name, err)
+	}
+	return x
+}
+
+func (v *vars) ip(name string) net.IP {
+	s, ok := v.m[name]
+	if !ok {
+		return nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		v.fail(name, errors.New("invalid address"))
+	}
+	return ip
+}
+
+// ntpEpoch is the start of NTP era 0, 1900-01-01, in Unix time.
+const ntpEpoch = -2208988800
+
+// timestamp parses an NTP timestamp, like reftime=0xe4f0c3a1.80000000.  An all-zero timestamp,
+// which ntpd reports for times that haven't happened, is the zero time.
+func (v *vars) timestamp(name string) time.Time {
+	s, ok := v.m[name]
+	if !ok {
+		return time.Time{}
+	}
+	parts := strings.SplitN(strings.TrimPrefix(s, "0x"), ".", 2)
+	if len(parts) != 2 {
+		v.fail(name, errors.New("not an NTP timestamp"))
+		return time.Time{}
+	}
+	sec, err := strconv.ParseUint(parts[0], 16, 32)
+	if err != nil {
+		v.fail(name, err)
+		return time.Time{}
+	}
+	frac, err := strconv.ParseUint(parts[1], 16, 32)
+	if err != nil {
+		v.fail(name, err)
+		return time.Time{}
+	}
+	if sec == 0 && frac == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(sec)+ntpEpoch, int64(frac*1e9>>32))
+}
+
+// refID converts a refid to chrony's form: an IPv4 address as a number, or up to four characters
+// like "PPS" packed into one, left-aligned.
+func (v *vars) refID(name string) uint32 {
+	s, ok := v.m[name]
+	if !ok {
+		return 0
+	}
+	if ip := net.ParseIP(s).To4(); ip != nil {
+		return binary.BigEndian.Uint32(ip)
+	}
+	// ntpq shows kiss codes and reference clocks as ".PPS.", but ntpd doesn't.
+	s = strings.Trim(s, ".")
+	var id [4]byte
+	copy(id[:], s)
+	return binary.BigEndian.Uint32(id[:])
+}
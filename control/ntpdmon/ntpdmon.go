@@ -0,0 +1,186 @@
+// Package ntpdmon polls ntpd with NTP control messages, the protocol that ntpq speaks, and
+// describes what it reports in chronymon's terms, so that programs that monitor chronyd can
+// monitor ntpd too.
+//
+// ntpd has no equivalent of chronyd's activity, rtcdata, serverstats or clients reports, so they
+// are always nil.
+package ntpdmon
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/chrony"
+	"github.com/jrockway/beaglebone-gps-clock/control/chronymon"
+)
+
+// Poll connects to ntpd's control port at addr, like "localhost:123", takes one snapshot within
+// timeout, and disconnects.  An association that ntpd won't describe is left out, with a warning.
+func Poll(addr string, timeout time.Duration) (*chronymon.Snapshot, error) {
+	s := &chronymon.Snapshot{Time: time.Now()}
+	conn, err := net.DialTimeout("udp", addr, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(s.Time.Add(timeout)); err != nil {
+		return nil, fmt.Errorf("set deadline: %w", err)
+	}
+	c := &client{conn: conn}
+
+	_, data, err := c.request(opReadStat, 0)
+	if err != nil {
+		return nil, fmt.Errorf("read peer status: %w", err)
+	}
+	assocs, err := parseAssociations(data)
+	if err != nil {
+		return nil, fmt.Errorf("read peer status: %w", err)
+	}
+	_, data, err = c.request(opReadVar, 0)
+	if err != nil {
+		return nil, fmt.Errorf("read system variables: %w", err)
+	}
+	sys := &vars{m: parseVars(data)}
+	s.Tracking = tracking(sys)
+	if sys.err != nil {
+		return nil, fmt.Errorf("read system variables: %w", sys.err)
+	}
+
+	for _, a := range assocs {
+		_, data, err := c.request(opReadVar, a.ID)
+		if err != nil {
+			s.Warnings = append(s.Warnings, fmt.Errorf("association %d: read peer variables: %w", a.ID, err))
+			continue
+		}
+		peer := &vars{m: parseVars(data)}
+		src := source(a, peer, s.Time)
+		if peer.err != nil {
+			s.Warnings = append(s.Warnings, fmt.Errorf("association %d: read peer variables: %w", a.ID, peer.err))
+			continue
+		}
+		src.Index = len(s.Sources)
+		s.Sources = append(s.Sources, src)
+		if a.selection() >= selectSysPeer && src.NTP != nil {
+			s.Tracking.IPAddr = src.NTP.RemoteAddr
+		}
+	}
+	return s, nil
+}
+
+// tracking converts ntpd's system variables to chronyd's tracking report.
+func tracking(v *vars) chrony.Tracking {
+	return chrony.Tracking{
+		RefID:      v.refID("refid"),
+		Stratum:    uint16(v.uint("stratum")),
+		LeapStatus: uint16(v.uint("leap")),
+		RefTime:    v.timestamp("reftime"),
+		// ntpd's offset is how far behind true time the clock was at the last update, which is
+		// the correction that chronyd reports; ntpd doesn't say how much of it is left.  chronyd's
+		// last offset is how far ahead the clock was, so it's the other way around.
+		CurrentCorrection: v.millis("offset"),
+		LastOffset:        -v.millis("offset"),
+		RMSOffset:         v.millis("sys_jitter"),
+		// ntpd reports the correction it applies to the clock's frequency, and chronyd the
+		// clock's frequency error.
+		FreqPPM:            -v.float("frequency"),
+		SkewPPM:            v.float("clk_wander"),
+		RootDelay:          v.millis("rootdelay"),
+		RootDispersion:     v.millis("rootdisp"),
+		LastUpdateInterval: math.Pow(2, float64(v.int("tc"))),
+	}
+}
+
+// isRefclock returns true if ip is one of the 127.127.t.u addresses that ntpd gives reference
+// clocks.
+func isRefclock(ip net.IP) bool {
+	v4 := ip.To4()
+	return v4 != nil && v4[0] == 127 && v4[1] == 127
+}
+
+// state converts a peer selection code to chronyd's nearest source state.
+func state(a association) chrony.SourceStateType {
+	switch a.selection() {
+	case selectSysPeer, selectPPSPeer:
+		return chrony.SourceStateSync
+	case selectCandidate:
+		return chrony.SourceStateCandidate
+	case selectOutlier, selectExcess, selectBackup:
+		return chrony.SourceStateOutlier
+	case selectFalsetick:
+		return chrony.SourceStateFalseTicket
+	}
+	return chrony.SourceStateUnreach
+}
+
+// source converts an association's peer variables to chronyd's sources, sourcestats and ntpdata
+// reports.  Reference clocks are named by their refid, like chronyd's, and have no ntpdata.
+func source(a association, v *vars, now time.Time) chronymon.Source {
+	addr := v.ip("srcadr")
+	refID := v.refID("refid")
+	// ntpd's peer offset is positive when the peer is ahead of the local clock, and chronyd's when
+	// the local clock is ahead of the source.
+	offset := -v.millis("offset")
+	delay := v.millis("delay")
+	dispersion := v.millis("dispersion")
+	jitter := v.millis("jitter")
+	var since uint32
+	if rec := v.timestamp("rec"); !rec.IsZero() && rec.Before(now) {
+		since = uint32(now.Sub(rec) / time.Second)
+	}
+
+	src := chronymon.Source{
+		Data: chrony.SourceData{
+			IPAddr:         addr,
+			Poll:           int16(v.int("hpoll")),
+			Stratum:        uint16(v.uint("stratum")),
+			State:          state(a),
+			Mode:           chrony.SourceModeClient,
+			Reachability:   uint16(v.uint("reach")),
+			SinceSample:    since,
+			OrigLatestMeas: offset,
+			LatestMeas:     offset,
+			// The synchronization distance to the peer bounds the offset's error.
+			LatestMeasErr: delay/2 + dispersion,
+		},
+		Stats: chrony.SourceStats{
+			IPAddr:             addr,
+			StandardDeviation:  jitter,
+			EstimatedOffset:    offset,
+			EstimatedOffsetErr: delay/2 + dispersion,
+		},
+	}
+	switch v.uint("hmode") {
+	case 1, 2:
+		src.Data.Mode = chrony.SourceModePeer
+	}
+	if isRefclock(addr) {
+		src.Data.IPAddr = net.IPv4(byte(refID>>24), byte(refID>>16), byte(refID>>8), byte(refID))
+		src.Data.Mode = chrony.SourceModeRef
+		src.Stats.IPAddr = nil
+		src.Stats.RefID = refID
+		return src
+	}
+	if v4 := addr.To4(); v4 != nil {
+		src.Stats.RefID = uint32(v4[0])<<24 | uint32(v4[1])<<16 | uint32(v4[2])<<8 | uint32(v4[3])
+	}
+	src.NTP = &chrony.NTPData{
+		RemoteAddr:     addr,
+		LocalAddr:      v.ip("dstadr"),
+		RemotePort:     uint16(v.uint("srcport")),
+		Leap:           uint8(v.uint("leap")),
+		Mode:           uint8(v.uint("pmode")),
+		Stratum:        uint8(v.uint("stratum")),
+		Poll:           int8(v.int("ppoll")),
+		Precision:      int8(v.int("precision")),
+		RootDelay:      v.millis("rootdelay"),
+		RootDispersion: v.millis("rootdisp"),
+		RefID:          refID,
+		RefTime:        v.timestamp("reftime"),
+		Offset:         offset,
+		PeerDelay:      delay,
+		PeerDispersion: dispersion,
+	}
+	return src
+}
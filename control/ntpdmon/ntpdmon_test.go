@@ -0,0 +1,258 @@
+package ntpdmon_test
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/chrony"
+	"github.com/jrockway/beaglebone-gps-clock/control/chronymon"
+	"github.com/jrockway/beaglebone-gps-clock/control/chronymon/chronytest"
+	"github.com/jrockway/beaglebone-gps-clock/control/ntpdmon"
+	"github.com/jrockway/beaglebone-gps-clock/control/ntpdmon/ntpdtest"
+)
+
+// ntpTime formats t as an NTP timestamp, as ntpd does.
+func ntpTime(t time.Time) string {
+	return fmt.Sprintf("0x%08x.%08x", uint32(t.Unix()+2208988800), uint32((uint64(t.Nanosecond())<<32)/1e9))
+}
+
+// approx reports whether a and b agree to within rounding.
+func approx(a, b float64) bool {
+	return math.Abs(a-b) <= math.Abs(b)*1e-9
+}
+
+var refTime = time.Unix(1633046400, 500000000)
+
+func newFakeNtpd(t *testing.T) *ntpdtest.Server {
+	t.Helper()
+	s, err := ntpdtest.NewServer()
+	if err != nil {
+		t.Fatalf("start fake ntpd: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	s.SetSystem(0x0418, `version="ntpd 4.2.8p15@1.3728-o, built by someone", processor="armv7l",
+system="Linux/5.10.0", leap=0, stratum=1, precision=-20, rootdelay=0.000,
+rootdisp=1.015, refid=PPS, reftime=`+ntpTime(refTime)+`, clock=0xe5014a9c.12345678,
+peer=40001, tc=4, mintc=3, offset=0.002134, frequency=-12.512, sys_jitter=0.001907,
+clk_jitter=0.002, clk_wander=0.004, tai=37`)
+	s.SetPeers(
+		ntpdtest.Peer{ID: 40001, Status: ntpdtest.PeerStatus(7), Vars: `srcadr=127.127.22.0, srcport=123, dstadr=0.0.0.0, dstport=123, leap=0, stratum=0, precision=-20,
+rootdelay=0.000, rootdisp=0.000, refid=PPS, reftime=0xe5014a9b.00000000, rec=0xe5014a9b.00000000,
+reach=0xff, unreach=0, hmode=3, pmode=4, hpoll=4, ppoll=4, headway=0, flash=0x0, keyid=0,
+offset=0.003, delay=0.000, dispersion=0.938, jitter=0.002, filtdelay= 0.00 0.00 0.00`},
+		ntpdtest.Peer{ID: 40002, Status: ntpdtest.PeerStatus(4), Vars: `srcadr=192.0.2.1, srcport=123, dstadr=192.0.2.100, dstport=123, leap=0, stratum=2, precision=-23,
+rootdelay=10.500, rootdisp=20.250, refid=203.0.113.5, reftime=` + ntpTime(refTime) + `, rec=` + ntpTime(time.Now().Add(-30*time.Second)) + `,
+reach=0x3f, unreach=0, hmode=3, pmode=4, hpoll=6, ppoll=7, offset=-2.100, delay=15.000,
+dispersion=1.500, jitter=0.500`},
+		ntpdtest.Peer{ID: 40003, Status: ntpdtest.PeerStatus(1), Vars: `srcadr=2001:db8::1, srcport=123, dstadr=::, leap=3, stratum=16, refid=INIT, reach=0x0, hmode=1, hpoll=6, offset=0.000`},
+		ntpdtest.Peer{ID: 40004, Status: ntpdtest.PeerStatus(0), Vars: `srcadr=192.0.2.2`},
+	)
+	s.Fail(40004, ntpdtest.ErrorProhibited)
+	return s
+}
+
+// describe summarizes a snapshot's sources.
+func describe(s *chronymon.Snapshot) string {
+	var result []string
+	for _, src := range s.Sources {
+		result = append(result, fmt.Sprintf("%d:%s %s mode=%d reach=%o", src.Index, chronymon.SourceName(src.Data.IPAddr), chronymon.StateName(src.Data.State), src.Data.Mode, src.Data.Reachability))
+	}
+	return strings.Join(result, ", ")
+}
+
+func TestPoll(t *testing.T) {
+	testData := []struct {
+		name     string
+		fragment int
+		reverse  bool
+	}{
+		{name: "one fragment", fragment: 468},
+		{name: "several fragments", fragment: 64},
+		{name: "reordered fragments", fragment: 64, reverse: true},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			fake := newFakeNtpd(t)
+			fake.SetFragments(test.fragment, test.reverse)
+			s, err := ntpdmon.Poll(fake.Addr, time.Second)
+			if err != nil {
+				t.Fatalf("poll: %v", err)
+			}
+
+			tr := s.Tracking
+			if tr.RefID != 0x50505300 || tr.Stratum != 1 || tr.LeapStatus != 0 || tr.IPAddr != nil || !tr.RefTime.Equal(refTime) || tr.LastUpdateInterval != 16 {
+				t.Errorf("tracking: %+v", tr)
+			}
+			if !approx(tr.CurrentCorrection, 0.000002134) || !approx(tr.LastOffset, -0.000002134) || !approx(tr.RMSOffset, 0.000001907) || !approx(tr.FreqPPM, 12.512) || !approx(tr.SkewPPM, 0.004) || !approx(tr.RootDispersion, 0.001015) {
+				t.Errorf("tracking floats: %+v", tr)
+			}
+			if !s.Synchronized() {
+				t.Error("snapshot should be synchronized")
+			}
+
+			if got, want := describe(s), "0:PPS selected mode=2 reach=377, 1:192.0.2.1 combined mode=0 reach=77, 2:2001:db8::1 falseticker mode=1 reach=0"; got != want {
+				t.Errorf("sources:\n  got: %v\n want: %v", got, want)
+			}
+			if got, want := fmt.Sprint(s.Warnings), "[association 40004: read peer variables: got error: administratively prohibited]"; got != want {
+				t.Errorf("warnings:\n  got: %v\n want: %v", got, want)
+			}
+
+			pps := s.Sources[0]
+			if pps.NTP != nil || pps.Stats.RefID != 0x50505300 || pps.Stats.IPAddr != nil || pps.Data.Poll != 4 || !approx(pps.Data.LatestMeas, -0.000003) || !approx(pps.Stats.StandardDeviation, 0.000002) {
+				t.Errorf("reference clock: %+v", pps)
+			}
+			ntp := s.Sources[1]
+			if ntp.Stats.RefID != 0xc0000201 || !ntp.Stats.IPAddr.Equal(net.IPv4(192, 0, 2, 1)) || ntp.Data.Stratum != 2 || ntp.Data.Poll != 6 || !approx(ntp.Data.LatestMeas, 0.0021) || !approx(ntp.Data.LatestMeasErr, 0.009) {
+				t.Errorf("ntp source: %+v", ntp)
+			}
+			if since := ntp.Data.SinceSample; since < 29 || since > 35 {
+				t.Errorf("ntp source: since sample %v, want about 30", since)
+			}
+			want := &chrony.NTPData{
+				RemoteAddr:     net.ParseIP("192.0.2.1"),
+				LocalAddr:      net.ParseIP("192.0.2.100"),
+				RemotePort:     123,
+				Mode:           4,
+				Stratum:        2,
+				Poll:           7,
+				Precision:      -23,
+				RootDelay:      0.0105,
+				RootDispersion: 0.02025,
+				RefID:          0xcb007105,
+				RefTime:        refTime,
+				Offset:         0.0021,
+				PeerDelay:      0.015,
+				PeerDispersion: 0.0015,
+			}
+			if ntp.NTP == nil {
+				t.Fatal("ntp source has no ntpdata")
+			}
+			got := *ntp.NTP
+			if !approx(got.Offset, want.Offset) {
+				t.Errorf("ntpdata offset:\n  got: %v\n want: %v", got.Offset, want.Offset)
+			}
+			got.Offset = want.Offset
+			if !reflect.DeepEqual(got, *want) {
+				t.Errorf("ntpdata:\n  got: %+v\n want: %+v", got, *want)
+			}
+			if s.Activity != nil || s.RTC != nil || s.ServerStats != nil || s.Clients != nil {
+				t.Errorf("unexpected chrony reports: %+v", s)
+			}
+
+			wantRequests := []ntpdtest.Request{
+				{Opcode: ntpdtest.OpReadStat}, {Opcode: ntpdtest.OpReadVar},
+				{Opcode: ntpdtest.OpReadVar, AssocID: 40001}, {Opcode: ntpdtest.OpReadVar, AssocID: 40002},
+				{Opcode: ntpdtest.OpReadVar, AssocID: 40003}, {Opcode: ntpdtest.OpReadVar, AssocID: 40004},
+			}
+			if got := fake.Requests(); !reflect.DeepEqual(got, wantRequests) {
+				t.Errorf("requests:\n  got: %v\n want: %v", got, wantRequests)
+			}
+		})
+	}
+}
+
+func TestSignsMatchChrony(t *testing.T) {
+	// The local clock is 2ms fast, according to its one server, and both daemons are about to
+	// slow it down.
+	server := net.IPv4(192, 0, 2, 1)
+	ntpd := newFakeNtpd(t)
+	ntpd.SetSystem(0, "leap=0, stratum=2, refid=192.0.2.1, offset=-2.000")
+	ntpd.SetPeers(ntpdtest.Peer{ID: 1, Status: ntpdtest.PeerStatus(6), Vars: "srcadr=192.0.2.1, stratum=1, hmode=3, pmode=4, offset=-2.000"})
+	fromNtpd, err := ntpdmon.Poll(ntpd.Addr, time.Second)
+	if err != nil {
+		t.Fatalf("poll ntpd: %v", err)
+	}
+
+	chronyd, err := chronytest.NewServer()
+	if err != nil {
+		t.Fatalf("start fake chronyd: %v", err)
+	}
+	t.Cleanup(func() { chronyd.Close() })
+	chronyd.SetTracking(chrony.Tracking{Stratum: 2, CurrentCorrection: -0.002, LastOffset: 0.002})
+	chronyd.SetSources(
+		[]chrony.SourceData{{IPAddr: server, Stratum: 1, State: chrony.SourceStateSync, OrigLatestMeas: 0.002, LatestMeas: 0.002}},
+		[]chrony.SourceStats{{RefID: 0xc0000201, IPAddr: server, EstimatedOffset: 0.002}},
+	)
+	chronyd.SetNTPData(chrony.NTPData{RemoteAddr: server, Offset: 0.002})
+	fromChronyd, err := chronymon.Poll(chronyd.Addr, chronyd.Socket)
+	if err != nil {
+		t.Fatalf("poll chronyd: %v", err)
+	}
+	if len(fromNtpd.Sources) != 1 || len(fromChronyd.Sources) != 1 || fromNtpd.Sources[0].NTP == nil || fromChronyd.Sources[0].NTP == nil {
+		t.Fatalf("sources:\n ntpd: %+v\n chronyd: %+v", fromNtpd.Sources, fromChronyd.Sources)
+	}
+
+	n, c := fromNtpd.Sources[0], fromChronyd.Sources[0]
+	for _, field := range []struct {
+		name          string
+		ntpd, chronyd float64
+	}{
+		{"current correction", fromNtpd.Tracking.CurrentCorrection, fromChronyd.Tracking.CurrentCorrection},
+		{"last offset", fromNtpd.Tracking.LastOffset, fromChronyd.Tracking.LastOffset},
+		{"original latest measurement", n.Data.OrigLatestMeas, c.Data.OrigLatestMeas},
+		{"latest measurement", n.Data.LatestMeas, c.Data.LatestMeas},
+		{"estimated offset", n.Stats.EstimatedOffset, c.Stats.EstimatedOffset},
+		{"ntpdata offset", n.NTP.Offset, c.NTP.Offset},
+	} {
+		// chronyd's floats are only good to a few significant figures.
+		if math.Abs(field.ntpd-field.chronyd) > 1e-6 {
+			t.Errorf("%s:\n    ntpd: %v\n chronyd: %v", field.name, field.ntpd, field.chronyd)
+		}
+	}
+}
+
+func TestPollErrors(t *testing.T) {
+	testData := []struct {
+		name    string
+		setup   func(s *ntpdtest.Server)
+		wantErr string
+	}{
+		{
+			name: "bad system variable",
+			setup: func(s *ntpdtest.Server) {
+				s.SetSystem(0, "leap=0, stratum=one")
+			},
+			wantErr: `read system variables: stratum="one": strconv.ParseUint`,
+		},
+		{
+			name: "bad timestamp",
+			setup: func(s *ntpdtest.Server) {
+				s.SetSystem(0, "reftime=yesterday")
+			},
+			wantErr: `read system variables: reftime="yesterday": not an NTP timestamp`,
+		},
+		{
+			name: "unreachable",
+			setup: func(s *ntpdtest.Server) {
+				s.Close()
+			},
+			wantErr: "read peer status: read reply:",
+		},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			fake := newFakeNtpd(t)
+			test.setup(fake)
+			_, err := ntpdmon.Poll(fake.Addr, 100*time.Millisecond)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("poll: unexpected error:\n  got: %v\n want: ...%s...", err, test.wantErr)
+			}
+		})
+	}
+
+	// A bad peer only loses that peer.
+	fake := newFakeNtpd(t)
+	fake.SetPeers(ntpdtest.Peer{ID: 1, Status: ntpdtest.PeerStatus(4), Vars: "srcadr=ntp.example"})
+	s, err := ntpdmon.Poll(fake.Addr, time.Second)
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Warnings), `[association 1: read peer variables: srcadr="ntp.example": invalid address]`; got != want || len(s.Sources) > 0 {
+		t.Errorf("warnings:\n  got: %v\n want: %v\n sources: %v", got, want, s.Sources)
+	}
+}
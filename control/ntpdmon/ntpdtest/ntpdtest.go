@@ -0,0 +1,231 @@
+// Package ntpdtest provides a fake ntpd for tests.  It answers the NTP control messages (mode 6)
+// that ntpdmon sends, readstat and readvar, and can split its replies into fragments and send them
+// out of order, as UDP allows.
+package ntpdtest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Opcodes that the fake understands, from ntpd's ntp_control.h.
+const (
+	OpReadStat uint8 = 1
+	OpReadVar  uint8 = 2
+)
+
+// Error codes, from ntp_control.h.
+const (
+	ErrorUnspecified        uint8 = 0
+	ErrorPermission         uint8 = 1
+	ErrorBadFormat          uint8 = 2
+	ErrorBadOpcode          uint8 = 3
+	ErrorUnknownAssociation uint8 = 4
+	ErrorUnknownVariable    uint8 = 5
+	ErrorBadValue           uint8 = 6
+	ErrorProhibited         uint8 = 7
+)
+
+// maxData is the most data that ntpd puts in one fragment.
+const maxData = 468
+
+// Peer is one of the fake's associations.
+type Peer struct {
+	ID     uint16
+	Status uint16 // The peer status word; see PeerStatus.
+	Vars   string // As readvar returns them, like `srcadr=192.0.2.1, stratum=2`.
+}
+
+// PeerStatus returns the status word of a configured, reachable peer with the given selection
+// code, like 6 for the system peer.
+func PeerStatus(selection uint8) uint16 {
+	return uint16(0x80|0x10)<<8 | uint16(selection&7)<<8
+}
+
+// Request is a request that the server received.
+type Request struct {
+	Opcode  uint8
+	AssocID uint16
+}
+
+// header is the start of every control message.
+type header struct {
+	LIVNMode uint8
+	ROpcode  uint8
+	Sequence uint16
+	Status   uint16
+	AssocID  uint16
+	Offset   uint16
+	Count    uint16
+}
+
+// Server is a fake ntpd.
+type Server struct {
+	Addr string // The UDP control port, like "127.0.0.1:12345".
+
+	conn net.PacketConn
+
+	mu       sync.Mutex
+	status   uint16
+	system   string
+	peers    []Peer
+	failures map[uint16]uint8
+	fragment int
+	reverse  bool
+	requests []Request
+}
+
+// NewServer starts a fake ntpd with no associations.  Call Close when done with it.
+func NewServer() (*Server, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	s := &Server{Addr: conn.LocalAddr().String(), conn: conn, failures: map[uint16]uint8{}, fragment: maxData}
+	go s.serve()
+	return s, nil
+}
+
+// Close stops the server.
+func (s *Server) Close() error {
+	return s.conn.Close()
+}
+
+// SetSystem changes the system status word and the system variables.
+func (s *Server) SetSystem(status uint16, vars string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status, s.system = status, vars
+}
+
+// SetPeers changes the associations.
+func (s *Server) SetPeers(peers ...Peer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peers = peers
+}
+
+// SetFragments splits replies into fragments of at most size bytes of data, sent last first if
+// reverse is set.
+func (s *Server) SetFragments(size int, reverse bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fragment, s.reverse = size, reverse
+}
+
+// Fail makes readvar requests for an association fail with the given error code.
+func (s *Server) Fail(assocID uint16, code uint8) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[assocID] = code
+}
+
+// Requests returns the requests that the server has received, in order.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) serve() {
+	buf := make([]byte, 1024)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		for _, reply := range s.handle(buf[:n]) {
+			if _, err := s.conn.WriteTo(reply, addr); err != nil {
+				// The client may have gone away already.
+				break
+			}
+		}
+	}
+}
+
+// handle returns the fragments of the reply to one request.
+func (s *Server) handle(req []byte) [][]byte {
+	var head header
+	if err := binary.Read(bytes.NewReader(req), binary.BigEndian, &head); err != nil || head.LIVNMode&7 != 6 || head.ROpcode&0x80 != 0 {
+		return nil
+	}
+	opcode := head.ROpcode & 0x1f
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, Request{Opcode: opcode, AssocID: head.AssocID})
+	reply := header{LIVNMode: head.LIVNMode, ROpcode: 0x80 | opcode, Sequence: head.Sequence, AssocID: head.AssocID}
+	fail := func(code uint8) [][]byte {
+		reply.ROpcode |= 0x40
+		reply.Status = uint16(code) << 8
+		return [][]byte{encode(reply, nil)}
+	}
+
+	var data []byte
+	switch {
+	case opcode == OpReadStat && head.AssocID == 0:
+		reply.Status = s.status
+		buf := new(bytes.Buffer)
+		for _, p := range s.peers {
+			binary.Write(buf, binary.BigEndian, [2]uint16{p.ID, p.Status}) // nolint:errcheck
+		}
+		data = buf.Bytes()
+	case opcode == OpReadVar && head.AssocID == 0:
+		reply.Status = s.status
+		data = []byte(s.system)
+	case opcode == OpReadVar:
+		if code, ok := s.failures[head.AssocID]; ok {
+			return fail(code)
+		}
+		var peer *Peer
+		for i := range s.peers {
+			if s.peers[i].ID == head.AssocID {
+				peer = &s.peers[i]
+			}
+		}
+		if peer == nil {
+			return fail(ErrorUnknownAssociation)
+		}
+		reply.Status = peer.Status
+		data = []byte(peer.Vars)
+	default:
+		return fail(ErrorBadOpcode)
+	}
+
+	var result [][]byte
+	for offset := 0; ; offset += s.fragment {
+		end := offset + s.fragment
+		if end >= len(data) {
+			end = len(data)
+		} else {
+			reply.ROpcode |= 0x20
+		}
+		reply.Offset = uint16(offset)
+		result = append(result, encode(reply, data[offset:end]))
+		reply.ROpcode &^= 0x20
+		if end == len(data) {
+			break
+		}
+	}
+	if s.reverse {
+		for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+			result[i], result[j] = result[j], result[i]
+		}
+	}
+	return result
+}
+
+// encode encodes one fragment of a reply, padding its data to a multiple of four bytes.
+func encode(head header, data []byte) []byte {
+	head.Count = uint16(len(data))
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, head) // nolint:errcheck
+	buf.Write(data)
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,70 @@
+package pixelstream
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+)
+
+// DDPPort is the UDP port that DDP uses.
+const DDPPort = 4048
+
+// DDP header fields; see http://www.3waylabs.com/ddp/.
+const (
+	ddpHeaderLen   = 10
+	ddpVersionMask = 0xc0
+	ddpVersion1    = 0x40
+	ddpTimecode    = 0x10 // A 4-byte timecode follows the header.
+	ddpQuery       = 0x02
+	ddpPush        = 0x01
+
+	ddpDefaultID   = 1   // The default output device.
+	ddpBroadcastID = 255 // All devices.
+)
+
+// ddpPacket is the part of a DDP packet that matters to a display.
+type ddpPacket struct {
+	offset int
+	data   []byte
+	push   bool
+	ignore bool // The packet is valid, but not pixel data for us.
+}
+
+func parseDDP(pkt []byte) (ddpPacket, error) {
+	if len(pkt) < ddpHeaderLen {
+		return ddpPacket{}, badPacket("ddp: %d-byte packet is shorter than the header", len(pkt))
+	}
+	flags := pkt[0]
+	if flags&ddpVersionMask != ddpVersion1 {
+		return ddpPacket{}, badPacket("ddp: unsupported version %d", flags>>6)
+	}
+	header := ddpHeaderLen
+	if flags&ddpTimecode != 0 {
+		header += 4
+	}
+	length := int(binary.BigEndian.Uint16(pkt[8:10]))
+	if len(pkt) < header+length {
+		return ddpPacket{}, badPacket("ddp: packet has %d bytes of data; header says %d", len(pkt)-header, length)
+	}
+	id := pkt[3]
+	if flags&ddpQuery != 0 || (id != 0 && id != ddpDefaultID && id != ddpBroadcastID) {
+		return ddpPacket{ignore: true}, nil
+	}
+	return ddpPacket{
+		offset: int(binary.BigEndian.Uint32(pkt[4:8])),
+		data:   pkt[header : header+length],
+		push:   flags&ddpPush != 0,
+	}, nil
+}
+
+// ServeDDP displays DDP frames arriving on conn until the context is cancelled.  A frame is
+// displayed when a packet with the push flag arrives.
+func (s *Server) ServeDDP(ctx context.Context, conn net.PacketConn) error {
+	return s.servePackets(ctx, "ddp", conn, func(pkt []byte) error {
+		p, err := parseDDP(pkt)
+		if err != nil || p.ignore {
+			return err
+		}
+		return s.update("ddp", p.offset, p.data, p.push)
+	})
+}
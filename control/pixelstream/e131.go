@@ -0,0 +1,83 @@
+package pixelstream
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+)
+
+// E131Port is the UDP port that E1.31 (sACN) uses.
+const E131Port = 5568
+
+// Offsets and values of E1.31 data packet fields; see ANSI E1.31-2018.
+const (
+	e131MinLen          = 126
+	e131RootVectorData  = 4
+	e131FrameVectorData = 2
+	e131DMPVector       = 2
+	e131AddressType     = 0xa1
+	e131OptionPreview   = 0x80 // Data for visualizers, not real outputs.
+	e131OptionStop      = 0x40 // The source has stopped sending this universe.
+	e131NullStartCode   = 0
+
+	// e131PixelsPerUniverse is how many RGB pixels fit in a 512-channel universe.
+	e131PixelsPerUniverse = 512 / 3
+)
+
+var e131PacketID = []byte("ASC-E1.17\x00\x00\x00")
+
+// e131Packet is the part of an E1.31 data packet that matters to a display.
+type e131Packet struct {
+	universe uint16
+	data     []byte // DMX channel values, not including the start code.
+	ignore   bool   // The packet is valid, but not pixel data for us.
+}
+
+func parseE131(pkt []byte) (e131Packet, error) {
+	if len(pkt) < e131MinLen {
+		return e131Packet{}, badPacket("e1.31: %d-byte packet is too short", len(pkt))
+	}
+	if binary.BigEndian.Uint16(pkt[0:2]) != 0x0010 || !bytes.Equal(pkt[4:16], e131PacketID) {
+		return e131Packet{}, badPacket("e1.31: not an ACN packet")
+	}
+	if binary.BigEndian.Uint32(pkt[18:22]) != e131RootVectorData {
+		// Synchronization and discovery packets.
+		return e131Packet{ignore: true}, nil
+	}
+	if binary.BigEndian.Uint32(pkt[40:44]) != e131FrameVectorData || pkt[117] != e131DMPVector || pkt[118] != e131AddressType {
+		return e131Packet{}, badPacket("e1.31: malformed data packet")
+	}
+	count := int(binary.BigEndian.Uint16(pkt[123:125]))
+	if count < 1 || len(pkt) < 125+count {
+		return e131Packet{}, badPacket("e1.31: packet has %d property values; header says %d", len(pkt)-125, count)
+	}
+	options := pkt[112]
+	if options&(e131OptionPreview|e131OptionStop) != 0 || pkt[125] != e131NullStartCode {
+		return e131Packet{ignore: true}, nil
+	}
+	return e131Packet{
+		universe: binary.BigEndian.Uint16(pkt[113:115]),
+		data:     pkt[126 : 125+count],
+	}, nil
+}
+
+// ServeE131 displays E1.31 frames arriving on conn until the context is cancelled.  The display
+// takes up consecutive universes starting at firstUniverse, with 170 pixels in each, and a frame
+// is displayed when the last of them arrives.
+func (s *Server) ServeE131(ctx context.Context, conn net.PacketConn, firstUniverse uint16) error {
+	pixels := len(s.frame) / 3
+	lastUniverse := int(firstUniverse) + (pixels-1)/e131PixelsPerUniverse
+	return s.servePackets(ctx, "e131", conn, func(pkt []byte) error {
+		p, err := parseE131(pkt)
+		if err != nil || p.ignore || p.universe < firstUniverse || int(p.universe) > lastUniverse {
+			return err
+		}
+		data := p.data
+		if len(data) > 3*e131PixelsPerUniverse {
+			data = data[:3*e131PixelsPerUniverse]
+		}
+		offset := 3 * e131PixelsPerUniverse * int(p.universe-firstUniverse)
+		return s.update("e131", offset, data, int(p.universe) == lastUniverse)
+	})
+}
@@ -0,0 +1,78 @@
+package pixelstream
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+)
+
+// OPCPort is the TCP port that Open Pixel Control uses.
+const OPCPort = 7890
+
+const (
+	opcHeaderLen    = 4
+	opcBroadcast    = 0 // Channel 0 addresses every device.
+	opcChannel      = 1 // The display is the first channel.
+	opcSetPixels    = 0 // Command to set 8-bit RGB pixel colors.
+	opcMaxDataBytes = 65535
+)
+
+// ServeOPC accepts Open Pixel Control connections on l until the context is cancelled.  Every "set
+// pixel colors" message is a complete frame, starting at the first pixel.
+func (s *Server) ServeOPC(ctx context.Context, l net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("serve opc: %w", ctx.Err())
+			}
+			return fmt.Errorf("serve opc: accept: %w", err)
+		}
+		go func() {
+			defer conn.Close()
+			if err := s.serveOPCConn(ctx, conn); err != nil {
+				log.Printf("pixelstream: opc client %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// serveOPCConn handles messages from one OPC client until it disconnects.
+func (s *Server) serveOPCConn(ctx context.Context, conn net.Conn) error {
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+	header := make([]byte, opcHeaderLen)
+	data := make([]byte, opcMaxDataBytes)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("read header: %w", err)
+		}
+		length := int(binary.BigEndian.Uint16(header[2:4]))
+		if _, err := io.ReadFull(r, data[:length]); err != nil {
+			return fmt.Errorf("read %d bytes of data: %w", length, err)
+		}
+		packetsCounter.WithLabelValues("opc").Inc()
+		channel, command := header[0], header[1]
+		if command != opcSetPixels || (channel != opcBroadcast && channel != opcChannel) {
+			continue
+		}
+		if err := s.update("opc", 0, data[:length], true); err != nil {
+			log.Printf("pixelstream: %v", err)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Package pixelstream receives frames for the LED matrix from other programs over the network, so
+// that lighting controllers and WLED-style effects can use the display.  It understands DDP,
+// E1.31 (sACN) and Open Pixel Control.
+//
+// Every protocol addresses the display as a strip of RGB pixels.  Pixel i is at column i%width and
+// row i/width, counting from the top left of the display; the physical wiring of the panels is
+// handled by the screen package.
+package pixelstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	packetsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pixelstream_packets",
+		Help: "count of pixel stream packets received, by protocol",
+	}, []string{"protocol"})
+
+	badPacketsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pixelstream_bad_packets",
+		Help: "count of pixel stream packets that were ignored because they couldn't be parsed, by protocol",
+	}, []string{"protocol"})
+
+	framesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pixelstream_frames",
+		Help: "count of frames sent to the display, by protocol",
+	}, []string{"protocol"})
+)
+
+// Display is where frames go; *screen.Screen implements it.
+type Display interface {
+	EmptyCanvas() *image.NRGBA64
+	Stream(img image.Image) error
+}
+
+// Server assembles frames from the network and sends them to a Display.  One Server can serve
+// several protocols at once; they share a frame buffer.
+type Server struct {
+	display Display
+	bounds  image.Rectangle
+
+	mu    sync.Mutex
+	frame []byte // RGB, 3 bytes per pixel; must hold mu.
+}
+
+// New returns a Server that draws on d.
+func New(d Display) *Server {
+	b := d.EmptyCanvas().Bounds()
+	return &Server{
+		display: d,
+		bounds:  b,
+		frame:   make([]byte, 3*b.Dx()*b.Dy()),
+	}
+}
+
+// update copies data into the frame buffer, starting at byte offset, and displays the frame if
+// push is true.  Data beyond the end of the display is ignored.
+func (s *Server) update(protocol string, offset int, data []byte, push bool) error {
+	s.mu.Lock()
+	if offset < len(s.frame) {
+		copy(s.frame[offset:], data)
+	}
+	if !push {
+		s.mu.Unlock()
+		return nil
+	}
+	img := s.image()
+	s.mu.Unlock()
+	framesCounter.WithLabelValues(protocol).Inc()
+	if err := s.display.Stream(img); err != nil {
+		return fmt.Errorf("display %s frame: %w", protocol, err)
+	}
+	return nil
+}
+
+// image returns the frame buffer as an image; must hold mu.
+func (s *Server) image() *image.NRGBA64 {
+	img := image.NewNRGBA64(s.bounds)
+	w := s.bounds.Dx()
+	for i := 0; i+2 < len(s.frame); i += 3 {
+		p := i / 3
+		img.SetNRGBA64(s.bounds.Min.X+p%w, s.bounds.Min.Y+p/w, color.NRGBA64{
+			R: uint16(s.frame[i]) * 0x101,
+			G: uint16(s.frame[i+1]) * 0x101,
+			B: uint16(s.frame[i+2]) * 0x101,
+			A: 0xffff,
+		})
+	}
+	return img
+}
+
+// servePackets reads packets from conn and passes them to handle until the context is cancelled
+// or the connection fails.  Packets that handle can't parse are counted and dropped.
+func (s *Server) servePackets(ctx context.Context, protocol string, conn net.PacketConn, handle func([]byte) error) error {
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("serve %s: %w", protocol, ctx.Err())
+			}
+			return fmt.Errorf("serve %s: read: %w", protocol, err)
+		}
+		packetsCounter.WithLabelValues(protocol).Inc()
+		if err := handle(buf[:n]); err != nil {
+			var pe *packetError
+			if !errors.As(err, &pe) {
+				log.Printf("pixelstream: %v", err)
+				continue
+			}
+			badPacketsCounter.WithLabelValues(protocol).Inc()
+		}
+	}
+}
+
+// packetError is returned for packets that can't be parsed.
+type packetError struct {
+	msg string
+}
+
+func (e *packetError) Error() string {
+	return e.msg
+}
+
+func badPacket(format string, args ...interface{}) error {
+	return &packetError{msg: fmt.Sprintf(format, args...)}
+}
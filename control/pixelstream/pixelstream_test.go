@@ -0,0 +1,201 @@
+package pixelstream
+
+import (
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeDisplay is a 4x2 display that sends every frame to a channel.
+type fakeDisplay struct {
+	frames chan image.Image
+}
+
+func (d *fakeDisplay) EmptyCanvas() *image.NRGBA64 {
+	return image.NewNRGBA64(image.Rect(0, 0, 4, 2))
+}
+
+func (d *fakeDisplay) Stream(img image.Image) error {
+	d.frames <- img
+	return nil
+}
+
+func newTestServer(t *testing.T) (*Server, *fakeDisplay) {
+	t.Helper()
+	d := &fakeDisplay{frames: make(chan image.Image, 10)}
+	return New(d), d
+}
+
+func (d *fakeDisplay) next(t *testing.T) image.Image {
+	t.Helper()
+	select {
+	case img := <-d.frames:
+		return img
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a frame")
+	}
+	return nil
+}
+
+func (d *fakeDisplay) none(t *testing.T) {
+	t.Helper()
+	select {
+	case <-d.frames:
+		t.Fatal("unexpected frame")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// listenUDP starts serve on a local UDP socket, and returns a connection to it.
+func listenUDP(t *testing.T, serve func(context.Context, net.PacketConn) error) net.Conn {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error)
+	go func() { errCh <- serve(ctx, pc) }()
+	t.Cleanup(func() {
+		cancel()
+		<-errCh
+	})
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func send(t *testing.T, conn net.Conn, pkt []byte) {
+	t.Helper()
+	if _, err := conn.Write(pkt); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+}
+
+func checkPixel(t *testing.T, img image.Image, x, y int, want color.NRGBA) {
+	t.Helper()
+	r, g, b, _ := img.At(x, y).RGBA()
+	if got := (color.NRGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: 0xff}); got != want {
+		t.Errorf("pixel (%d, %d):\n  got: %v\n want: %v", x, y, got, want)
+	}
+}
+
+func newDDPPacket(flags byte, id byte, offset int, data []byte) []byte {
+	pkt := make([]byte, ddpHeaderLen, ddpHeaderLen+len(data))
+	pkt[0] = ddpVersion1 | flags
+	pkt[2] = 0x0b // 8-bit RGB
+	pkt[3] = id
+	binary.BigEndian.PutUint32(pkt[4:8], uint32(offset))
+	length := len(data)
+	if flags&ddpTimecode != 0 {
+		length -= 4 // The data starts with the timecode.
+	}
+	binary.BigEndian.PutUint16(pkt[8:10], uint16(length))
+	return append(pkt, data...)
+}
+
+func TestDDP(t *testing.T) {
+	s, d := newTestServer(t)
+	conn := listenUDP(t, s.ServeDDP)
+
+	send(t, conn, newDDPPacket(0, ddpDefaultID, 0, []byte{255, 0, 0}))
+	send(t, conn, []byte{0x40, 0, 0}) // Too short; ignored.
+	send(t, conn, newDDPPacket(0, 250, 3, []byte(`{"config":{}}`)))
+	send(t, conn, newDDPPacket(ddpPush, ddpDefaultID, 3*5, []byte{0, 0, 255, 1, 2, 3, 4, 5, 6, 7, 8, 9}))
+	img := d.next(t)
+	checkPixel(t, img, 0, 0, color.NRGBA{R: 255, A: 0xff})
+	checkPixel(t, img, 1, 0, color.NRGBA{A: 0xff})
+	checkPixel(t, img, 1, 1, color.NRGBA{B: 255, A: 0xff})
+	checkPixel(t, img, 3, 1, color.NRGBA{R: 4, G: 5, B: 6, A: 0xff})
+
+	// Data past the end of the display is dropped.
+	send(t, conn, newDDPPacket(ddpPush|ddpTimecode, ddpBroadcastID, 1000, append([]byte{0, 0, 0, 0}, 1, 2, 3)))
+	checkPixel(t, d.next(t), 0, 0, color.NRGBA{R: 255, A: 0xff})
+}
+
+func newE131Packet(universe uint16, options byte, data []byte) []byte {
+	pkt := make([]byte, e131MinLen, e131MinLen+len(data))
+	binary.BigEndian.PutUint16(pkt[0:2], 0x0010)
+	copy(pkt[4:16], e131PacketID)
+	binary.BigEndian.PutUint32(pkt[18:22], e131RootVectorData)
+	binary.BigEndian.PutUint32(pkt[40:44], e131FrameVectorData)
+	copy(pkt[44:], "test")
+	pkt[108] = 100 // priority
+	pkt[112] = options
+	binary.BigEndian.PutUint16(pkt[113:115], universe)
+	pkt[117] = e131DMPVector
+	pkt[118] = e131AddressType
+	binary.BigEndian.PutUint16(pkt[121:123], 1)
+	binary.BigEndian.PutUint16(pkt[123:125], uint16(1+len(data)))
+	return append(pkt, data...)
+}
+
+func TestE131(t *testing.T) {
+	s, d := newTestServer(t)
+	// The fake display is small enough to fit in one universe; make it need two.
+	s.frame = make([]byte, 3*(e131PixelsPerUniverse+1))
+	s.bounds = image.Rect(0, 0, e131PixelsPerUniverse+1, 1)
+	conn := listenUDP(t, func(ctx context.Context, pc net.PacketConn) error {
+		return s.ServeE131(ctx, pc, 7)
+	})
+
+	first := make([]byte, 512)
+	first[0], first[509], first[510] = 10, 20, 30
+	send(t, conn, newE131Packet(6, 0, []byte{99, 99, 99}))
+	send(t, conn, newE131Packet(7, 0, first))
+	send(t, conn, newE131Packet(8, e131OptionPreview, []byte{99, 99, 99}))
+	send(t, conn, newE131Packet(9, 0, []byte{99, 99, 99}))
+	d.none(t)
+	send(t, conn, newE131Packet(8, 0, []byte{1, 2, 3, 99}))
+	img := d.next(t)
+	checkPixel(t, img, 0, 0, color.NRGBA{R: 10, A: 0xff})
+	checkPixel(t, img, e131PixelsPerUniverse-1, 0, color.NRGBA{B: 20, A: 0xff})
+	checkPixel(t, img, e131PixelsPerUniverse, 0, color.NRGBA{R: 1, G: 2, B: 3, A: 0xff})
+}
+
+func TestOPC(t *testing.T) {
+	s, d := newTestServer(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error)
+	go func() { errCh <- s.ServeOPC(ctx, l) }()
+	defer func() {
+		cancel()
+		<-errCh
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	msg := func(channel, command byte, data ...byte) []byte {
+		return append([]byte{channel, command, byte(len(data) >> 8), byte(len(data))}, data...)
+	}
+	var stream []byte
+	stream = append(stream, msg(2, opcSetPixels, 9, 9, 9)...)
+	stream = append(stream, msg(opcChannel, 0xff, 0, 1, 2, 3)...)
+	stream = append(stream, msg(opcChannel, opcSetPixels, 1, 2, 3, 4, 5, 6)...)
+	if _, err := conn.Write(stream); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	img := d.next(t)
+	checkPixel(t, img, 0, 0, color.NRGBA{R: 1, G: 2, B: 3, A: 0xff})
+	checkPixel(t, img, 1, 0, color.NRGBA{R: 4, G: 5, B: 6, A: 0xff})
+
+	if _, err := conn.Write(msg(opcBroadcast, opcSetPixels, 7, 8, 9)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	checkPixel(t, d.next(t), 0, 0, color.NRGBA{R: 7, G: 8, B: 9, A: 0xff})
+	d.none(t)
+}
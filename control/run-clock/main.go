@@ -10,8 +10,14 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/jrockway/beaglebone-gps-clock/control/alarm"
 	"github.com/jrockway/beaglebone-gps-clock/control/clock"
+	"github.com/jrockway/beaglebone-gps-clock/control/config"
 	"github.com/jrockway/beaglebone-gps-clock/control/screen"
+	"github.com/jrockway/beaglebone-gps-clock/control/sdnotify"
+	"github.com/jrockway/beaglebone-gps-clock/control/timedaemon"
+	"github.com/jrockway/beaglebone-gps-clock/control/tzlookup"
+	"github.com/jrockway/beaglebone-gps-clock/control/webhook"
 	"github.com/jrockway/periphflag"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"periph.io/x/extra/hostextra"
@@ -19,17 +25,35 @@ import (
 )
 
 var (
-	bind = flag.String("bind", ":8080", "address to bind for debug/metrics server")
-	spi  string
+	configPath = flag.String("config", config.DefaultPath, "configuration file")
+	bind       = flag.String("bind", "", "address to bind for debug/metrics server; overrides http.bind in the config")
+	spi        string
 )
 
 func main() {
 	if _, err := hostextra.Init(); err != nil {
 		log.Fatalf("init periph.io: %v", err)
 	}
-	periphflag.SPIDevVar(&spi, "spi", "", "spi bus that the display is on")
+	periphflag.SPIDevVar(&spi, "spi", "", "spi bus that the display is on; overrides display.spi in the config")
 	flag.Parse()
 
+	notifier, err := sdnotify.FromEnv()
+	if err != nil {
+		log.Printf("systemd watchdog disabled: %v", err)
+	}
+
+	cfg, err := config.NewWatcher(*configPath)
+	if err != nil {
+		log.Fatalf("load config: %v", err)
+	}
+	startupConfig := cfg.Current()
+	if *bind == "" {
+		*bind = startupConfig.HTTP.Bind
+	}
+	if spi == "" {
+		spi = startupConfig.Display.SPI
+	}
+
 	spiPort, err := spireg.Open(spi)
 	if err != nil {
 		log.Fatalf("open spi port %q: %v", spi, err)
@@ -43,11 +67,37 @@ func main() {
 	}
 	leds.Blank()
 
+	alarms, err := alarm.Open(startupConfig.Alarm.File)
+	if err != nil {
+		log.Fatalf("load alarms: %v", err)
+	}
+	alarms.SetEventListener(func(ev alarm.Event) {
+		log.Printf("alarm %q: %s", ev.Rule.ID, ev.Type)
+		if url := cfg.Current().Alarm.Webhook; url != "" {
+			go func() {
+				if err := webhook.Post(ctx, url, ev); err != nil {
+					log.Printf("alarm webhook: %v", err)
+				}
+			}()
+		}
+	})
+
 	http.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 		http.Redirect(w, req, "/display.png", http.StatusFound)
 	})
 	http.Handle("/display.png", leds)
+	http.Handle("/diagnostics/", http.StripPrefix("/diagnostics", leds.DiagnosticsHandler()))
 	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/debug/config", cfg)
+	cl := clock.New(leds)
+	http.Handle("/timer/", http.StripPrefix("/timer", cl.TimerHandler()))
+	http.Handle("/faces/", http.StripPrefix("/faces", cl.FaceHandler()))
+	cl.SetAlarms(alarms)
+	http.Handle("/alarms/", http.StripPrefix("/alarms", alarms))
+	status := newStatusReporter(ctx, cl, cfg)
+	http.Handle("/status/", http.StripPrefix("/status", status))
+
+	serveStreams(ctx, startupConfig, leds)
 
 	httpDoneCh := make(chan error)
 	httpServer := http.Server{Addr: *bind}
@@ -61,10 +111,9 @@ func main() {
 		close(httpDoneCh)
 	}()
 
-	sigCh := make(chan os.Signal)
+	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
 
-	cl := clock.New(leds)
 	loopDoneCh := make(chan error)
 	go func() {
 		err := cl.Run(ctx)
@@ -77,23 +126,86 @@ func main() {
 
 	cl.BrightnessCh <- 0x1000
 
+	var gpsZone tzlookup.Tracker
+	applyConfig := func(c *config.Config) {
+		if c.FollowsGPS() {
+			cl.SetLocation(gpsZone.Location(ctx, func() string { return cfg.Current().Gpsd.Addr }, func(loc *time.Location) {
+				if cfg.Current().FollowsGPS() {
+					cl.SetLocation(loc)
+				}
+			}))
+		} else {
+			// Validation guarantees that the location loads.
+			loc, _ := c.TimeLocation()
+			cl.SetLocation(loc)
+		}
+		tr := clock.DefaultTransitions
+		tr.Style = clock.TransitionStyle(c.Display.Transition)
+		if err := cl.SetTransitions(tr); err != nil {
+			log.Printf("display.transition: %v", err)
+		}
+		if err := leds.SetOrientation(screen.Orientation(c.Display.Orientation)); err != nil {
+			log.Printf("display.orientation: %v", err)
+		}
+		if err := cl.SetTheme(c.Display.Theme); err != nil {
+			log.Printf("display.theme: %v", err)
+		}
+		if c.Display.Faces == "" {
+			cl.SetFaceFile(nil)
+		} else if ff, err := clock.LoadFaceFile(c.Display.Faces); err != nil {
+			log.Printf("display.faces: %v; keeping the previous faces", err)
+		} else {
+			cl.SetFaceFile(ff)
+		}
+		if c.HTTP.Bind != startupConfig.HTTP.Bind || c.Display.SPI != startupConfig.Display.SPI || c.Alarm.File != startupConfig.Alarm.File {
+			log.Printf("http.bind, display.spi or alarm.file changed; restart to apply")
+		}
+		if c.Stream != startupConfig.Stream {
+			log.Printf("stream changed; restart to apply")
+		}
+	}
+	applyConfig(startupConfig)
+	cfg.OnChange(applyConfig)
+	status.show()
+	go cfg.Run(ctx, 10*time.Second) // nolint:errcheck
+	go superviseMQTT(ctx, cfg, cl)
+	syncMon := timedaemon.NewMonitor(cfg, false)
+	go syncMon.Run(ctx) // nolint:errcheck
+	go watchSync(ctx, syncMon, cl)
+
+	// Only tell systemd we're alive while ticks are making it to the display; if the clock loop
+	// hangs, systemd will restart us.
+	go notifier.RunWatchdog(ctx, func() bool { // nolint:errcheck
+		return time.Since(cl.LastTick()) < 3*time.Second
+	})
+	if err := notifier.Notify(sdnotify.Ready); err != nil {
+		log.Printf("notify systemd: %v", err)
+	}
+	cfg.NotifyReloads(notifier)
+
 	httpAlive := true
+	exitCode := 1
 	select {
 	case err := <-httpDoneCh:
 		log.Printf("http server died: %v", err)
 		httpAlive = false
 	case err := <-loopDoneCh:
 		log.Printf("clock loop died: %v", err)
-	case <-sigCh:
-		log.Printf("interrupt")
+	case sig := <-sigCh:
+		log.Printf("%v; shutting down", sig)
+		exitCode = 0
+	}
+	if err := notifier.Notify(sdnotify.Stopping); err != nil {
+		log.Printf("notify systemd: %v", err)
 	}
 	signal.Stop(sigCh)
 	cancel()
+	leds.StopDiagnostics()
 	leds.Blank()
 	if httpAlive {
 		tctx, c := context.WithTimeout(context.Background(), time.Second)
 		httpServer.Shutdown(tctx)
 		c()
 	}
-	os.Exit(1)
+	os.Exit(exitCode)
 }
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/jrockway/beaglebone-gps-clock/control/clock"
+	"github.com/jrockway/beaglebone-gps-clock/control/config"
+	"github.com/jrockway/beaglebone-gps-clock/control/mqtt"
+)
+
+// runMQTT bridges the clock to an MQTT broker.  The clock's state is published (retained) to
+// <prefix>/face, <prefix>/brightness and <prefix>/message, and can be changed by publishing to the
+// same topics with /set appended.  Brightness is a percentage.  <prefix>/alarm is the label of the
+// ringing alarm, or empty.
+func runMQTT(ctx context.Context, brokerURL, prefix string, cl *clock.Clock) error {
+	c, err := mqtt.ParseURL(brokerURL, "run-clock")
+	if err != nil {
+		return fmt.Errorf("configure mqtt: %w", err)
+	}
+	availability := prefix + "/availability"
+	c.Will = &mqtt.Message{Topic: availability, Payload: []byte("offline"), Retain: true}
+	c.OnConnect = func() {
+		c.Publish(mqtt.Message{Topic: availability, Payload: []byte("online"), Retain: true}) // nolint:errcheck
+	}
+
+	publishState := func(s clock.State) {
+		for topic, payload := range map[string]string{
+			"face":       s.Face,
+			"brightness": strconv.FormatFloat(100*float64(s.Brightness)/0xffff, 'f', 1, 64),
+			"message":    s.Message,
+			"alarm":      s.Alarm,
+		} {
+			if err := c.Publish(mqtt.Message{Topic: prefix + "/" + topic, Payload: []byte(payload), Retain: true}); err != nil && err != mqtt.ErrNotConnected {
+				log.Printf("mqtt: publish %s: %v", topic, err)
+			}
+		}
+	}
+	// The listener runs on the clock loop, so it only queues states for publishing here.
+	stateCh := make(chan clock.State, 1)
+	cl.SetStateListener(stateSender(stateCh))
+	publishState(cl.State())
+	go func() {
+		for {
+			select {
+			case s := <-stateCh:
+				publishState(s)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Handlers run on the MQTT read loop, so hand values to the clock from another goroutine
+	// rather than blocking until the clock loop gets around to reading them.
+	c.Subscribe(prefix+"/face/set", func(m mqtt.Message) {
+		face := strings.TrimSpace(string(m.Payload))
+		if !cl.HasFace(face) {
+			log.Printf("mqtt: ignoring unknown face %q", face)
+			return
+		}
+		go func() {
+			select {
+			case cl.FaceCh <- face:
+			case <-ctx.Done():
+			}
+		}()
+	})
+	c.Subscribe(prefix+"/brightness/set", func(m mqtt.Message) {
+		pct, err := strconv.ParseFloat(strings.TrimSpace(string(m.Payload)), 64)
+		if err != nil || pct < 0 || pct > 100 {
+			log.Printf("mqtt: ignoring invalid brightness %q; want a percentage", m.Payload)
+			return
+		}
+		b := uint16(pct / 100 * 0xffff)
+		go func() {
+			select {
+			case cl.BrightnessCh <- b:
+			case <-ctx.Done():
+			}
+		}()
+	})
+	c.Subscribe(prefix+"/message/set", func(m mqtt.Message) {
+		msg := string(m.Payload)
+		go func() {
+			select {
+			case cl.MessageCh <- msg:
+			case <-ctx.Done():
+			}
+		}()
+	})
+	return c.Run(ctx)
+}
+
+// stateSender returns a state listener that hands the latest state to ch without blocking the
+// clock loop, replacing any state that the bridge hasn't published yet.  ch must have a buffer.
+func stateSender(ch chan clock.State) func(clock.State) {
+	return func(s clock.State) {
+		for {
+			select {
+			case ch <- s:
+				return
+			default:
+			}
+			select {
+			case <-ch:
+			default:
+			}
+		}
+	}
+}
+
+// superviseMQTT runs the MQTT bridge with the broker and prefix from the current configuration,
+// restarting it whenever they change, until the context is cancelled.
+func superviseMQTT(ctx context.Context, cfg *config.Watcher, cl *clock.Clock) {
+	changedCh := make(chan struct{}, 1)
+	cfg.OnChange(func(*config.Config) {
+		select {
+		case changedCh <- struct{}{}:
+		default:
+		}
+	})
+	var broker, prefix string
+	var stop func()
+	for {
+		c := cfg.Current()
+		if c.MQTT.Broker != broker || c.MQTT.Prefix != prefix {
+			if stop != nil {
+				stop()
+				stop = nil
+			}
+			cl.SetStateListener(nil)
+			broker, prefix = c.MQTT.Broker, c.MQTT.Prefix
+			if broker != "" {
+				bctx, cancel := context.WithCancel(ctx)
+				doneCh := make(chan struct{})
+				go func() {
+					defer close(doneCh)
+					if err := runMQTT(bctx, broker, prefix, cl); err != nil && bctx.Err() == nil {
+						log.Printf("mqtt bridge exited: %v", err)
+					}
+				}()
+				stop = func() {
+					cancel()
+					<-doneCh
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			if stop != nil {
+				stop()
+			}
+			return
+		case <-changedCh:
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/chrony"
+	"github.com/jrockway/beaglebone-gps-clock/control/chronymon"
+	"github.com/jrockway/beaglebone-gps-clock/control/clock"
+	"github.com/jrockway/beaglebone-gps-clock/control/config"
+	"github.com/jrockway/beaglebone-gps-clock/control/timedaemon"
+)
+
+// statusTimeout is how long each status check may take.
+const statusTimeout = 5 * time.Second
+
+// statusCheck is the result of checking one thing that the clock depends on.
+type statusCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+	Text   string `json:"text"` // What the LEDs show.
+}
+
+// checkAddresses finds the IPv4 addresses that the clock can be reached at.
+func checkAddresses() statusCheck {
+	result := statusCheck{Name: "ip", Text: "NO IP"}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		result.Detail = fmt.Sprintf("list addresses: %v", err)
+		return result
+	}
+	var ips []string
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() || ipnet.IP.To4() == nil {
+			continue
+		}
+		ips = append(ips, ipnet.IP.String())
+	}
+	if len(ips) == 0 {
+		result.Detail = "no non-loopback IPv4 addresses"
+		return result
+	}
+	result.OK = true
+	result.Detail = strings.Join(ips, ", ")
+	result.Text = "IP " + ips[0]
+	return result
+}
+
+// checkTimeDaemon asks the time daemon what it's synchronized to.
+func checkTimeDaemon(c *config.Config) statusCheck {
+	name := timedaemon.Name(c)
+	label := "CHRONY"
+	if name == "ntpd" {
+		label = "NTPD"
+	}
+	result := statusCheck{Name: name, Text: label + " ERR"}
+	s, err := timedaemon.Poll(c)
+	if err != nil {
+		result.Detail = err.Error()
+		return result
+	}
+	result.OK = true
+	result.Detail = fmt.Sprintf("stratum %d, reference %s, %d sources", s.Tracking.Stratum, chrony.RefidToString(s.Tracking.RefID), len(s.Sources))
+	result.Text = label + " OK"
+	return result
+}
+
+// watchSync keeps the clock's idea of whether the time daemon is synchronized up to date, until
+// the context is cancelled.
+func watchSync(ctx context.Context, m *chronymon.Monitor, cl *clock.Clock) {
+	snapshots, unsubscribe := m.Subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case s := <-snapshots:
+			cl.SetSynced(s.Synchronized())
+		}
+	}
+}
+
+// checkGpsd waits for gpsd to report the satellites it can see, returning a check of gpsd itself
+// and one of the satellites.
+func checkGpsd(ctx context.Context, addr string) (gpsd, sats statusCheck) {
+	gpsd = statusCheck{Name: "gpsd", Text: "GPSD ERR"}
+	sats = statusCheck{Name: "satellites", Text: "NO SATS"}
+	used, visible, err := gpsdSatellites(ctx, addr)
+	if err != nil {
+		gpsd.Detail = err.Error()
+		sats.Detail = "gpsd is unavailable"
+		return gpsd, sats
+	}
+	gpsd.OK = true
+	gpsd.Detail = "connected"
+	gpsd.Text = "GPSD OK"
+	sats.OK = used > 0
+	sats.Detail = fmt.Sprintf("%d used of %d visible", used, visible)
+	sats.Text = fmt.Sprintf("SATS %d/%d", used, visible)
+	return gpsd, sats
+}
+
+// gpsdSatellites returns the number of satellites in gpsd's next SKY report.  It speaks the gpsd
+// protocol directly, rather than using go-gpsd, so that the connection is closed afterwards.
+func gpsdSatellites(ctx context.Context, addr string) (used, visible int, err error) {
+	ctx, cancel := context.WithTimeout(ctx, statusTimeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return 0, 0, fmt.Errorf("set deadline: %w", err)
+	}
+	if _, err := conn.Write([]byte(`?WATCH={"enable":true,"json":true};` + "\n")); err != nil {
+		return 0, 0, fmt.Errorf("start watch: %w", err)
+	}
+	s := bufio.NewScanner(conn)
+	for s.Scan() {
+		var report struct {
+			Class      string `json:"class"`
+			Satellites []struct {
+				Used bool `json:"used"`
+			} `json:"satellites"`
+		}
+		if err := json.Unmarshal(s.Bytes(), &report); err != nil || report.Class != "SKY" || report.Satellites == nil {
+			continue
+		}
+		for _, sat := range report.Satellites {
+			if sat.Used {
+				used++
+			}
+		}
+		return used, len(report.Satellites), nil
+	}
+	if err := s.Err(); err != nil {
+		return 0, 0, fmt.Errorf("wait for sky report: %w", err)
+	}
+	return 0, 0, fmt.Errorf("gpsd closed the connection before sending a sky report")
+}
+
+// statusReporter runs the status checks and shows them on the clock.
+type statusReporter struct {
+	ctx     context.Context // shows stop when it's cancelled.
+	cl      *clock.Clock
+	cfg     *config.Watcher
+	showing chan struct{} // holds a value while the checks are being shown.
+}
+
+func newStatusReporter(ctx context.Context, cl *clock.Clock, cfg *config.Watcher) *statusReporter {
+	return &statusReporter{ctx: ctx, cl: cl, cfg: cfg, showing: make(chan struct{}, 1)}
+}
+
+// check runs each check in turn, calling f with the results as they come in.
+func (r *statusReporter) check(ctx context.Context, f func(statusCheck)) {
+	c := r.cfg.Current()
+	f(checkAddresses())
+	f(checkTimeDaemon(c))
+	gpsd, sats := checkGpsd(ctx, c.Gpsd.Addr)
+	f(gpsd)
+	f(sats)
+}
+
+// show shows each check on the clock as soon as it's done, long enough to read it, and then
+// returns the clock to its face.  It returns false without doing anything if the checks are
+// already being shown.
+func (r *statusReporter) show() bool {
+	ctx := r.ctx
+	select {
+	case r.showing <- struct{}{}:
+	default:
+		return false
+	}
+	go func() {
+		defer func() { <-r.showing }()
+		var next time.Time
+		r.check(ctx, func(c statusCheck) {
+			log.Printf("status: %s: ok=%v: %s", c.Name, c.OK, c.Detail)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(next)):
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case r.cl.MessageCh <- c.Text:
+			}
+			next = time.Now().Add(r.cl.MessageReadTime(c.Text))
+		})
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		select {
+		case <-ctx.Done():
+		case r.cl.MessageCh <- "":
+		}
+	}()
+	return true
+}
+
+// ServeHTTP lets the status checks be run over HTTP; mount it with http.StripPrefix.
+//
+//	GET  /      run the checks and return the results as JSON
+//	POST /show  show the checks on the clock, as at boot
+func (r *statusReporter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "", "/":
+		if req.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var checks []statusCheck
+		r.check(req.Context(), func(c statusCheck) { checks = append(checks, c) })
+		w.Header().Set("content-type", "application/json")
+		if err := json.NewEncoder(w).Encode(checks); err != nil {
+			log.Printf("status: encode response: %v", err)
+		}
+	case "/show":
+		if req.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !r.show() {
+			http.Error(w, "status is already being shown", http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.NotFound(w, req)
+	}
+}
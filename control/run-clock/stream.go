@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+
+	"github.com/jrockway/beaglebone-gps-clock/control/config"
+	"github.com/jrockway/beaglebone-gps-clock/control/pixelstream"
+	"github.com/jrockway/beaglebone-gps-clock/control/screen"
+)
+
+// serveStreams listens for pixel streams on the addresses in the config, until the context is
+// cancelled.  Listeners that fail are logged and left off.
+func serveStreams(ctx context.Context, c *config.Config, leds *screen.Screen) {
+	s := pixelstream.New(leds)
+	if addr := c.Stream.DDP; addr != "" {
+		if conn, err := net.ListenPacket("udp", addr); err != nil {
+			log.Printf("listen for ddp: %v", err)
+		} else {
+			log.Printf("listening for ddp on %s", conn.LocalAddr())
+			go func() { log.Print(s.ServeDDP(ctx, conn)) }()
+		}
+	}
+	if addr := c.Stream.E131; addr != "" {
+		if conn, err := net.ListenPacket("udp", addr); err != nil {
+			log.Printf("listen for e1.31: %v", err)
+		} else {
+			log.Printf("listening for e1.31 universes from %d on %s", c.Stream.E131Universe, conn.LocalAddr())
+			go func() { log.Print(s.ServeE131(ctx, conn, c.Stream.E131Universe)) }()
+		}
+	}
+	if addr := c.Stream.OPC; addr != "" {
+		if l, err := net.Listen("tcp", addr); err != nil {
+			log.Printf("listen for opc: %v", err)
+		} else {
+			log.Printf("listening for opc on %s", l.Addr())
+			go func() { log.Print(s.ServeOPC(ctx, l)) }()
+		}
+	}
+}
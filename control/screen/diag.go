@@ -0,0 +1,301 @@
+package screen
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Pattern is a diagnostic test pattern; an animation of Frames frames, each shown for Period.
+type Pattern struct {
+	Name   string
+	Frames int
+	Period time.Duration
+	Draw   func(img *image.NRGBA64, frame int)
+}
+
+// Patterns are the diagnostic test patterns, in the order that they're shown.
+var Patterns = []Pattern{
+	{Name: "strand", Frames: rows * cols * panels, Period: 50 * time.Millisecond, Draw: drawStrand},
+	{Name: "panels", Frames: 1, Period: 5 * time.Second, Draw: drawPanels},
+	{Name: "sweep", Frames: 100, Period: 100 * time.Millisecond, Draw: drawSweep},
+	{Name: "gamma", Frames: 1, Period: 5 * time.Second, Draw: drawGamma},
+}
+
+// coordsOf is the inverse of indexOf; it returns the (x,y) coordinate of a strand index.
+func coordsOf(i int) (x, y int) {
+	panel, pix := i/(rows*cols), i%(rows*cols)
+	if panel%2 == 1 {
+		pix = rows*cols - 1 - pix
+	}
+	return panel*cols + pix/rows, pix % rows
+}
+
+var (
+	diagWhite = color.NRGBA64{R: 0xffff, G: 0xffff, B: 0xffff, A: 0xffff}
+	diagRed   = color.NRGBA64{R: 0xffff, A: 0xffff}
+	diagGreen = color.NRGBA64{G: 0xffff, A: 0xffff}
+	diagBlue  = color.NRGBA64{B: 0xffff, A: 0xffff}
+	diagDim   = color.NRGBA64{B: 0x2000, A: 0xffff}
+)
+
+// drawStrand lights the LEDs in strand order, one per frame, leaving the ones already visited dim.
+// A break in the wiring shows up as the lit LED disappearing; a panel in the wrong orientation
+// shows up as the trail moving in the wrong direction.
+func drawStrand(img *image.NRGBA64, frame int) {
+	for i := 0; i < frame; i++ {
+		x, y := coordsOf(i)
+		img.SetNRGBA64(x, y, diagDim)
+	}
+	x, y := coordsOf(frame)
+	img.SetNRGBA64(x, y, diagWhite)
+}
+
+// digits3x5 are the digits 0-9 in a 3x5 font; each row is 3 bits, most significant on the left.
+var digits3x5 = [10][5]uint8{
+	{7, 5, 5, 5, 7}, {2, 6, 2, 2, 7}, {7, 1, 7, 4, 7}, {7, 1, 3, 1, 7}, {5, 5, 7, 1, 1},
+	{7, 4, 7, 1, 7}, {7, 4, 7, 5, 7}, {7, 1, 1, 1, 1}, {7, 5, 7, 5, 7}, {7, 5, 7, 1, 7},
+}
+
+// drawPanels draws each panel's number in the middle of the panel, with the panel's first strand
+// LED in red and its last in green.
+func drawPanels(img *image.NRGBA64, frame int) {
+	for panel := 0; panel < panels; panel++ {
+		digit := digits3x5[panel%10]
+		x0, y0 := panel*cols+(cols-3)/2, (rows-5)/2
+		for y, bits := range digit {
+			for x := 0; x < 3; x++ {
+				if bits&(4>>uint(x)) != 0 {
+					img.SetNRGBA64(x0+x, y0+y, diagWhite)
+				}
+			}
+		}
+		x, y := coordsOf(panel * rows * cols)
+		img.SetNRGBA64(x, y, diagRed)
+		x, y = coordsOf((panel+1)*rows*cols - 1)
+		img.SetNRGBA64(x, y, diagGreen)
+	}
+}
+
+// drawSweep fills the display with red, green, blue and white for a second each, then sweeps
+// through the hues.
+func drawSweep(img *image.NRGBA64, frame int) {
+	var c color.NRGBA64
+	if frame < 40 {
+		c = []color.NRGBA64{diagRed, diagGreen, diagBlue, diagWhite}[frame/10]
+	} else {
+		c = hue(float64(frame-40) / 60)
+	}
+	fill(img, c)
+}
+
+// hue returns a fully-saturated color; h is in [0, 1).
+func hue(h float64) color.NRGBA64 {
+	h6 := h * 6
+	sector := int(h6)
+	f := uint16((h6 - float64(sector)) * 0xffff)
+	switch sector % 6 {
+	case 0:
+		return color.NRGBA64{R: 0xffff, G: f, A: 0xffff}
+	case 1:
+		return color.NRGBA64{R: 0xffff - f, G: 0xffff, A: 0xffff}
+	case 2:
+		return color.NRGBA64{G: 0xffff, B: f, A: 0xffff}
+	case 3:
+		return color.NRGBA64{G: 0xffff - f, B: 0xffff, A: 0xffff}
+	case 4:
+		return color.NRGBA64{R: f, B: 0xffff, A: 0xffff}
+	}
+	return color.NRGBA64{R: 0xffff, B: 0xffff - f, A: 0xffff}
+}
+
+// drawGamma draws brightness ramps from left to right; two rows each of white, red, green and blue.
+// The panels from the two batches should look the same at every level.
+func drawGamma(img *image.NRGBA64, frame int) {
+	for x := 0; x < cols*panels; x++ {
+		level := uint16((x + 1) * 0xffff / (cols * panels))
+		for y := 0; y < rows; y++ {
+			c := color.NRGBA64{A: 0xffff}
+			switch y / 2 {
+			case 0:
+				c.R, c.G, c.B = level, level, level
+			case 1:
+				c.R = level
+			case 2:
+				c.G = level
+			case 3:
+				c.B = level
+			}
+			img.SetNRGBA64(x, y, c)
+		}
+	}
+}
+
+func fill(img *image.NRGBA64, c color.NRGBA64) {
+	b := img.Bounds()
+	for x := b.Min.X; x < b.Max.X; x++ {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			img.SetNRGBA64(x, y, c)
+		}
+	}
+}
+
+// DiagnosticsStatus describes the running diagnostics.
+type DiagnosticsStatus struct {
+	Running bool   `json:"running"`
+	Pattern string `json:"pattern,omitempty"`
+	Frame   int    `json:"frame,omitempty"`
+}
+
+// StartDiagnostics cycles through the named patterns (all of them, if names is empty) until
+// StopDiagnostics is called.  While diagnostics are running, images sent to Display are dropped, so
+// that whatever normally draws to the screen can keep running.
+func (s *Screen) StartDiagnostics(names []string) error {
+	var patterns []Pattern
+	for _, name := range names {
+		var found bool
+		for _, p := range Patterns {
+			if p.Name == name {
+				patterns = append(patterns, p)
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown pattern %q", name)
+		}
+	}
+	if len(patterns) == 0 {
+		patterns = Patterns
+	}
+
+	s.diagCtlMu.Lock()
+	defer s.diagCtlMu.Unlock()
+	s.stopDiagnostics()
+	ctx, cancel := context.WithCancel(context.Background())
+	doneCh := make(chan struct{})
+	s.diagMu.Lock()
+	s.stopDiag = func() {
+		cancel()
+		<-doneCh
+	}
+	s.diagMu.Unlock()
+	go func() {
+		defer close(doneCh)
+		s.runDiagnostics(ctx, patterns)
+	}()
+	return nil
+}
+
+// StopDiagnostics stops the diagnostics, if they're running.  The screen is blanked until the next
+// call to Display.
+func (s *Screen) StopDiagnostics() {
+	s.diagCtlMu.Lock()
+	defer s.diagCtlMu.Unlock()
+	s.stopDiagnostics()
+}
+
+// stopDiagnostics stops the diagnostics; must hold diagCtlMu.
+func (s *Screen) stopDiagnostics() {
+	s.diagMu.Lock()
+	stop := s.stopDiag
+	s.stopDiag = nil
+	s.diagMu.Unlock()
+	if stop != nil {
+		stop()
+	}
+}
+
+// DiagnosticsStatus returns what the diagnostics are showing.
+func (s *Screen) DiagnosticsStatus() DiagnosticsStatus {
+	s.diagMu.Lock()
+	defer s.diagMu.Unlock()
+	status := s.diagStatus
+	status.Running = s.stopDiag != nil
+	return status
+}
+
+func (s *Screen) runDiagnostics(ctx context.Context, patterns []Pattern) {
+	defer func() {
+		s.diagMu.Lock()
+		s.diagStatus = DiagnosticsStatus{}
+		s.diagMu.Unlock()
+		if err := s.display(image.Black); err != nil {
+			log.Printf("diagnostics: %v", err)
+		}
+	}()
+	for {
+		for _, p := range patterns {
+			for frame := 0; frame < p.Frames; frame++ {
+				s.diagMu.Lock()
+				s.diagStatus = DiagnosticsStatus{Pattern: p.Name, Frame: frame}
+				s.diagMu.Unlock()
+				img := s.EmptyCanvas()
+				p.Draw(img, frame)
+				if err := s.display(img); err != nil {
+					log.Printf("diagnostics: %v", err)
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(p.Period):
+				}
+			}
+		}
+	}
+}
+
+// diagnosticsRunning returns true if diagnostics own the display.
+func (s *Screen) diagnosticsRunning() bool {
+	s.diagMu.Lock()
+	defer s.diagMu.Unlock()
+	return s.stopDiag != nil
+}
+
+// DiagnosticsHandler returns an HTTP handler that controls the diagnostics, meant to be mounted
+// with http.StripPrefix.
+//
+//	GET  /        the diagnostics status, as JSON
+//	POST /start   start cycling patterns; optional form value pattern=strand,panels,sweep,gamma
+//	POST /stop    go back to the normal display
+func (s *Screen) DiagnosticsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "", "/":
+			if req.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+		case "/start", "/stop":
+			if req.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if req.URL.Path == "/stop" {
+				s.StopDiagnostics()
+				break
+			}
+			var names []string
+			if p := req.FormValue("pattern"); p != "" {
+				names = strings.Split(p, ",")
+			}
+			if err := s.StartDiagnostics(names); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(s.DiagnosticsStatus()); err != nil {
+			log.Printf("encoding diagnostics status: %v", err)
+		}
+	})
+}
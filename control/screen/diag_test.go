@@ -0,0 +1,92 @@
+package screen
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCoordsOf(t *testing.T) {
+	seen := map[[2]int]bool{}
+	for i := 0; i < rows*cols*panels; i++ {
+		x, y := coordsOf(i)
+		if got := indexOf(x, y); got != i {
+			t.Errorf("indexOf(coordsOf(%d)) = indexOf(%d, %d):\n  got: %v\n want: %v", i, x, y, got, i)
+		}
+		seen[[2]int{x, y}] = true
+	}
+	if got, want := len(seen), rows*cols*panels; got != want {
+		t.Errorf("distinct coordinates:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func TestPatterns(t *testing.T) {
+	s, err := NewScreen(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range Patterns {
+		for frame := 0; frame < p.Frames; frame++ {
+			img := s.EmptyCanvas()
+			p.Draw(img, frame)
+			var lit bool
+			for i := 0; i < len(img.Pix); i += 8 {
+				if img.Pix[i] != 0 || img.Pix[i+2] != 0 || img.Pix[i+4] != 0 {
+					lit = true
+					break
+				}
+			}
+			if !lit {
+				t.Errorf("pattern %s frame %d: nothing lit", p.Name, frame)
+			}
+		}
+	}
+}
+
+func TestDiagnosticsHandler(t *testing.T) {
+	s, err := NewScreen(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := http.StripPrefix("/diagnostics", s.DiagnosticsHandler())
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	if rec := do("POST", "/diagnostics/start?pattern=nope"); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown pattern: got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	rec := do("POST", "/diagnostics/start?pattern=panels")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"running":true`) {
+		t.Fatalf("start: status %d: %s", rec.Code, rec.Body)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for s.DiagnosticsStatus().Pattern != "panels" {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the pattern to be drawn")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Other callers can't draw over the test pattern.
+	if err := s.Display(s.EmptyCanvas()); err != nil {
+		t.Fatal(err)
+	}
+	// The top-left pixel of panel 0's "0" is at (2, 1).
+	scale := previewScale + previewPixelBorder
+	s.imageMu.Lock()
+	r, _, _, _ := s.image.At(2*scale, 1*scale).RGBA()
+	s.imageMu.Unlock()
+	if r == 0 {
+		t.Error("Display drew over the test pattern")
+	}
+	if rec := do("POST", "/diagnostics/stop"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"running":false`) {
+		t.Errorf("stop: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do("GET", "/diagnostics/stop"); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET stop: got status %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
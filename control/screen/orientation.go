@@ -0,0 +1,70 @@
+package screen
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// Orientation is how the canvas is transformed before it's drawn on the LEDs, so that the display
+// can be mounted in other ways.
+type Orientation string
+
+const (
+	// Normal draws the canvas as it is.
+	Normal Orientation = ""
+	// Rotate180 turns the canvas upside down, for a display mounted upside down.
+	Rotate180 Orientation = "rotate-180"
+	// MirrorHorizontal swaps left and right, for viewing the display in a mirror.
+	MirrorHorizontal Orientation = "mirror-horizontal"
+	// MirrorVertical swaps top and bottom, for viewing the display reflected in a table top.
+	MirrorVertical Orientation = "mirror-vertical"
+)
+
+// Orientations are all the supported orientations.
+var Orientations = []Orientation{Normal, Rotate180, MirrorHorizontal, MirrorVertical}
+
+// SetOrientation changes the orientation, starting with the next frame.
+func (s *Screen) SetOrientation(o Orientation) error {
+	for _, valid := range Orientations {
+		if o == valid {
+			s.orientationMu.Lock()
+			defer s.orientationMu.Unlock()
+			s.orientation = o
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown orientation %q", o)
+}
+
+// Orientation returns the current orientation.
+func (s *Screen) Orientation() Orientation {
+	s.orientationMu.Lock()
+	defer s.orientationMu.Unlock()
+	return s.orientation
+}
+
+// source returns the canvas coordinate that's drawn at display coordinate (x, y).
+func (o Orientation) source(x, y int) (int, int) {
+	w, h := panels*cols, rows
+	switch o {
+	case Rotate180:
+		return w - 1 - x, h - 1 - y
+	case MirrorHorizontal:
+		return w - 1 - x, y
+	case MirrorVertical:
+		return x, h - 1 - y
+	}
+	return x, y
+}
+
+// oriented is an image transformed by an Orientation.  Everything that draws the image, including
+// the preview, sees the transformed image.
+type oriented struct {
+	image.Image
+	o Orientation
+}
+
+func (i *oriented) At(x, y int) color.Color {
+	return i.Image.At(i.o.source(x, y))
+}
@@ -0,0 +1,53 @@
+package screen
+
+import (
+	"bytes"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestOrientation(t *testing.T) {
+	red := color.NRGBA64{R: 0xffff, A: 0xffff}
+	testData := []struct {
+		orientation Orientation
+		wantX       int
+		wantY       int
+	}{
+		{Normal, 0, 0},
+		{Rotate180, panels*cols - 1, rows - 1},
+		{MirrorHorizontal, panels*cols - 1, 0},
+		{MirrorVertical, 0, rows - 1},
+	}
+	for _, test := range testData {
+		t.Run(string(test.orientation), func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			s := NewTerminal(buf)
+			if err := s.SetOrientation(test.orientation); err != nil {
+				t.Fatalf("set orientation: %v", err)
+			}
+			img := s.EmptyCanvas()
+			img.SetNRGBA64(0, 0, red)
+			if err := s.Display(img); err != nil {
+				t.Fatalf("display: %v", err)
+			}
+
+			// The preview shows the transformed image.
+			for x := 0; x < panels*cols; x++ {
+				for y := 0; y < rows; y++ {
+					r, _, _, _ := s.image.At(x*(previewScale+previewPixelBorder)+(x/cols)*previewPanelSpacing, y*(previewScale+previewPixelBorder)).RGBA()
+					if got, want := r != 0, x == test.wantX && y == test.wantY; got != want {
+						t.Errorf("preview pixel (%d, %d) lit:\n  got: %v\n want: %v", x, y, got, want)
+					}
+				}
+			}
+			// So does the terminal.
+			if got, want := strings.Count(buf.String(), "255;0;0m"), 1; got != want {
+				t.Errorf("red pixels in terminal:\n  got: %v\n want: %v", got, want)
+			}
+		})
+	}
+	if err := NewTerminal(new(bytes.Buffer)).SetOrientation("sideways"); err == nil {
+		t.Error("unknown orientation: expected error")
+	}
+}
@@ -7,10 +7,12 @@ import (
 	"image"
 	"image/color"
 	"image/png"
+	"io"
 	"log"
 	"math"
 	"net/http"
 	"sync"
+	"time"
 
 	"periph.io/x/periph/conn/spi"
 	"periph.io/x/periph/devices/apa102"
@@ -51,8 +53,23 @@ const (
 type Screen struct {
 	leds *apa102.Dev
 
+	displayMu sync.Mutex // serializes writes to the LEDs.
+	term      io.Writer  // if not nil, frames are drawn here instead; see NewTerminal.
+	termDrawn bool       // whether a frame has been drawn to term; must hold displayMu.
+
 	imageMu sync.Mutex
 	image   *image.NRGBA64 // must hold imageMu to read or write.
+
+	diagCtlMu  sync.Mutex        // serializes starting and stopping diagnostics.
+	diagMu     sync.Mutex        // protects the fields below.
+	stopDiag   func()            // stops the running diagnostics; nil if not running.
+	diagStatus DiagnosticsStatus // what the diagnostics are showing.
+
+	orientationMu sync.Mutex
+	orientation   Orientation // must hold orientationMu.
+
+	streamMu    sync.Mutex
+	streamUntil time.Time // Display is ignored until then; see Stream.  Must hold streamMu.
 }
 
 // NewScreen returns an initialized Screen object.
@@ -221,9 +238,48 @@ func toMatrix(img image.Image) []color.NRGBA {
 	return result
 }
 
-// Display displays the provided image on the screen.
+// Display displays the provided image on the screen, unless diagnostics are running or another
+// program is streaming to the display.
 func (s *Screen) Display(img image.Image) error {
+	if s.diagnosticsRunning() || s.streaming() {
+		return nil
+	}
+	return s.display(img)
+}
+
+// StreamTimeout is how long after the last frame from Stream that Display works again.
+const StreamTimeout = 2 * time.Second
+
+// Stream displays a frame that's part of a stream from another program.  Calls to Display are
+// ignored until the stream has stopped for StreamTimeout, so that the stream doesn't flicker with
+// the clock.  Diagnostics take priority over streams.
+func (s *Screen) Stream(img image.Image) error {
+	if s.diagnosticsRunning() {
+		return nil
+	}
+	s.streamMu.Lock()
+	s.streamUntil = time.Now().Add(StreamTimeout)
+	s.streamMu.Unlock()
+	return s.display(img)
+}
+
+// streaming returns true if a stream owns the display.
+func (s *Screen) streaming() bool {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+	return time.Now().Before(s.streamUntil)
+}
+
+func (s *Screen) display(img image.Image) error {
+	s.displayMu.Lock()
+	defer s.displayMu.Unlock()
+	if o := s.Orientation(); o != Normal {
+		img = &oriented{Image: img, o: o}
+	}
 	s.updateCurrentImage(img)
+	if s.term != nil {
+		return s.drawTerminal(img)
+	}
 	if s.leds == nil {
 		return nil
 	}
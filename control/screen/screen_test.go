@@ -0,0 +1,51 @@
+package screen
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	buf := new(bytes.Buffer)
+	s := NewTerminal(buf)
+	if err := s.Stream(s.EmptyCanvas()); err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	if buf.Len() == 0 {
+		t.Fatal("stream frame wasn't displayed")
+	}
+	buf.Reset()
+	if err := s.Display(s.EmptyCanvas()); err != nil {
+		t.Fatalf("display: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Error("display frame was drawn while streaming")
+	}
+
+	// Pretend that StreamTimeout has passed.
+	s.streamMu.Lock()
+	s.streamUntil = time.Now()
+	s.streamMu.Unlock()
+	if err := s.Display(s.EmptyCanvas()); err != nil {
+		t.Fatalf("display: %v", err)
+	}
+	if buf.Len() == 0 {
+		t.Error("display frame wasn't drawn after the stream stopped")
+	}
+}
+
+func TestPowerLimit(t *testing.T) {
+	img := (&Screen{}).EmptyCanvas()
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	var power float64
+	for i, c := range toMatrix(img) {
+		x, y := coordsOf(i)
+		power += powerFor(x, y, c)
+	}
+	if power > powerLimit {
+		t.Errorf("all pixels at full brightness use %vW; limit is %vW", power, powerLimit)
+	}
+}
@@ -0,0 +1,51 @@
+package screen
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+)
+
+// NewTerminal returns a Screen that draws to a terminal instead of the LEDs, so that faces can be
+// worked on without the hardware.  Each frame is written to w as 4 lines of ANSI 24-bit color
+// "▀" characters, two pixels per character, with a column between panels like the gap between
+// the real ones.  Every frame after the first moves the cursor back up to overwrite the last one.
+func NewTerminal(w io.Writer) *Screen {
+	s, _ := NewScreen(nil) // Can't fail without a port.
+	s.term = w
+	return s
+}
+
+// terminalLines is how many lines a frame takes up in the terminal.
+const terminalLines = rows / 2
+
+// renderTerminal returns the escape sequences that draw img in a terminal.
+func renderTerminal(img image.Image) []byte {
+	var b bytes.Buffer
+	for y := 0; y < rows; y += 2 {
+		for x := 0; x < cols*panels; x++ {
+			if x > 0 && x%cols == 0 {
+				b.WriteString("\x1b[0m ")
+			}
+			tr, tg, tb, _ := img.At(x, y).RGBA()
+			br, bg, bb, _ := img.At(x, y+1).RGBA()
+			fmt.Fprintf(&b, "\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm▀", tr>>8, tg>>8, tb>>8, br>>8, bg>>8, bb>>8)
+		}
+		b.WriteString("\x1b[0m\n")
+	}
+	return b.Bytes()
+}
+
+// drawTerminal draws img over the previous frame.  It must be called with displayMu held.
+func (s *Screen) drawTerminal(img image.Image) error {
+	frame := renderTerminal(img)
+	if s.termDrawn {
+		frame = append([]byte(fmt.Sprintf("\x1b[%dF", terminalLines)), frame...)
+	}
+	if _, err := s.term.Write(frame); err != nil {
+		return fmt.Errorf("write to terminal: %w", err)
+	}
+	s.termDrawn = true
+	return nil
+}
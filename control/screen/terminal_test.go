@@ -0,0 +1,40 @@
+package screen
+
+import (
+	"bytes"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestTerminal(t *testing.T) {
+	buf := new(bytes.Buffer)
+	s := NewTerminal(buf)
+	img := s.EmptyCanvas()
+	img.SetNRGBA64(0, 0, color.NRGBA64{R: 0xffff, A: 0xffff})
+	img.SetNRGBA64(0, 1, color.NRGBA64{B: 0xffff, A: 0xffff})
+	if err := s.Display(img); err != nil {
+		t.Fatalf("display: %v", err)
+	}
+	first := buf.String()
+	if got, want := strings.Count(first, "\n"), terminalLines; got != want {
+		t.Errorf("lines:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := strings.Count(first, "▀"), rows*cols*panels/2; got != want {
+		t.Errorf("characters:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := strings.Count(first, "\x1b[0m "), (panels-1)*terminalLines; got != want {
+		t.Errorf("panel gaps:\n  got: %v\n want: %v", got, want)
+	}
+	if want := "\x1b[38;2;255;0;0m\x1b[48;2;0;0;255m▀"; !strings.HasPrefix(first, want) {
+		t.Errorf("first character:\n  got: %q\n want: %q...", first[:len(want)], want)
+	}
+
+	buf.Reset()
+	if err := s.Display(img); err != nil {
+		t.Fatalf("display: %v", err)
+	}
+	if got, want := buf.String(), "\x1b[4F"+first; got != want {
+		t.Errorf("second frame doesn't overwrite the first:\n  got: %q...\n want: %q...", got[:20], want[:20])
+	}
+}
@@ -0,0 +1,104 @@
+// Package sdnotify implements the systemd service notification protocol (sd_notify(3)), so that
+// systemd knows when the clock is ready, when it's stopping on purpose, and whether it's still
+// making progress.
+package sdnotify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// States that can be sent with Notify.
+const (
+	Ready     = "READY=1"
+	Stopping  = "STOPPING=1"
+	Reloading = "RELOADING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+// Notifier sends notifications to systemd.  The zero value discards notifications, which is what
+// happens when the program isn't started by systemd.
+type Notifier struct {
+	// Socket is the path of the notification socket, from $NOTIFY_SOCKET.  A leading '@' means
+	// an abstract socket.
+	Socket string
+	// WatchdogInterval is how often systemd expects to hear from us, from $WATCHDOG_USEC.  0 if
+	// the watchdog is disabled.
+	WatchdogInterval time.Duration
+}
+
+// FromEnv returns a Notifier configured from the environment that systemd provides.
+func FromEnv() (*Notifier, error) {
+	n := &Notifier{Socket: os.Getenv("NOTIFY_SOCKET")}
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return n, nil
+	}
+	// WATCHDOG_PID is set when the watchdog is meant for a different process, like a child of a
+	// shell script.
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return n, nil
+	}
+	us, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || us <= 0 {
+		return n, fmt.Errorf("invalid $WATCHDOG_USEC %q", usec)
+	}
+	n.WatchdogInterval = time.Duration(us) * time.Microsecond
+	return n, nil
+}
+
+// Enabled returns true if notifications will be sent anywhere.
+func (n *Notifier) Enabled() bool {
+	return n != nil && n.Socket != ""
+}
+
+// Notify sends one or more newline-separated state assignments to systemd, like "READY=1" or
+// "STATUS=waiting for gpsd".  It does nothing if there is no notification socket.
+func (n *Notifier) Notify(state string) error {
+	if !n.Enabled() {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: n.Socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("dial notify socket: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("write to notify socket: %w", err)
+	}
+	return nil
+}
+
+// Status sends a free-form status line, shown by "systemctl status".
+func (n *Notifier) Status(status string) error {
+	return n.Notify("STATUS=" + status)
+}
+
+// RunWatchdog pets the watchdog at half the interval that systemd asked for, but only while
+// healthy returns true; if the program stops doing its job, systemd will notice and restart it.
+// It returns when the context is cancelled, or immediately if the watchdog is disabled.
+func (n *Notifier) RunWatchdog(ctx context.Context, healthy func() bool) error {
+	if !n.Enabled() || n.WatchdogInterval == 0 {
+		return nil
+	}
+	t := time.NewTicker(n.WatchdogInterval / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("watchdog: %w", ctx.Err())
+		case <-t.C:
+			if !healthy() {
+				continue
+			}
+			if err := n.Notify(Watchdog); err != nil {
+				log.Printf("pet watchdog: %v", err)
+			}
+		}
+	}
+}
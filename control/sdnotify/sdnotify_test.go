@@ -0,0 +1,136 @@
+package sdnotify
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeSystemd listens on a notification socket and collects what is sent to it.
+func fakeSystemd(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	ch := make(chan string, 100)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			ch <- string(buf[:n])
+		}
+	}()
+	return path, ch
+}
+
+func receive(t *testing.T, ch <-chan string) string {
+	t.Helper()
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for notification")
+	}
+	return ""
+}
+
+func TestNotify(t *testing.T) {
+	path, ch := fakeSystemd(t)
+	n := &Notifier{Socket: path}
+	if err := n.Notify(Ready); err != nil {
+		t.Fatalf("notify ready: %v", err)
+	}
+	if got, want := receive(t, ch), "READY=1"; got != want {
+		t.Errorf("ready:\n  got: %v\n want: %v", got, want)
+	}
+	if err := n.Status("waiting for chronyd"); err != nil {
+		t.Fatalf("notify status: %v", err)
+	}
+	if got, want := receive(t, ch), "STATUS=waiting for chronyd"; got != want {
+		t.Errorf("status:\n  got: %v\n want: %v", got, want)
+	}
+
+	// Without a socket, notifications are silently dropped.
+	if err := new(Notifier).Notify(Ready); err != nil {
+		t.Errorf("notify without socket: %v", err)
+	}
+	var nilNotifier *Notifier
+	if err := nilNotifier.Notify(Stopping); err != nil {
+		t.Errorf("notify with nil notifier: %v", err)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	testData := []struct {
+		name         string
+		usec, pid    string
+		wantInterval time.Duration
+		wantErr      bool
+	}{
+		{name: "no watchdog"},
+		{name: "watchdog", usec: "10000000", wantInterval: 10 * time.Second},
+		{name: "our pid", usec: "2000000", pid: strconv.Itoa(os.Getpid()), wantInterval: 2 * time.Second},
+		{name: "other pid", usec: "2000000", pid: "1"},
+		{name: "garbage", usec: "soon", wantErr: true},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("NOTIFY_SOCKET", "/run/systemd/notify")
+			t.Setenv("WATCHDOG_USEC", test.usec)
+			t.Setenv("WATCHDOG_PID", test.pid)
+			n, err := FromEnv()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got, want := n.Socket, "/run/systemd/notify"; got != want {
+				t.Errorf("socket:\n  got: %v\n want: %v", got, want)
+			}
+			if got, want := n.WatchdogInterval, test.wantInterval; got != want {
+				t.Errorf("watchdog interval:\n  got: %v\n want: %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRunWatchdog(t *testing.T) {
+	path, ch := fakeSystemd(t)
+	n := &Notifier{Socket: path, WatchdogInterval: 20 * time.Millisecond}
+	var healthy int32 = 1
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.RunWatchdog(ctx, func() bool { return atomic.LoadInt32(&healthy) == 1 }) // nolint:errcheck
+
+	for i := 0; i < 3; i++ {
+		if got, want := receive(t, ch), "WATCHDOG=1"; got != want {
+			t.Errorf("pet %d:\n  got: %v\n want: %v", i, got, want)
+		}
+	}
+
+	// Once the program stops making progress, the pets stop.
+	atomic.StoreInt32(&healthy, 0)
+	time.Sleep(50 * time.Millisecond)
+	for len(ch) > 0 {
+		<-ch
+	}
+	select {
+	case msg := <-ch:
+		t.Errorf("unexpected notification while unhealthy: %v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+	if got, want := receive(t, ch), "WATCHDOG=1"; got != want {
+		t.Errorf("pet after recovery:\n  got: %v\n want: %v", got, want)
+	}
+}
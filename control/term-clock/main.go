@@ -0,0 +1,88 @@
+// Command term-clock runs the LED matrix clock in a terminal, for working on faces without the
+// hardware.  The terminal needs to support 24-bit color.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jrockway/beaglebone-gps-clock/control/clock"
+	"github.com/jrockway/beaglebone-gps-clock/control/screen"
+)
+
+var (
+	face       = flag.String("face", clock.DefaultFace, "face to show")
+	location   = flag.String("location", "Local", "time zone to show the time in")
+	brightness = flag.Uint("brightness", 0xffff, "brightness, from 0 to 65535")
+	transition = flag.String("transition", "", `animation between seconds and faces: "", "fade" or "slide"`)
+	message    = flag.String("message", "", "message to show for the first 10 seconds")
+	theme      = flag.String("theme", clock.DefaultTheme, "theme of the built-in faces: "+strings.Join(clock.ThemeNames(), ", "))
+	faces      = flag.String("faces", "", "face file with more faces to choose from")
+	synced     = flag.Bool("synced", true, "whether face file faces should draw as if the time is synchronized")
+	orient     = flag.String("orientation", "", `transform the display: "", "rotate-180", "mirror-horizontal" or "mirror-vertical"`)
+)
+
+const (
+	hideCursor = "\x1b[?25l"
+	showCursor = "\x1b[?25h"
+)
+
+func main() {
+	flag.Parse()
+	if *brightness > 0xffff {
+		log.Fatalf("brightness %d is more than 65535", *brightness)
+	}
+	loc, err := time.LoadLocation(*location)
+	if err != nil {
+		log.Fatalf("load location: %v", err)
+	}
+
+	display := screen.NewTerminal(os.Stdout)
+	if err := display.SetOrientation(screen.Orientation(*orient)); err != nil {
+		log.Fatalf("%v", err)
+	}
+	cl := clock.New(display)
+	if *faces != "" {
+		ff, err := clock.LoadFaceFile(*faces)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		cl.SetFaceFile(ff)
+	}
+	cl.SetSynced(*synced)
+	if err := cl.SetTheme(*theme); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if !cl.HasFace(*face) {
+		log.Fatalf("unknown face %q", *face)
+	}
+	cl.SetLocation(loc)
+	tr := clock.DefaultTransitions
+	tr.Style = clock.TransitionStyle(*transition)
+	if err := cl.SetTransitions(tr); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	fmt.Print(hideCursor)
+	defer fmt.Print(showCursor)
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- cl.Run(ctx) }()
+	cl.FaceCh <- *face
+	cl.BrightnessCh <- uint16(*brightness)
+	if *message != "" {
+		cl.MessageCh <- *message
+	}
+	if err := <-errCh; ctx.Err() == nil {
+		log.Printf("clock loop died: %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+// Package timedaemon monitors the time daemon that the configuration's time_daemon names, chronyd
+// or ntpd, so that every program asks the same one whether the clock is synchronized.
+package timedaemon
+
+import (
+	"time"
+
+	"github.com/jrockway/beaglebone-gps-clock/control/chronymon"
+	"github.com/jrockway/beaglebone-gps-clock/control/config"
+	"github.com/jrockway/beaglebone-gps-clock/control/ntpdmon"
+)
+
+// Name returns the name of the daemon that c says to monitor, like "chronyd".
+func Name(c *config.Config) string {
+	if c.TimeDaemon == "ntpd" {
+		return "ntpd"
+	}
+	return "chronyd"
+}
+
+// Poll takes one snapshot from the daemon that c says to monitor.
+func Poll(c *config.Config) (*chronymon.Snapshot, error) {
+	if c.TimeDaemon == "ntpd" {
+		return ntpdmon.Poll(c.Ntpd.Addr, chronymon.DefaultTimeout)
+	}
+	return chronymon.Poll(c.Chrony.Addr, "")
+}
+
+// NewMonitor returns a Monitor for the daemon that cfg's current configuration says to monitor;
+// switching daemons takes a restart, but address changes take effect at the next poll.  chronyd's
+// Unix socket is only used if useSocket is set, since it needs privileges that not every program
+// has.
+func NewMonitor(cfg *config.Watcher, useSocket bool) *chronymon.Monitor {
+	if cfg.Current().TimeDaemon == "ntpd" {
+		return chronymon.NewPoller(func(timeout time.Duration) (*chronymon.Snapshot, error) {
+			return ntpdmon.Poll(cfg.Current().Ntpd.Addr, timeout)
+		})
+	}
+	var socket func() string
+	if useSocket {
+		socket = func() string { return cfg.Current().Chrony.Socket }
+	}
+	return chronymon.New(func() string { return cfg.Current().Chrony.Addr }, socket)
+}
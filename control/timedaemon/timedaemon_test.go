@@ -0,0 +1,69 @@
+package timedaemon
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/chrony"
+	"github.com/jrockway/beaglebone-gps-clock/control/chronymon/chronytest"
+	"github.com/jrockway/beaglebone-gps-clock/control/config"
+	"github.com/jrockway/beaglebone-gps-clock/control/ntpdmon/ntpdtest"
+)
+
+func TestMonitor(t *testing.T) {
+	chronyd, err := chronytest.NewServer()
+	if err != nil {
+		t.Fatalf("start fake chronyd: %v", err)
+	}
+	t.Cleanup(func() { chronyd.Close() })
+	chronyd.SetTracking(chrony.Tracking{Stratum: 2, RefID: 0x50505300})
+	ntpd, err := ntpdtest.NewServer()
+	if err != nil {
+		t.Fatalf("start fake ntpd: %v", err)
+	}
+	t.Cleanup(func() { ntpd.Close() })
+	ntpd.SetSystem(0, "leap=0, stratum=1, refid=GPS")
+
+	testData := []struct {
+		daemon, name string
+		wantStratum  uint16
+	}{
+		{daemon: "chrony", name: "chronyd", wantStratum: 2},
+		{daemon: "ntpd", name: "ntpd", wantStratum: 1},
+	}
+	for _, test := range testData {
+		t.Run(test.daemon, func(t *testing.T) {
+			c := config.Default()
+			c.TimeDaemon = test.daemon
+			c.Chrony.Addr = chronyd.Addr
+			c.Ntpd.Addr = ntpd.Addr
+			if got := Name(c); got != test.name {
+				t.Errorf("name:\n  got: %v\n want: %v", got, test.name)
+			}
+
+			s, err := Poll(c)
+			if err != nil {
+				t.Fatalf("poll: %v", err)
+			}
+			if got := s.Tracking.Stratum; got != test.wantStratum {
+				t.Errorf("polled stratum:\n  got: %v\n want: %v", got, test.wantStratum)
+			}
+
+			m := NewMonitor(config.Static(c), false)
+			snapshots, unsubscribe := m.Subscribe()
+			defer unsubscribe()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go m.Run(ctx) // nolint:errcheck
+			select {
+			case s := <-snapshots:
+				if s.Err != nil || s.Tracking.Stratum != test.wantStratum || !s.Synchronized() {
+					t.Errorf("monitored snapshot: err %v, tracking %+v", s.Err, s.Tracking)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout waiting for a snapshot")
+			}
+		})
+	}
+}
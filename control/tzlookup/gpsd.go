@@ -0,0 +1,152 @@
+package tzlookup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jrockway/go-gpsd"
+)
+
+// Averager averages the most recent positions, so that GPS noise near a zone boundary doesn't
+// flip the clock back and forth.
+type Averager struct {
+	lat, lon []float64
+	next, n  int
+}
+
+// NewAverager returns an Averager over the last size positions.
+func NewAverager(size int) *Averager {
+	return &Averager{lat: make([]float64, size), lon: make([]float64, size)}
+}
+
+// Add records a position.
+func (a *Averager) Add(lat, lon float64) {
+	a.lat[a.next], a.lon[a.next] = lat, lon
+	a.next = (a.next + 1) % len(a.lat)
+	if a.n < len(a.lat) {
+		a.n++
+	}
+}
+
+// Mean returns the average of the recorded positions, and how many there are.
+func (a *Averager) Mean() (lat, lon float64, n int) {
+	if a.n == 0 {
+		return 0, 0, 0
+	}
+	for i := 0; i < a.n; i++ {
+		lat += a.lat[i]
+		lon += a.lon[i]
+	}
+	return lat / float64(a.n), lon / float64(a.n), a.n
+}
+
+const (
+	// averageFixes is how many fixes are averaged.
+	averageFixes = 60
+	// minFixes is how many fixes are needed before the first lookup.
+	minFixes = 10
+)
+
+// Follow watches the position reported by the gpsd at addr() and calls onZone with the zone at
+// the averaged position, at startup and whenever it changes, until the context is cancelled.
+// Positions that Lookup doesn't know the zone of are ignored.  It reconnects to gpsd as
+// necessary.
+func Follow(ctx context.Context, addr func() string, onZone func(zone string)) {
+	avg := NewAverager(averageFixes)
+	var zone string
+	for {
+		err := follow(ctx, addr(), func(lat, lon float64) {
+			avg.Add(lat, lon)
+			lat, lon, n := avg.Mean()
+			if n < minFixes {
+				return
+			}
+			z := Lookup(lat, lon)
+			if z == zone {
+				return
+			}
+			zone = z
+			if z == "" {
+				log.Printf("tzlookup: no time zone boundaries cover (%.2f, %.2f); keeping the current time zone", lat, lon)
+				return
+			}
+			onZone(z)
+		})
+		log.Printf("tzlookup: %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
+// follow calls onFix with every valid position from gpsd until the connection fails or the
+// context is cancelled.  onFix is never called concurrently.
+func follow(ctx context.Context, addr string, onFix func(lat, lon float64)) error {
+	gps, err := gpsd.Dial(addr)
+	if err != nil {
+		return fmt.Errorf("dial gpsd: %w", err)
+	}
+	fixCh := make(chan [2]float64)
+	gps.AddFilter("TPV", func(r interface{}) {
+		tpv, ok := r.(*gpsd.TPVReport)
+		if !ok || tpv.Mode < gpsd.Mode2D || (tpv.Lat == 0 && tpv.Lon == 0) {
+			return
+		}
+		select {
+		case fixCh <- [2]float64{tpv.Lat, tpv.Lon}:
+		case <-ctx.Done():
+		}
+	})
+	doneCh := gps.Watch()
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("follow gpsd: %w", ctx.Err())
+		case <-doneCh:
+			return fmt.Errorf("gpsd watch at %s stopped", addr)
+		case <-time.After(time.Minute):
+			return fmt.Errorf("gpsd at %s hasn't sent a fix for 1 minute", addr)
+		case fix := <-fixCh:
+			onFix(fix[0], fix[1])
+		}
+	}
+}
+
+// Tracker follows the time zone at the GPS position, starting the first time it's asked for it.
+type Tracker struct {
+	once sync.Once
+	mu   sync.Mutex
+	loc  *time.Location // nil until the position is known; must hold mu.
+}
+
+// Location returns the time zone at the GPS position, or time.Local until a position with a known
+// time zone arrives.
+// The first call starts following the gpsd at addr(), and onChange is called whenever the zone
+// changes after that.  onChange must not block.
+func (t *Tracker) Location(ctx context.Context, addr func() string, onChange func(*time.Location)) *time.Location {
+	t.once.Do(func() {
+		go Follow(ctx, addr, func(zone string) {
+			loc, err := time.LoadLocation(zone)
+			if err != nil {
+				log.Printf("tzlookup: load %s: %v", zone, err)
+				return
+			}
+			log.Printf("tzlookup: gps position is in time zone %s", zone)
+			t.mu.Lock()
+			t.loc = loc
+			t.mu.Unlock()
+			onChange(loc)
+		})
+	})
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.loc == nil {
+		return time.Local
+	}
+	return t.loc
+}
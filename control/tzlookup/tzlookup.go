@@ -0,0 +1,131 @@
+// Package tzlookup finds the time zone at a latitude and longitude, without a network connection.
+// It uses simplified zone boundaries embedded in the binary (see zones.txt), which only cover the
+// United States; elsewhere, it doesn't guess.
+package tzlookup
+
+import (
+	"bufio"
+	_ "embed" // For zones.txt.
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed zones.txt
+var zonesTxt string
+
+type point struct {
+	lat, lon float64
+}
+
+type polygon struct {
+	zone     string
+	vertices []point
+	min, max point // bounding box
+}
+
+// contains returns true if p is inside the polygon, by counting how many edges a ray from p
+// crosses.
+func (pg *polygon) contains(p point) bool {
+	if p.lat < pg.min.lat || p.lat > pg.max.lat || p.lon < pg.min.lon || p.lon > pg.max.lon {
+		return false
+	}
+	var inside bool
+	vs := pg.vertices
+	for i, j := 0, len(vs)-1; i < len(vs); j, i = i, i+1 {
+		a, b := vs[i], vs[j]
+		if (a.lat > p.lat) != (b.lat > p.lat) && p.lon < (b.lon-a.lon)*(p.lat-a.lat)/(b.lat-a.lat)+a.lon {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// parseZones parses the format of zones.txt.
+func parseZones(data string) ([]*polygon, error) {
+	var result []*polygon
+	var current *polygon
+	finish := func() error {
+		if current == nil {
+			return nil
+		}
+		if len(current.vertices) < 3 {
+			return fmt.Errorf("zone %s: polygon has %d vertices; need at least 3", current.zone, len(current.vertices))
+		}
+		result = append(result, current)
+		current = nil
+		return nil
+	}
+	s := bufio.NewScanner(strings.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexByte(line, ',')
+		if i < 0 {
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			current = &polygon{
+				zone: line,
+				min:  point{math.Inf(1), math.Inf(1)},
+				max:  point{math.Inf(-1), math.Inf(-1)},
+			}
+			continue
+		}
+		if current == nil {
+			return nil, fmt.Errorf("line %d: vertex before zone name", n)
+		}
+		lat, err := strconv.ParseFloat(line[:i], 64)
+		if err != nil || lat < -90 || lat > 90 {
+			return nil, fmt.Errorf("line %d: invalid latitude %q", n, line[:i])
+		}
+		lon, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil || lon < -180 || lon > 180 {
+			return nil, fmt.Errorf("line %d: invalid longitude %q", n, line[i+1:])
+		}
+		p := point{lat, lon}
+		current.vertices = append(current.vertices, p)
+		current.min = point{math.Min(current.min.lat, lat), math.Min(current.min.lon, lon)}
+		current.max = point{math.Max(current.max.lat, lat), math.Max(current.max.lon, lon)}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("read zones: %w", err)
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+var (
+	zonesOnce sync.Once
+	zones     []*polygon
+)
+
+func loadZones() []*polygon {
+	zonesOnce.Do(func() {
+		var err error
+		zones, err = parseZones(zonesTxt)
+		if err != nil {
+			// The data is compiled in and covered by tests.
+			panic(fmt.Sprintf("tzlookup: embedded zones.txt: %v", err))
+		}
+	})
+	return zones
+}
+
+// Lookup returns the IANA name of the time zone at a position, in degrees, or "" if the position
+// isn't covered by the embedded boundaries.
+func Lookup(lat, lon float64) string {
+	p := point{lat, lon}
+	for _, pg := range loadZones() {
+		if pg.contains(p) {
+			return pg.zone
+		}
+	}
+	return ""
+}
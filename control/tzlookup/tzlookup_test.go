@@ -0,0 +1,130 @@
+package tzlookup
+
+import (
+	"testing"
+	"time"
+)
+
+func TestZonesParse(t *testing.T) {
+	zones, err := parseZones(zonesTxt)
+	if err != nil {
+		t.Fatalf("parse embedded zones: %v", err)
+	}
+	for _, pg := range zones {
+		if _, err := time.LoadLocation(pg.zone); err != nil {
+			t.Errorf("zone %s: %v", pg.zone, err)
+		}
+	}
+	if _, err := parseZones("America/Nowhere\n1,2\n3,4\n"); err == nil {
+		t.Error("two-vertex polygon: expected error")
+	}
+	if _, err := parseZones("1,2\n"); err == nil {
+		t.Error("vertex without zone: expected error")
+	}
+	if _, err := parseZones("America/Nowhere\n1,200\n"); err == nil {
+		t.Error("invalid longitude: expected error")
+	}
+}
+
+func TestNoOverlaps(t *testing.T) {
+	// Sample a grid that doesn't land exactly on any boundary, and make sure that no point is in
+	// two polygons.
+	for lat := 18.13; lat < 72; lat += 0.25 {
+		for lon := -169.87; lon < -66; lon += 0.25 {
+			var in []string
+			for _, pg := range loadZones() {
+				if pg.contains(point{lat, lon}) {
+					in = append(in, pg.zone)
+				}
+			}
+			if len(in) > 1 {
+				t.Errorf("(%v, %v) is in more than one zone: %v", lat, lon, in)
+			}
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	testData := []struct {
+		name     string
+		lat, lon float64
+		want     string
+	}{
+		{"new york", 40.7128, -74.0060, "America/New_York"},
+		{"miami", 25.7617, -80.1918, "America/New_York"},
+		{"indianapolis", 39.7684, -86.1581, "America/New_York"},
+		{"chicago", 41.8781, -87.6298, "America/Chicago"},
+		{"gary", 41.5934, -87.3464, "America/Chicago"},
+		{"evansville", 37.9716, -87.5711, "America/Chicago"},
+		{"pensacola", 30.4213, -87.2169, "America/Chicago"},
+		{"tallahassee", 30.4383, -84.2807, "America/New_York"},
+		{"houston", 29.7604, -95.3698, "America/Chicago"},
+		{"denver", 39.7392, -104.9903, "America/Denver"},
+		{"el paso", 31.7587, -106.4869, "America/Denver"},
+		{"boise", 43.6150, -116.2023, "America/Denver"},
+		{"phoenix", 33.4484, -112.0740, "America/Phoenix"},
+		{"yuma", 32.6927, -114.6277, "America/Phoenix"},
+		{"los angeles", 34.0522, -118.2437, "America/Los_Angeles"},
+		{"las vegas", 36.1699, -115.1398, "America/Los_Angeles"},
+		{"coeur d'alene", 47.6777, -116.7805, "America/Los_Angeles"},
+		{"anchorage", 61.2181, -149.9003, "America/Anchorage"},
+		{"juneau", 58.3019, -134.4197, "America/Anchorage"},
+		{"honolulu", 21.3069, -157.8583, "Pacific/Honolulu"},
+
+		// Both sides of borders that really are straight lines.
+		{"arizona side of four corners", 36.99, -109.06, "America/Phoenix"},
+		{"new mexico side of four corners", 36.99, -109.04, "America/Denver"},
+		{"arizona side of utah line", 36.99, -111.5, "America/Phoenix"},
+		{"utah side of arizona line", 37.01, -111.5, "America/Denver"},
+		{"nevada side of utah line", 39.0, -114.06, "America/Los_Angeles"},
+		{"utah side of nevada line", 39.0, -114.03, "America/Denver"},
+		{"illinois side of indiana line", 40.0, -87.54, "America/Chicago"},
+		{"indiana side of illinois line", 40.0, -87.52, "America/New_York"},
+		{"new mexico side of texas line", 34.0, -103.07, "America/Denver"},
+		{"texas side of new mexico line", 34.0, -103.03, "America/Chicago"},
+
+		// Border cities.
+		{"detroit", 42.3314, -83.0458, "America/New_York"},
+		{"port huron", 42.9709, -82.4249, "America/New_York"},
+		{"toledo", 41.6528, -83.5379, "America/New_York"},
+		{"san diego", 32.7157, -117.1611, "America/Los_Angeles"},
+		{"seattle", 47.6062, -122.3321, "America/Los_Angeles"},
+		{"buffalo", 42.8864, -78.8784, "America/New_York"},
+
+		// Places the data doesn't cover have no zone, rather than a guess.
+		{"toronto", 43.6532, -79.3832, ""},
+		{"montreal", 45.5017, -73.5673, ""},
+		{"vancouver", 49.2827, -123.1207, ""},
+		{"tijuana", 32.5149, -117.0382, ""},
+		{"san juan", 18.4655, -66.1057, ""},
+		{"mexico city", 19.4326, -99.1332, ""},
+		{"greenwich", 51.4779, -0.0015, ""},
+		{"tokyo", 35.6762, 139.6503, ""},
+		{"mid-atlantic", 30.0, -40.0, ""},
+		{"date line", 0, 180, ""},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			if got, want := Lookup(test.lat, test.lon), test.want; got != want {
+				t.Errorf("zone at (%v, %v):\n  got: %v\n want: %v", test.lat, test.lon, got, want)
+			}
+		})
+	}
+}
+
+func TestAverager(t *testing.T) {
+	a := NewAverager(3)
+	if _, _, n := a.Mean(); n != 0 {
+		t.Errorf("empty averager has %d positions", n)
+	}
+	a.Add(10, 20)
+	a.Add(20, 40)
+	if lat, lon, n := a.Mean(); lat != 15 || lon != 30 || n != 2 {
+		t.Errorf("mean of two:\n  got: %v, %v, %v\n want: 15, 30, 2", lat, lon, n)
+	}
+	a.Add(30, 60)
+	a.Add(40, 80)
+	if lat, lon, n := a.Mean(); lat != 30 || lon != 60 || n != 3 {
+		t.Errorf("mean after wrapping:\n  got: %v, %v, %v\n want: 30, 60, 3", lat, lon, n)
+	}
+}
@@ -0,0 +1,39 @@
+// Package webhook posts JSON events to HTTP endpoints, for alarms and alerts that should reach
+// something outside the clock, like a home automation system or a chat room.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Timeout is how long Post waits for the endpoint to respond.
+const Timeout = 10 * time.Second
+
+// Post sends v to url as JSON.  Any response other than 2xx is an error.
+func Post(ctx context.Context, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal body: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("content-type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("send request: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPost(t *testing.T) {
+	var got map[string]string
+	var contentType string
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		contentType = req.Header.Get("content-type")
+		if err := json.NewDecoder(req.Body).Decode(&got); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+	}))
+	t.Cleanup(ok.Close)
+	if err := Post(context.Background(), ok.URL, map[string]string{"type": "ring"}); err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	if got["type"] != "ring" || contentType != "application/json" {
+		t.Errorf("received %v with content-type %q", got, contentType)
+	}
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "nope", http.StatusInternalServerError)
+	}))
+	t.Cleanup(broken.Close)
+	if err := Post(context.Background(), broken.URL, nil); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("post to broken webhook: unexpected error %v", err)
+	}
+}
@@ -1,53 +1,111 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/fulr/spidev"
+	"github.com/jrockway/beaglebone-gps-clock/control/config"
+	"github.com/jrockway/beaglebone-gps-clock/control/sdnotify"
+	"github.com/jrockway/beaglebone-gps-clock/control/tzlookup"
 )
 
+var (
+	configPath = flag.String("config", config.DefaultPath, "configuration file")
+	utc        = flag.Bool("utc", false, "show UTC, regardless of the configured location")
+)
+
+// followsGPS returns true if the time zone to display comes from the GPS position.
+func followsGPS(c *config.Config) bool {
+	return !*utc && c.Display.SevenSegmentLocation == "" && c.FollowsGPS()
+}
+
+// sendLatest replaces any unread location in locCh with loc.
+func sendLatest(locCh chan *time.Location, loc *time.Location) {
+	select {
+	case <-locCh:
+	default:
+	}
+	locCh <- loc
+}
+
 func main() {
-	here, err := time.LoadLocation("America/New_York")
+	flag.Parse()
+	cfg, err := config.NewWatcher(*configPath)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("load config: %v", err)
+	}
+	startupConfig := cfg.Current()
+	locCh := make(chan *time.Location, 1)
+	var gpsZone tzlookup.Tracker
+	location := func(c *config.Config) *time.Location {
+		switch {
+		case *utc:
+			return time.UTC
+		case followsGPS(c):
+			return gpsZone.Location(context.Background(), func() string { return cfg.Current().Gpsd.Addr }, func(loc *time.Location) {
+				if followsGPS(cfg.Current()) {
+					sendLatest(locCh, loc)
+				}
+			})
+		}
+		// Validation guarantees that the location loads.
+		loc, _ := c.SevenSegmentTimeLocation()
+		return loc
 	}
+	here := location(startupConfig)
+	log.Printf("displaying %s time on %s", here, startupConfig.Display.SevenSegment)
+	cfg.OnChange(func(c *config.Config) {
+		loc := location(c)
+		log.Printf("now displaying %s time", loc)
+		if c.Display.SevenSegment != startupConfig.Display.SevenSegment {
+			log.Printf("display.seven_segment changed; restart to apply")
+		}
+		sendLatest(locCh, loc)
+	})
+	go cfg.Run(context.Background(), 10*time.Second) // nolint:errcheck
 
-	spi, err := spidev.NewSPIDevice("/dev/spidev0.0")
+	spi, err := spidev.NewSPIDevice(startupConfig.Display.SevenSegment)
+	if err != nil {
+		log.Fatalf("open %s: %v", startupConfig.Display.SevenSegment, err)
+	}
+	display, err := initDisplay(spi)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	spi.Xfer([]byte{0x0B, 0x07}) // scan limit
-	spi.Xfer([]byte{0x09, 0xFF}) // decode mode
-	spi.Xfer([]byte{0x0F, 0x00}) // display test off
-	spi.Xfer([]byte{0x0C, 0x01}) // shutdown off
-	spi.Xfer([]byte{0x0A, 0x01}) // brightness
-
-	// blank digits
-	spi.Xfer([]byte{0x06, 0x0F})
-	spi.Xfer([]byte{0x03, 0x0F})
-
 	log.Printf("clock initialized")
 	exit := make(chan os.Signal, 1)
 	signal.Notify(exit, os.Interrupt, syscall.SIGTERM)
 
+	notifier, err := sdnotify.FromEnv()
+	if err != nil {
+		log.Printf("systemd watchdog disabled: %v", err)
+	}
+	// lastUpdate is the UnixNano time of the last display update; systemd's watchdog is only
+	// petted while the display is being updated.
+	var lastUpdate int64
+	go notifier.RunWatchdog(context.Background(), func() bool { // nolint:errcheck
+		return time.Since(time.Unix(0, atomic.LoadInt64(&lastUpdate))) < 3*time.Second
+	})
+	if err := notifier.Notify(sdnotify.Ready); err != nil {
+		log.Printf("notify systemd: %v", err)
+	}
+	cfg.NotifyReloads(notifier)
+
 clock:
 	for {
-		now := time.Now().In(here)
-		h, m, s := now.Clock()
-
-		spi.Xfer([]byte{0x08, byte(h / 10)})
-		spi.Xfer([]byte{0x07, byte((h % 10) | 0x80)})
-
-		spi.Xfer([]byte{0x05, byte(m / 10)})
-		spi.Xfer([]byte{0x04, byte((m % 10) | 0x80)})
-
-		spi.Xfer([]byte{0x02, byte(s / 10)})
-		spi.Xfer([]byte{0x01, byte(s % 10)})
+		if err := showTime(display, time.Now().In(here)); err != nil {
+			log.Print(err)
+		} else {
+			atomic.StoreInt64(&lastUpdate, time.Now().UnixNano())
+		}
 
 		// Wake up again right as the next second starts.  This means that the display will
 		// show the wrong second for about 500 microseconds on average (measured)... but
@@ -56,19 +114,16 @@ clock:
 		select {
 		case <-exit:
 			break clock
+		case here = <-locCh:
 		case <-time.After(next):
 		}
 	}
 	log.Printf("exiting")
+	if err := notifier.Notify(sdnotify.Stopping); err != nil {
+		log.Printf("notify systemd: %v", err)
+	}
 
-	// Blank all digits when exiting on a signal, just so someone looking at the clock can tell
-	// whether the OS crashed or we just exited the program for some reason.
-	spi.Xfer([]byte{0x08, 0x0F})
-	spi.Xfer([]byte{0x07, 0x0F})
-	spi.Xfer([]byte{0x06, 0x0F})
-	spi.Xfer([]byte{0x05, 0x0F})
-	spi.Xfer([]byte{0x04, 0x0F})
-	spi.Xfer([]byte{0x03, 0x0F})
-	spi.Xfer([]byte{0x02, 0x0F})
-	spi.Xfer([]byte{0x01, 0x8F}) // period so that you can see the clock still has power.
+	if err := showStopped(display); err != nil {
+		log.Print(err)
+	}
 }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jrockway/beaglebone-gps-clock/control/max7219"
+)
+
+func TestShowTime(t *testing.T) {
+	f := new(max7219.Fake)
+	d, err := initDisplay(f)
+	if err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	if got, want := f.Text(), "        "; got != want {
+		t.Errorf("after init:\n  got: %q\n want: %q", got, want)
+	}
+	if err := showTime(d, time.Date(2021, 10, 4, 9, 5, 7, 0, time.UTC)); err != nil {
+		t.Fatalf("show time: %v", err)
+	}
+	if got, want := f.Text(), "09. 05. 07"; got != want {
+		t.Errorf("time:\n  got: %q\n want: %q", got, want)
+	}
+	if err := showStopped(d); err != nil {
+		t.Fatalf("show stopped: %v", err)
+	}
+	if got, want := f.Text(), "        ."; got != want {
+		t.Errorf("stopped:\n  got: %q\n want: %q", got, want)
+	}
+}
+
+func TestDSTIndicator(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spring := time.Date(2021, 3, 14, 7, 0, 0, 0, time.UTC) // 02:00 EST becomes 03:00 EDT.
+	fall := time.Date(2021, 11, 7, 6, 0, 0, 0, time.UTC)   // 02:00 EDT becomes 01:00 EST.
+	testData := []struct {
+		name string
+		t    time.Time
+		want string
+	}{
+		{"ordinary day", time.Date(2021, 10, 4, 9, 5, 7, 0, ny), "09. 05. 07"},
+		{"long before spring", spring.Add(-time.Hour - time.Second).In(ny), "00. 59. 59"},
+		{"hour before spring", spring.Add(-time.Hour).In(ny), "01. .00. 00"},
+		{"instant before spring", spring.Add(-time.Second).In(ny), "01. .59. 59"},
+		{"spring", spring.In(ny), "03. 00. .00"},
+		{"end of hour after spring", spring.Add(time.Hour - time.Second).In(ny), "03. 59. .59"},
+		{"long after spring", spring.Add(time.Hour).In(ny), "04. 00. 00"},
+		{"hour before fall", fall.Add(-time.Hour).In(ny), "01. .00. 00"},
+		{"fall", fall.In(ny), "01. 00. .00"},
+		{"long after fall", fall.Add(time.Hour).In(ny), "02. 00. 00"},
+		{"utc never changes", spring, "07. 00. 00"},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			f := new(max7219.Fake)
+			d, err := initDisplay(f)
+			if err != nil {
+				t.Fatalf("init: %v", err)
+			}
+			if err := showTime(d, test.t); err != nil {
+				t.Fatalf("show time: %v", err)
+			}
+			if got, want := f.Text(), test.want; got != want {
+				t.Errorf("display at %v:\n  got: %q\n want: %q", test.t, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jrockway/beaglebone-gps-clock/control/max7219"
+)
+
+// The display is 8 digits, wired so that digit 7 is on the left: "HH. MM. SS".  The separator
+// digits are blank, but their decimal points warn of daylight saving time transitions: the first
+// lights during the hour before a transition, and the second during the hour after.
+const (
+	digitHourTens   = 7
+	digitHourOnes   = 6
+	digitSep1       = 5
+	digitMinuteTens = 4
+	digitMinuteOnes = 3
+	digitSep2       = 2
+	digitSecondTens = 1
+	digitSecondOnes = 0
+)
+
+// initDisplay sets up the display for showTime.
+func initDisplay(conn max7219.Conn) (*max7219.Device, error) {
+	d, err := max7219.New(conn, max7219.MaxDigits)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.SetDecodeMode(0xFF); err != nil {
+		return nil, fmt.Errorf("init display: %w", err)
+	}
+	if err := d.SetBrightness(1); err != nil {
+		return nil, fmt.Errorf("init display: %w", err)
+	}
+	if err := d.Clear(); err != nil {
+		return nil, fmt.Errorf("init display: %w", err)
+	}
+	return d, nil
+}
+
+// dstWindow is how long before and after a change in UTC offset the display indicates it.
+const dstWindow = time.Hour
+
+// nearTransition reports whether t's location changes its UTC offset in the hour after t
+// (before), or changed it in the hour up to and including t (after).
+func nearTransition(t time.Time) (before, after bool) {
+	_, now := t.Zone()
+	_, next := t.Add(dstWindow).Zone()
+	_, prev := t.Add(-dstWindow).Zone()
+	return next != now, prev != now
+}
+
+// showTime displays the time of day of t, in t's location.
+func showTime(d *max7219.Device, t time.Time) error {
+	h, m, s := t.Clock()
+	before, after := nearTransition(t)
+	for _, digit := range []struct {
+		i     int
+		v     int
+		point bool
+	}{
+		{digitHourTens, h / 10, false},
+		{digitHourOnes, h % 10, true},
+		{digitMinuteTens, m / 10, false},
+		{digitMinuteOnes, m % 10, true},
+		{digitSecondTens, s / 10, false},
+		{digitSecondOnes, s % 10, false},
+		{digitSep1, int(max7219.BCDBlank), before},
+		{digitSep2, int(max7219.BCDBlank), after},
+	} {
+		if err := d.SetBCD(digit.i, byte(digit.v), digit.point); err != nil {
+			return fmt.Errorf("show time: %w", err)
+		}
+	}
+	return nil
+}
+
+// showStopped blanks the display, except for the last decimal point, so someone looking at the
+// clock can tell whether the OS crashed or we just exited the program for some reason.
+func showStopped(d *max7219.Device) error {
+	if err := d.Clear(); err != nil {
+		return fmt.Errorf("show stopped: %w", err)
+	}
+	if err := d.SetBCD(digitSecondOnes, max7219.BCDBlank, true); err != nil {
+		return fmt.Errorf("show stopped: %w", err)
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ require (
 	github.com/jrockway/go-gpsd v0.0.0-20210914052111-4bc2d052dcac
 	github.com/jrockway/periphflag v0.0.0-20191020104359-a1cd7211ce99
 	github.com/prometheus/client_golang v1.2.1
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	golang.org/x/exp v0.0.0-20191030013958-a1ab85dbe136
 	golang.org/x/image v0.0.0-20210220032944-ac19c3e999fb
 	golang.org/x/net v0.0.0-20210119194325-5f4716e94777
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jrockway/beaglebone-gps-clock/control/alert"
+	"github.com/jrockway/beaglebone-gps-clock/control/chronymon"
+	"github.com/jrockway/go-gpsd"
+	"golang.org/x/net/trace"
+)
+
+// alerts evaluates the rules in alerts.file against the metrics that the watchers below set:
+//
+//	chrony.up                     1 if chronyd answered the last poll, 0 if not
+//	chrony.synchronized           1 unless chronyd's leap status is "Unsynchronized"
+//	chrony.stratum                chronyd's own stratum
+//	chrony.leap_status            0 normal, 1 insert second, 2 delete second, 3 unsynchronized
+//	chrony.system_offset_seconds  how far the system clock is from true time; positive is fast
+//	chrony.last_offset_seconds    the offset at the last clock update
+//	chrony.rms_offset_seconds     the long-term average of the offset
+//	gpsd.fix_mode                 1 no fix, 2 2D, 3 3D; the best of all devices
+//	gpsd.satellites_used          from the last sky report
+//	gpsd.satellites_visible       from the last sky report
+//	sensors.temperature_celsius
+//	sensors.relative_humidity
+//	sensors.pressure_hpa
+//	sensors.lux                   MaxLux when the sensor is saturated
+var alerts = alert.NewEngine(nil)
+
+// setChronyMetrics records a snapshot's metrics for alerting.
+func setChronyMetrics(s *chronymon.Snapshot) {
+	if s.Err != nil {
+		alerts.Set("chrony.up", 0, s.Time)
+		return
+	}
+	t := s.Tracking
+	synchronized := 1.0
+	if t.LeapStatus == 3 {
+		synchronized = 0
+	}
+	alerts.Set("chrony.up", 1, s.Time)
+	alerts.Set("chrony.synchronized", synchronized, s.Time)
+	alerts.Set("chrony.stratum", float64(t.Stratum), s.Time)
+	alerts.Set("chrony.leap_status", float64(t.LeapStatus), s.Time)
+	// chronyd reports the correction it's applying, which is the opposite of the offset.
+	alerts.Set("chrony.system_offset_seconds", -t.CurrentCorrection, s.Time)
+	alerts.Set("chrony.last_offset_seconds", t.LastOffset, s.Time)
+	alerts.Set("chrony.rms_offset_seconds", t.RMSOffset, s.Time)
+}
+
+var (
+	fixModesMu sync.Mutex
+	fixModes   = make(map[string]gpsd.Mode) // By device; must hold fixModesMu.
+)
+
+// setFixMetrics records a TPV report's fix mode for alerting.
+func setFixMetrics(tpv *gpsd.TPVReport, t time.Time) {
+	fixModesMu.Lock()
+	defer fixModesMu.Unlock()
+	fixModes[tpv.Device] = tpv.Mode
+	var best gpsd.Mode
+	for _, m := range fixModes {
+		if m > best {
+			best = m
+		}
+	}
+	alerts.Set("gpsd.fix_mode", float64(best), t)
+}
+
+// setSkyMetrics records a SKY report's satellite counts for alerting.
+func setSkyMetrics(sky *gpsd.SKYReport, t time.Time) {
+	var used int
+	for _, s := range sky.Satellites {
+		if s.Used {
+			used++
+		}
+	}
+	alerts.Set("gpsd.satellites_used", float64(used), t)
+	alerts.Set("gpsd.satellites_visible", float64(len(sky.Satellites)), t)
+}
+
+// alertWatcher keeps the alert engine's rules in sync with the rules file, and sends
+// notifications to its webhooks.
+type alertWatcher struct {
+	engine *alert.Engine
+	retry  time.Duration // How long to wait before trying a webhook again.
+	events trace.EventLog
+
+	path  string    // The rules file that was last loaded.
+	mtime time.Time // Its modification time; zero if it was missing.
+}
+
+// alertBatch is notifications to send to some webhooks.
+type alertBatch struct {
+	webhooks      []string
+	notifications []alert.Notification
+}
+
+// reload loads the rules file at path if it's a different file than last time or it has changed
+// since.  Rules that fail to load leave the old rules in place.  Alerts that were firing when
+// their rules were removed are resolved, with notifications for the old rules' webhooks.
+func (w *alertWatcher) reload(path string, now time.Time) (alertBatch, error) {
+	var mtime time.Time
+	if path != "" {
+		info, err := os.Stat(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return alertBatch{}, fmt.Errorf("stat rules: %w", err)
+		}
+		if err == nil {
+			mtime = info.ModTime()
+		}
+	}
+	if path == w.path && mtime.Equal(w.mtime) {
+		return alertBatch{}, nil
+	}
+	var f *alert.File
+	if path != "" {
+		var err error
+		if f, err = alert.LoadFile(path); err != nil {
+			return alertBatch{}, err
+		}
+	}
+	removed := alertBatch{webhooks: w.engine.Webhooks()}
+	removed.notifications = w.engine.SetFile(f, now)
+	w.path, w.mtime = path, mtime
+	w.events.Printf("loaded %d alert rules from %q", len(w.engine.Alerts()), path)
+	return removed, nil
+}
+
+// check reloads the rules from path, evaluates them, and returns the notifications to send, in
+// order.
+func (w *alertWatcher) check(path string, now time.Time) []alertBatch {
+	var result []alertBatch
+	removed, err := w.reload(path, now)
+	if err != nil {
+		w.events.Errorf("reload alert rules: %v", err)
+	}
+	if len(removed.notifications) > 0 {
+		result = append(result, removed)
+	}
+	if ns := w.engine.Evaluate(now); len(ns) > 0 {
+		result = append(result, alertBatch{webhooks: w.engine.Webhooks(), notifications: ns})
+	}
+	for _, b := range result {
+		for _, n := range b.notifications {
+			w.events.Printf("%s %s: %s", n.Status, n.Rule, n.Summary)
+		}
+	}
+	return result
+}
+
+// deliver sends a batch of notifications to its webhooks.
+func (w *alertWatcher) deliver(ctx context.Context, b alertBatch) {
+	if err := alert.Deliver(ctx, b.webhooks, b.notifications, w.retry); err != nil {
+		w.events.Errorf("deliver notifications: %v", err)
+	}
+}
+
+// alertQueueSize is how many batches of notifications can wait for delivery before new ones are
+// dropped.
+const alertQueueSize = 100
+
+// watchAlerts evaluates the alert rules every 10 seconds, forever.  Notifications are delivered
+// one batch at a time, in the order they happened, so that a webhook never hears that an alert
+// resolved before it hears that it fired.
+func watchAlerts() {
+	l := trace.NewEventLog("service", "alerts")
+	defer l.Finish()
+	w := &alertWatcher{engine: alerts, retry: 10 * time.Second, events: l}
+	queue := make(chan alertBatch, alertQueueSize)
+	go func() {
+		for b := range queue {
+			w.deliver(context.Background(), b)
+		}
+	}()
+	for range time.Tick(10 * time.Second) {
+		for _, b := range w.check(cfg.Current().Alerts.File, time.Now()) {
+			select {
+			case queue <- b:
+			default:
+				l.Errorf("webhooks are too far behind; dropping %d notifications", len(b.notifications))
+			}
+		}
+	}
+}
+
+// ServeAlerts shows the state of every alert, as JSON.
+func ServeAlerts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, alerts.Alerts())
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jrockway/beaglebone-gps-clock/control/alert"
+	"github.com/jrockway/beaglebone-gps-clock/control/chronymon"
+	"golang.org/x/net/trace"
+)
+
+func TestAlertWatcher(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var n alert.Notification
+		if err := json.NewDecoder(req.Body).Decode(&n); err != nil {
+			t.Errorf("decode notification: %v", err)
+		}
+		mu.Lock()
+		received = append(received, n.Status+" "+n.Rule)
+		mu.Unlock()
+	}))
+	t.Cleanup(hook.Close)
+
+	path := filepath.Join(t.TempDir(), "alerts.json")
+	rules := fmt.Sprintf(`{"webhooks": [%q], "rules": [
+		{"name": "chronyd-down", "metric": "chrony.up", "op": "<", "value": 1, "if_missing": true},
+		{"name": "offset", "metric": "chrony.system_offset_seconds", "abs": true, "op": ">", "value": 0.001}
+	]}`, hook.URL)
+	if err := os.WriteFile(path, []byte(rules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	alerts = alert.NewEngine(nil)
+	l := trace.NewEventLog("test", "alerts")
+	defer l.Finish()
+	w := &alertWatcher{engine: alerts, retry: time.Millisecond, events: l}
+	start := time.Now()
+
+	var got []string
+	check := func(path string, now time.Time) {
+		for _, b := range w.check(path, now) {
+			for _, n := range b.notifications {
+				got = append(got, n.Status+" "+n.Rule)
+			}
+			w.deliver(context.Background(), b)
+		}
+	}
+	check(path, start)
+	setChronyMetrics(&chronymon.Snapshot{Time: start, Err: errors.New("connection refused")})
+	check(path, start.Add(10*time.Second))
+	s := &chronymon.Snapshot{Time: start.Add(20 * time.Second)}
+	s.Tracking.CurrentCorrection = 0.002
+	setChronyMetrics(s)
+	check(path, start.Add(20*time.Second))
+	want := "firing chronyd-down, resolved chronyd-down, firing offset"
+	if got := strings.Join(got, ", "); got != want {
+		t.Errorf("notifications:\n  got: %v\n want: %v", got, want)
+	}
+	mu.Lock()
+	if got := strings.Join(received, ", "); got != want {
+		t.Errorf("webhook received:\n  got: %v\n want: %v", got, want)
+	}
+	mu.Unlock()
+
+	rec := httptest.NewRecorder()
+	ServeAlerts(rec, httptest.NewRequest("GET", "/alerts", nil))
+	var states []alert.Alert
+	if err := json.Unmarshal(rec.Body.Bytes(), &states); err != nil {
+		t.Fatalf("unmarshal alerts: %v", err)
+	}
+	if len(states) != 2 || states[1].State != alert.StateFiring || states[1].Value != -0.002 {
+		t.Errorf("alerts: %s", rec.Body.String())
+	}
+
+	// Edits to the rules file take effect; broken edits are ignored.
+	if err := os.WriteFile(path, []byte(`{"rules": [{"name": "oops"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, start.Add(time.Minute), start.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.reload(path, start.Add(time.Minute)); err == nil {
+		t.Error("reload broken rules: expected error")
+	}
+	if got, want := len(alerts.Alerts()), 2; got != want {
+		t.Errorf("alerts after broken edit:\n  got: %v\n want: %v", got, want)
+	}
+
+	// Disabling alerts resolves the firing one, at the webhooks it fired at.
+	got = nil
+	mu.Lock()
+	received = nil
+	mu.Unlock()
+	check("", start.Add(2*time.Minute))
+	want = "resolved offset"
+	if got := strings.Join(got, ", "); got != want {
+		t.Errorf("notifications after disabling:\n  got: %v\n want: %v", got, want)
+	}
+	mu.Lock()
+	if got := strings.Join(received, ", "); got != want {
+		t.Errorf("webhook received after disabling:\n  got: %v\n want: %v", got, want)
+	}
+	mu.Unlock()
+	if got, want := len(alerts.Alerts()), 0; got != want {
+		t.Errorf("alerts after disabling:\n  got: %v\n want: %v", got, want)
+	}
+}
@@ -1,157 +1,109 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"net"
-	"time"
+	"strings"
 
-	"github.com/facebookincubator/ntp/protocol/chrony"
+	"github.com/jrockway/beaglebone-gps-clock/control/chronymon"
+	"github.com/jrockway/beaglebone-gps-clock/control/timedaemon"
 	"golang.org/x/net/trace"
 )
 
 const source = "beaglebone"
 
-type SourceInfo struct {
-	Index int
-	Data  chrony.SourceData
-	Stats chrony.SourceStats
-}
-
+// watchChrony polls chronyd, or ntpd if time_daemon says so, sending what it reports, and what
+// changed since the last poll, to the status page, InfluxDB, MQTT and the alert engine.
 func watchChrony() {
 	l := trace.NewEventLog("service", "chrony")
 	defer l.Finish()
-	for {
-		if err := monitorChrony(l); err != nil {
-			log.Printf("monitorChrony exited unexpectedly: %v", err)
-			l.Errorf("monitorChrony exited unexpectedly: %v", err)
-			time.Sleep(10 * time.Second)
+	l.Printf("monitoring %s", timedaemon.Name(cfg.Current()))
+	m := timedaemon.NewMonitor(cfg, true)
+	snapshots, _ := m.Subscribe()
+	go m.Run(context.Background()) // nolint:errcheck
+	var d chronymon.Detector
+	for s := range snapshots {
+		setChronyMetrics(s)
+		if s.Err != nil {
+			l.Errorf("poll: %v", s.Err)
+			continue
 		}
+		reportChrony(l, s)
+		reportChronyEvents(l, d.Next(s))
 	}
 }
 
-func monitorChrony(l trace.EventLog) error {
-	l.Printf("dial localhost:323")
-	conn, err := net.DialTimeout("udp", "localhost:323", time.Second)
-	if err != nil {
-		l.Errorf("dial: %v", err)
-		return fmt.Errorf("dial: %w", err)
+// reportChrony sends one snapshot to the status page, InfluxDB and MQTT.
+func reportChrony(l trace.EventLog, s *chronymon.Snapshot) {
+	UpdateChrony(s)
+	ts := s.Time.UnixNano()
+	tracking := s.Tracking
+	l.Printf("tracking: %#v", tracking)
+	line := fmt.Sprintf(`tracking,machine=%s ref_id="%x",stratum=%vu,leap_status=%vu,reftime=%vu,correction=%v,offset=%v,rms_offset=%v,freq_ppm=%v,residual_freq_ppm=%v,skew=%v,root_delay=%v,root_dispersion=%v,update_interval=%v %v`, source, tracking.RefID, tracking.Stratum, tracking.LeapStatus, tracking.RefTime.UnixNano(), tracking.CurrentCorrection, tracking.LastOffset, tracking.RMSOffset, tracking.FreqPPM, tracking.ResidFreqPPM, tracking.SkewPPM, tracking.RootDelay, tracking.LastUpdateInterval, tracking.RootDispersion, ts)
+	if err := sendToInflux(line); err != nil {
+		l.Errorf("tracking: problem sending to influx: %v", err)
+	}
+	if err := publishToMQTT("sync", syncStatusFromTracking(&tracking)); err != nil {
+		l.Errorf("tracking: problem publishing to mqtt: %v", err)
 	}
 
-	c := chrony.Client{Sequence: 1, Connection: conn}
-	log.Printf("connected to chronyd ok; starting loop")
-	var wait bool
-	for {
-		if wait {
-			time.Sleep(30 * time.Second)
-		} else {
-			wait = true
-		}
-		deadline := time.Now().Add(time.Minute)
-		if err := conn.SetReadDeadline(deadline); err != nil {
-			l.Errorf("set read deadline: %v", err)
-			return fmt.Errorf("set read deadline: %w", err)
-		}
-		l.Printf("extended read deadline to %v", deadline.Format("15:04:05.000000"))
-		ts := time.Now().UnixNano()
-
-		treq := chrony.NewTrackingPacket()
-		tres, err := c.Communicate(treq)
-		if err != nil {
-			return fmt.Errorf("get tracking info: communicate: %w", err)
-		}
-		tracking, ok := tres.(*chrony.ReplyTracking)
-		if ok {
-			UpdateStatus(Status{Tracking: *tracking, Now: time.Now()})
-			l.Printf("tracking: %#v", tracking)
-			line := fmt.Sprintf(`tracking,machine=%s ref_id="%x",stratum=%vu,leap_status=%vu,reftime=%vu,correction=%v,offset=%v,rms_offset=%v,freq_ppm=%v,residual_freq_ppm=%v,skew=%v,root_delay=%v,root_dispersion=%v,update_interval=%v %v`, source, tracking.RefID, tracking.Stratum, tracking.LeapStatus, tracking.RefTime.UnixNano(), tracking.CurrentCorrection, tracking.LastOffset, tracking.RMSOffset, tracking.FreqPPM, tracking.ResidFreqPPM, tracking.SkewPPM, tracking.RootDelay, tracking.LastUpdateInterval, tracking.RootDispersion, ts)
-			if err := sendToInflux(line); err != nil {
-				l.Errorf("get tracking info: problem sending to influx: %v", err)
-			}
-		} else {
-			l.Errorf("tracking reply was of unexpected type: %#v", tres)
-		}
-
-		ssreq := chrony.NewSourcesPacket()
-		sres, err := c.Communicate(ssreq)
-		if err != nil {
-			return fmt.Errorf("get sources: %w", err)
-		}
-		var sources int
-		if s, ok := sres.(*chrony.ReplySources); ok {
-			l.Printf("sources: %#v", s)
-			sources = s.NSources
-		} else {
-			l.Errorf("sources reply was of unexpected type: %#v", sres)
-			sources = 0
+	for _, src := range s.Sources {
+		sd, ss := src.Data, src.Stats
+		l.Printf("source %v (%v):\n    data: %#v\n    stats: %#v", src.Index, chronymon.SourceName(sd.IPAddr), sd, ss)
+		line := fmt.Sprintf("source,machine=%s,source=%s poll=%vi,stratum=%vu,state=%vu,mode=%vu,flags=%vu,reachability=%vu,since_sample=%vu,orig_latest_meas=%v,latest_meas=%v,latest_meas_err=%v,samples=%vu,runs=%vu,span=%vu,resid_freq_ppm=%v,skew_ppm=%v,estimated_offset=%v,estimated_offset_err=%v,standard_deviation=%v %v", source, chronymon.SourceName(sd.IPAddr), sd.Poll, sd.Stratum, sd.State, sd.Mode, sd.Flags, sd.Reachability, sd.SinceSample, sd.OrigLatestMeas, sd.LatestMeas, sd.LatestMeasErr, ss.NSamples, ss.NRuns, ss.SpanSeconds, ss.ResidFreqPPM, ss.SkewPPM, ss.EstimatedOffset, ss.EstimatedOffsetErr, ss.StandardDeviation, ts)
+		if err := sendToInflux(line); err != nil {
+			l.Errorf("source %v: problem sending to influx: %v", src.Index, err)
 		}
+	}
 
-		// Chrony seems to keep sources sorted consistently in its own output, so I assume
-		// its internal representation allows it to return matching source data and
-		// sourcestats for lookups of the same index.  Not so sure that I wouldn't not write
-		// this comment though I guess.
-		var info []SourceInfo
-		for i := 0; i < sources; i++ {
-			// Get source data.
-			sdreq := chrony.NewSourceDataPacket(int32(i))
-			sdres, err := c.Communicate(sdreq)
-			if err != nil {
-				return fmt.Errorf("get sources: %w", err)
-			}
-			sd, ok := sdres.(*chrony.ReplySourceData)
-			if !ok {
-				l.Errorf("source %v: source data reply was of unexpected type: %#v", i, sdres)
-				continue
-			}
-
-			// Get sourcestats.
-			ssreq := chrony.NewSourceStatsPacket(int32(i))
-			ssres, err := c.Communicate(ssreq)
-			if err != nil {
-				return fmt.Errorf("get sourcestats: %w", err)
-			}
-			ss, ok := ssres.(*chrony.ReplySourceStats)
-			if !ok {
-				l.Errorf("source %v: sourcestats reply was of unexpected type: %#v", i, ssres)
-				continue
-			}
-
-			// Send both as one row.
-			l.Printf("source %v (%v):\n    data: %#v\n    stats: %#v", i, refID(sd.IPAddr), sd, ss)
-			line := fmt.Sprintf("source,machine=%s,source=%s poll=%vi,stratum=%vu,state=%vu,mode=%vu,flags=%vu,reachability=%vu,since_sample=%vu,orig_latest_meas=%v,latest_meas=%v,latest_meas_err=%v,samples=%vu,runs=%vu,span=%vu,resid_freq_ppm=%v,skew_ppm=%v,estimated_offset=%v,estimated_offset_err=%v,standard_deviation=%v %v", source, refID(sd.IPAddr), sd.Poll, sd.Stratum, sd.State, sd.Mode, sd.Flags, sd.Reachability, sd.SinceSample, sd.OrigLatestMeas, sd.LatestMeas, sd.LatestMeasErr, ss.NSamples, ss.NRuns, ss.SpanSeconds, ss.ResidFreqPPM, ss.SkewPPM, ss.EstimatedOffset, ss.EstimatedOffsetErr, ss.StandardDeviation, ts)
-			if err := sendToInflux(line); err != nil {
-				l.Errorf("source %v: problem sending to influx: %v", i, err)
-			}
-			info = append(info, SourceInfo{
-				Index: i,
-				Data:  sd.SourceData,
-				Stats: ss.SourceStats,
-			})
+	for _, err := range s.Warnings {
+		l.Errorf("%v", err)
+	}
+	if lines := optionalReportLines(s); lines != "" {
+		if err := sendToInflux(lines); err != nil {
+			l.Errorf("optional reports: problem sending to influx: %v", err)
 		}
-		UpdateStatus(Status{Sources: info})
 	}
 }
 
-func refID(ip net.IP) string {
-	if v4 := ip.To4(); v4 != nil {
-		last := len(v4)
-		for i, b := range v4 {
-			if b == 0 && i > 0 {
-				last = i
-				break
-			}
-			if b < '0' || b > 'z' {
-				last = 0
-				break
-			}
+// optionalReportLines formats the reports that chronyd doesn't always give out as InfluxDB line
+// protocol, leaving out the ones that are missing.
+func optionalReportLines(s *chronymon.Snapshot) string {
+	ts := s.Time.UnixNano()
+	buf := new(strings.Builder)
+	for _, src := range s.Sources {
+		if n := src.NTP; n != nil {
+			fmt.Fprintf(buf, "ntpdata,machine=%s,source=%s stratum=%vu,poll=%vi,root_delay=%v,root_dispersion=%v,offset=%v,peer_delay=%v,peer_dispersion=%v,response_time=%v,jitter_asymmetry=%v,tx_count=%vu,rx_count=%vu,valid_count=%vu %v\n", source, chronymon.SourceName(src.Data.IPAddr), n.Stratum, n.Poll, n.RootDelay, n.RootDispersion, n.Offset, n.PeerDelay, n.PeerDispersion, n.ResponseTime, n.JitterAsymmetry, n.TotalTXCount, n.TotalRXCount, n.TotalValidCount, ts)
 		}
-		if last > 0 {
-			return string(v4[0:last])
+	}
+	if st := s.ServerStats; st != nil {
+		fmt.Fprintf(buf, "serverstats,machine=%s ntp_hits=%vu,nke_hits=%vu,cmd_hits=%vu,ntp_drops=%vu,nke_drops=%vu,cmd_drops=%vu,log_drops=%vu,ntp_auth_hits=%vu %v\n", source, st.NTPHits, st.NKEHits, st.CMDHits, st.NTPDrops, st.NKEDrops, st.CMDDrops, st.LogDrops, st.NTPAuthHits, ts)
+	}
+	if s.Clients != nil {
+		var ntpClients, ntpHits, ntpDrops, cmdHits, cmdDrops uint64
+		var ntpRate float64
+		for _, c := range s.Clients {
+			if c.LastNTP >= 0 {
+				ntpClients++
+			}
+			ntpHits += uint64(c.NTPHits)
+			ntpDrops += uint64(c.NTPDrops)
+			cmdHits += uint64(c.CmdHits)
+			cmdDrops += uint64(c.CmdDrops)
+			ntpRate += c.NTPRate()
 		}
+		fmt.Fprintf(buf, "clients,machine=%s count=%vu,ntp_clients=%vu,ntp_hits=%vu,ntp_drops=%vu,ntp_rate=%v,cmd_hits=%vu,cmd_drops=%vu %v\n", source, len(s.Clients), ntpClients, ntpHits, ntpDrops, ntpRate, cmdHits, cmdDrops, ts)
+	}
+	if a := s.Activity; a != nil {
+		fmt.Fprintf(buf, "activity,machine=%s online=%vu,offline=%vu,burst_online=%vu,burst_offline=%vu,unresolved=%vu %v\n", source, a.Online, a.Offline, a.BurstOnline, a.BurstOffline, a.Unresolved, ts)
+	}
+	if r := s.RTC; r != nil {
+		fmt.Fprintf(buf, "rtc,machine=%s reftime=%vu,samples=%vu,runs=%vu,span=%vu,seconds_fast=%v,gain_rate_ppm=%v %v\n", source, r.RefTime.UnixNano(), r.Samples, r.Runs, int64(r.Span.Seconds()), r.SecondsFast, r.GainRatePPM, ts)
 	}
-	return ip.String()
+	return buf.String()
 }
 
 func intRefID(ip uint32) string {
-	return refID(net.IPv4(byte((ip>>24)&0xff), byte((ip>>16)&0xff), byte((ip>>8)&0xff), byte(ip&0xff)))
+	return chronymon.SourceName(net.IPv4(byte((ip>>24)&0xff), byte((ip>>16)&0xff), byte((ip>>8)&0xff), byte(ip&0xff)))
 }
@@ -3,30 +3,46 @@ package main
 import (
 	"net"
 	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/chrony"
+	"github.com/jrockway/beaglebone-gps-clock/control/chronymon"
 )
 
-func TestRefID(t *testing.T) {
-	testData := []struct {
-		in   net.IP
-		want string
-	}{
-		{nil, "<nil>"},
-		{net.IPv4(0, 0, 0, 0), "0.0.0.0"},
-		{net.IPv6interfacelocalallnodes, "ff01::1"},
-		{net.IPv4(1, 2, 3, 4), "1.2.3.4"},
-		{net.IPv4('A', 0, 0, 0), "A"},
-		{net.IPv4(80, 80, 83, 0), "PPS"},
-		{net.IPv4(71, 80, 83, 0), "GPS"},
-		{net.IPv4(82, 84, 67, 0), "RTC"},
-		{net.IPv4(80, 72, 67, 48), "PHC0"},
+func TestOptionalReportLines(t *testing.T) {
+	s := &chronymon.Snapshot{Time: time.Unix(1633046400, 0)}
+	if got := optionalReportLines(s); got != "" {
+		t.Errorf("no optional reports:\n  got: %q\n want: \"\"", got)
+	}
+
+	s.Sources = []chronymon.Source{
+		{Data: chrony.SourceData{IPAddr: net.IPv4(80, 80, 83, 0)}},
+		{Data: chrony.SourceData{IPAddr: net.IPv4(192, 0, 2, 1)}, NTP: &chrony.NTPData{Stratum: 1, Poll: 6, Offset: -0.5, TotalTXCount: 3, TotalRXCount: 2, TotalValidCount: 1}},
 	}
+	s.ServerStats = &chrony.ServerStats2{NTPHits: 10, CMDHits: 2}
+	s.Clients = []chronymon.Client{
+		{IP: net.IPv4(192, 0, 2, 10), NTPHits: 100, NTPDrops: 2, NTPInterval: 6, LastNTP: time.Second, LastCmd: -1},
+		{IP: net.IPv4(192, 0, 2, 11), NTPHits: 50, NTPInterval: -1, LastNTP: 0, LastCmd: -1},
+		{IP: net.IPv4(127, 0, 0, 1), CmdHits: 4, CmdInterval: 3, LastNTP: -1, LastCmd: time.Minute},
+	}
+	s.Activity = &chronymon.Activity{Online: 1, Offline: 1}
+	s.RTC = &chronymon.RTC{RefTime: time.Unix(1633046000, 0), Samples: 5, Runs: 3, Span: time.Hour, SecondsFast: 0.25, GainRatePPM: -1.5}
+	want := "" +
+		"ntpdata,machine=beaglebone,source=192.0.2.1 stratum=1u,poll=6i,root_delay=0,root_dispersion=0,offset=-0.5,peer_delay=0,peer_dispersion=0,response_time=0,jitter_asymmetry=0,tx_count=3u,rx_count=2u,valid_count=1u 1633046400000000000\n" +
+		"serverstats,machine=beaglebone ntp_hits=10u,nke_hits=0u,cmd_hits=2u,ntp_drops=0u,nke_drops=0u,cmd_drops=0u,log_drops=0u,ntp_auth_hits=0u 1633046400000000000\n" +
+		"clients,machine=beaglebone count=3u,ntp_clients=2u,ntp_hits=150u,ntp_drops=2u,ntp_rate=2.015625,cmd_hits=4u,cmd_drops=0u 1633046400000000000\n" +
+		"activity,machine=beaglebone online=1u,offline=1u,burst_online=0u,burst_offline=0u,unresolved=0u 1633046400000000000\n" +
+		"rtc,machine=beaglebone reftime=1633046000000000000u,samples=5u,runs=3u,span=3600u,seconds_fast=0.25,gain_rate_ppm=-1.5 1633046400000000000\n"
+	if got := optionalReportLines(s); got != want {
+		t.Errorf("optional reports:\n  got: %v\n want: %v", got, want)
+	}
+}
 
-	for _, test := range testData {
-		t.Run(test.in.String(), func(t *testing.T) {
-			got := refID(test.in)
-			if want := test.want; got != want {
-				t.Errorf("convert refid (%s):\n  got: %v\n want: %v", []byte(test.in), got, want)
-			}
-		})
+func TestSyncStatusFromTracking(t *testing.T) {
+	// The clock is slow, so chronyd is speeding it up.
+	got := syncStatusFromTracking(&chrony.Tracking{RefID: 0x50505300, Stratum: 1, CurrentCorrection: 0.002, RMSOffset: 0.0001})
+	want := SyncStatus{Synchronized: true, Reference: "PPS", Stratum: 1, LeapStatus: chronymon.LeapStatusName(0), OffsetSeconds: -0.002, RMSOffsetSeconds: 0.0001}
+	if got != want {
+		t.Errorf("sync status:\n  got: %+v\n want: %+v", got, want)
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"image"
 	"image/color"
 	"log"
+	"sync/atomic"
 	"time"
 
 	"github.com/goiot/devices/dotstar"
@@ -33,6 +34,9 @@ var myFont = &basicfont.Face{
 	},
 }
 
+// lastDraw is the UnixNano time that the clock was last drawn successfully.
+var lastDraw int64
+
 type fakeSPI struct{}
 
 func (x fakeSPI) Open() (driver.Conn, error) { return x, nil }
@@ -105,6 +109,8 @@ func drawClock() {
 		}
 		if err := d.Draw(); err != nil {
 			l.Errorf("draw clock: %v", err)
+		} else {
+			atomic.StoreInt64(&lastDraw, time.Now().UnixNano())
 		}
 		UpdateStatus(Status{ClockFace: img})
 		l.Printf("sleeping for %s", time.Until(time.Now().Add(time.Second).Truncate(time.Second)).String())
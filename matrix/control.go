@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jrockway/beaglebone-gps-clock/control/chronymon"
+	"github.com/jrockway/beaglebone-gps-clock/control/config"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/net/trace"
+)
+
+// maxRecentAudit is how many audit log entries chronyControl keeps in memory.
+const maxRecentAudit = 100
+
+// chronyControl lets users control chronyd over HTTP; mount it with http.StripPrefix.
+//
+//	GET  /          the recent audit log, as JSON
+//	POST /<action>  do a chronymon.Action: burst, makestep, online, offline or reselect; burst,
+//	                online and offline take an optional form value source=<address> to act on
+//	                one source instead of all of them
+//
+// Users log in with HTTP basic auth, and are checked against chrony.control_users.  Every attempt,
+// allowed or not, is appended to chrony.audit_log; actions are refused if it can't be written.
+type chronyControl struct {
+	cfg     *config.Watcher
+	timeout time.Duration // How long chronyd has to reply.
+	events  trace.EventLog
+
+	mu     sync.Mutex
+	recent []auditEntry // The last maxRecentAudit entries, oldest first; must hold mu.
+}
+
+// auditEntry is one line of the audit log.
+type auditEntry struct {
+	Time   time.Time        `json:"time"`
+	User   string           `json:"user"`
+	Remote string           `json:"remote"`
+	Action chronymon.Action `json:"action"`
+	Source string           `json:"source,omitempty"`
+	Error  string           `json:"error,omitempty"` // Empty if chronyd did what was asked.
+}
+
+func newChronyControl(cfg *config.Watcher) *chronyControl {
+	return &chronyControl{cfg: cfg, timeout: chronymon.DefaultTimeout, events: trace.NewEventLog("service", "chrony-control")}
+}
+
+func (x *chronyControl) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	c := x.cfg.Current()
+	if c.Chrony.ControlUsers == "" {
+		http.Error(w, "controlling chronyd is disabled; set chrony.control_users to enable it", http.StatusNotFound)
+		return
+	}
+	action := chronymon.Action(strings.TrimPrefix(req.URL.Path, "/"))
+	e := auditEntry{Time: time.Now(), Remote: req.RemoteAddr, Action: action, Source: req.FormValue("source")}
+	user, password, _ := req.BasicAuth()
+	e.User = user
+	ok, err := checkPassword(c.Chrony.ControlUsers, user, password)
+	if err != nil {
+		x.events.Errorf("check password: %v", err)
+		http.Error(w, "problem checking password", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		e.Error = "not authorized"
+		if err := x.audit(c.Chrony.AuditLog, e); err != nil {
+			x.events.Errorf("audit: %v", err)
+		}
+		w.Header().Set("www-authenticate", `Basic realm="chrony", charset="UTF-8"`)
+		http.Error(w, "not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	if action == "" {
+		if req.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		x.mu.Lock()
+		recent := append([]auditEntry(nil), x.recent...)
+		x.mu.Unlock()
+		writeJSON(w, recent)
+		return
+	}
+	if !knownAction(action) {
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Browsers send basic auth credentials along with forms posted from other sites, so only
+	// accept requests that say where they came from.
+	if err := checkOrigin(req); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	// Make sure the action can be recorded before doing it.
+	f, err := os.OpenFile(c.Chrony.AuditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		x.events.Errorf("open audit log: %v", err)
+		http.Error(w, fmt.Sprintf("open audit log: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	code := http.StatusOK
+	var source net.IP
+	if e.Source != "" {
+		if source = net.ParseIP(e.Source); source == nil {
+			err = fmt.Errorf("invalid source address %q", e.Source)
+			code = http.StatusBadRequest
+		} else if !action.TakesSource() {
+			err = fmt.Errorf("%s does not take a source", action)
+			code = http.StatusBadRequest
+		}
+	}
+	if err == nil {
+		if err = chronymon.Control(c.Chrony.Socket, action, source, x.timeout); err != nil {
+			code = http.StatusBadGateway
+		}
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if err := x.record(f, e); err != nil {
+		x.events.Errorf("write audit log: %v", err)
+		http.Error(w, fmt.Sprintf("write audit log: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if code != http.StatusOK {
+		http.Error(w, e.Error, code)
+		return
+	}
+	writeJSON(w, e)
+}
+
+func knownAction(a chronymon.Action) bool {
+	for _, known := range chronymon.Actions {
+		if a == known {
+			return true
+		}
+	}
+	return false
+}
+
+// audit appends e to the audit log at path.
+func (x *chronyControl) audit(path string, e auditEntry) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	if err := x.record(f, e); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// record writes e to the audit log, the event log and the list of recent entries.
+func (x *chronyControl) record(w io.Writer, e auditEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal audit entry: %w", err)
+	}
+	log.Printf("chrony control: %s", line)
+	x.events.Printf("%s", line)
+	x.mu.Lock()
+	x.recent = append(x.recent, e)
+	if len(x.recent) > maxRecentAudit {
+		x.recent = x.recent[len(x.recent)-maxRecentAudit:]
+	}
+	x.mu.Unlock()
+	if _, err := w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write audit entry: %w", err)
+	}
+	return nil
+}
+
+// checkOrigin returns an error unless req came from a page served by this host.  The Origin header
+// is checked when present; otherwise Sec-Fetch-Site must say the request was same-origin or made
+// by the user directly.  Requests that carry neither are rejected.
+func checkOrigin(req *http.Request) error {
+	if origin := req.Header.Get("origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != req.Host {
+			return fmt.Errorf("cross-origin request from %q", origin)
+		}
+		return nil
+	}
+	switch site := req.Header.Get("sec-fetch-site"); site {
+	case "same-origin", "none":
+		return nil
+	case "":
+		return errors.New("request has neither an Origin nor a Sec-Fetch-Site header")
+	default:
+		return fmt.Errorf("cross-origin request (Sec-Fetch-Site: %s)", site)
+	}
+}
+
+// checkPassword returns true if the users file at path has a line "user:hash", where hash is a
+// bcrypt hash of password, as made by "htpasswd -nB user".  Blank lines and lines starting with # are ignored.  The file is read
+// each time, so that changes to it take effect without a restart.
+func checkPassword(path, user, password string) (bool, error) {
+	if user == "" {
+		return false, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("open users file: %w", err)
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return false, fmt.Errorf("%s:%d: expected name:hash", path, n)
+		}
+		if parts[0] == user {
+			err := bcrypt.CompareHashAndPassword([]byte(parts[1]), []byte(password))
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, nil
+			}
+			if err != nil {
+				return false, fmt.Errorf("%s:%d: %w", path, n, err)
+			}
+			return true, nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return false, fmt.Errorf("read users file: %w", err)
+	}
+	return false, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	e := json.NewEncoder(w)
+	e.SetIndent("", "    ")
+	if err := e.Encode(v); err != nil {
+		log.Printf("encode response: %v", err)
+	}
+}
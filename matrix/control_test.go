@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/ntp/protocol/chrony"
+	"github.com/jrockway/beaglebone-gps-clock/control/chronymon/chronytest"
+	"github.com/jrockway/beaglebone-gps-clock/control/config"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/net/trace"
+)
+
+func TestChronyControl(t *testing.T) {
+	fake, err := chronytest.NewServer()
+	if err != nil {
+		t.Fatalf("start fake chronyd: %v", err)
+	}
+	t.Cleanup(func() { fake.Close() })
+	fake.SetSources([]chrony.SourceData{{IPAddr: net.IPv4(192, 0, 2, 1)}}, nil)
+
+	dir := t.TempDir()
+	users := filepath.Join(dir, "users")
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(users, []byte("# users\n\nalice:"+string(hash)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c := config.Default()
+	c.Chrony.Socket = fake.Socket
+	c.Chrony.ControlUsers = users
+	c.Chrony.AuditLog = filepath.Join(dir, "audit.log")
+	x := &chronyControl{cfg: config.Static(c), timeout: time.Second, events: trace.NewEventLog("test", "chrony-control")}
+	h := http.StripPrefix("/chrony", x)
+
+	testData := []struct {
+		name           string
+		method, path   string
+		user, password string
+		source         string
+		origin, site   string
+		wantCode       int
+		wantBody       string
+	}{
+		{name: "no password", method: "POST", path: "/chrony/burst", wantCode: http.StatusUnauthorized},
+		{name: "wrong password", method: "POST", path: "/chrony/burst", user: "alice", password: "hunter3", wantCode: http.StatusUnauthorized},
+		{name: "unknown user", method: "POST", path: "/chrony/burst", user: "mallory", password: "hunter2", wantCode: http.StatusUnauthorized},
+		{name: "burst", method: "POST", path: "/chrony/burst", user: "alice", password: "hunter2", wantCode: http.StatusOK, wantBody: `"action": "burst"`},
+		{name: "burst one source", method: "POST", path: "/chrony/burst", user: "alice", password: "hunter2", source: "192.0.2.1", wantCode: http.StatusOK, wantBody: `"source": "192.0.2.1"`},
+		{name: "same origin", method: "POST", path: "/chrony/makestep", user: "alice", password: "hunter2", origin: "http://example.com", wantCode: http.StatusOK},
+		{name: "other origin", method: "POST", path: "/chrony/makestep", user: "alice", password: "hunter2", origin: "http://evil.example", wantCode: http.StatusForbidden},
+		{name: "no origin", method: "POST", path: "/chrony/makestep", user: "alice", password: "hunter2", origin: "-", wantCode: http.StatusForbidden},
+		{name: "same site", method: "POST", path: "/chrony/makestep", user: "alice", password: "hunter2", origin: "-", site: "same-site", wantCode: http.StatusForbidden},
+		{name: "same origin fetch", method: "POST", path: "/chrony/makestep", user: "alice", password: "hunter2", origin: "-", site: "same-origin", wantCode: http.StatusOK},
+		{name: "missing source", method: "POST", path: "/chrony/offline", user: "alice", password: "hunter2", source: "192.0.2.2", wantCode: http.StatusBadGateway, wantBody: "offline: got status NOSUCHSOURCE"},
+		{name: "bad source", method: "POST", path: "/chrony/online", user: "alice", password: "hunter2", source: "ntp.example", wantCode: http.StatusBadRequest, wantBody: `invalid source address "ntp.example"`},
+		{name: "source for reselect", method: "POST", path: "/chrony/reselect", user: "alice", password: "hunter2", source: "192.0.2.1", wantCode: http.StatusBadRequest, wantBody: "reselect does not take a source"},
+		{name: "unknown action", method: "POST", path: "/chrony/shutdown", user: "alice", password: "hunter2", wantCode: http.StatusNotFound},
+		{name: "get action", method: "GET", path: "/chrony/burst", user: "alice", password: "hunter2", wantCode: http.StatusMethodNotAllowed},
+		{name: "audit log", method: "GET", path: "/chrony/", user: "alice", password: "hunter2", wantCode: http.StatusOK, wantBody: `"user": "mallory"`},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			form := url.Values{}
+			if test.source != "" {
+				form.Set("source", test.source)
+			}
+			req := httptest.NewRequest(test.method, test.path, strings.NewReader(form.Encode()))
+			req.Header.Set("content-type", "application/x-www-form-urlencoded")
+			if test.user != "" {
+				req.SetBasicAuth(test.user, test.password)
+			}
+			// Requests come from our own page unless the test says otherwise; "-" sends no Origin.
+			switch test.origin {
+			case "":
+				req.Header.Set("origin", "http://example.com")
+			case "-":
+			default:
+				req.Header.Set("origin", test.origin)
+			}
+			if test.site != "" {
+				req.Header.Set("sec-fetch-site", test.site)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if got, want := rec.Code, test.wantCode; got != want {
+				t.Errorf("response code:\n  got: %v\n want: %v\n body: %s", got, want, rec.Body.String())
+			}
+			if got, want := rec.Body.String(), test.wantBody; !strings.Contains(got, want) {
+				t.Errorf("body:\n  got: %v\n want: something containing %v", got, want)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("www-authenticate") == "" {
+				t.Error("no www-authenticate header")
+			}
+		})
+	}
+
+	var sources []string
+	for _, c := range fake.Controls() {
+		sources = append(sources, c.Source.String())
+	}
+	if got, want := strings.Join(sources, " "), "<nil> 192.0.2.1 <nil> <nil>"; got != want {
+		t.Errorf("controls that chronyd received:\n  got: %v\n want: %v", got, want)
+	}
+
+	f, err := os.Open(c.Chrony.AuditLog)
+	if err != nil {
+		t.Fatalf("open audit log: %v", err)
+	}
+	defer f.Close()
+	var lines []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var e auditEntry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			t.Fatalf("audit log line %q: %v", s.Text(), err)
+		}
+		lines = append(lines, e.User+" "+string(e.Action)+" "+e.Source+" "+e.Error)
+	}
+	want := []string{
+		" burst  not authorized",
+		"alice burst  not authorized",
+		"mallory burst  not authorized",
+		"alice burst  ",
+		"alice burst 192.0.2.1 ",
+		"alice makestep  ",
+		"alice makestep  ",
+		"alice offline 192.0.2.2 offline: got status NOSUCHSOURCE",
+		`alice online ntp.example invalid source address "ntp.example"`,
+		"alice reselect 192.0.2.1 reselect does not take a source",
+	}
+	if got := strings.Join(lines, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("audit log:\n  got: %v\n want: %v", got, strings.Join(want, "\n"))
+	}
+
+	// Control is off unless there are users.
+	c.Chrony.ControlUsers = ""
+	req := httptest.NewRequest("POST", "/chrony/burst", nil)
+	req.SetBasicAuth("alice", "hunter2")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got, want := rec.Code, http.StatusNotFound; got != want {
+		t.Errorf("disabled: response code:\n  got: %v\n want: %v", got, want)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/jrockway/beaglebone-gps-clock/control/chronymon"
+	"golang.org/x/net/trace"
+)
+
+// maxEvents is how many chrony events the timeline page shows.
+const maxEvents = 500
+
+var (
+	eventsMu     sync.Mutex
+	chronyEvents []chronymon.Event // The most recent events, oldest first; must hold eventsMu.
+
+	//go:embed events.html.tmpl
+	eventsHTML string
+	eventsPage = template.Must(template.New("events").Funcs(funcMap).Parse(eventsHTML))
+)
+
+// reportChronyEvents sends changes in chronyd's state to the event log, the timeline page and
+// InfluxDB, where they can be used as annotations.
+func reportChronyEvents(l trace.EventLog, events []chronymon.Event) {
+	if len(events) == 0 {
+		return
+	}
+	eventsMu.Lock()
+	chronyEvents = append(chronyEvents, events...)
+	if len(chronyEvents) > maxEvents {
+		chronyEvents = chronyEvents[len(chronyEvents)-maxEvents:]
+	}
+	eventsMu.Unlock()
+	for _, e := range events {
+		l.Printf("event: %v", e)
+	}
+	if err := sendToInflux(eventLines(events)); err != nil {
+		l.Errorf("events: problem sending to influx: %v", err)
+	}
+}
+
+// eventLines formats events as InfluxDB line protocol.  The text field is meant to be shown as an
+// annotation's text, and the kind and source tags as its tags.
+func eventLines(events []chronymon.Event) string {
+	buf := new(strings.Builder)
+	for _, e := range events {
+		fmt.Fprintf(buf, "chrony_event,machine=%s,kind=%s", source, escapeTag(string(e.Kind)))
+		if e.Source != "" {
+			fmt.Fprintf(buf, ",source=%s", escapeTag(e.Source))
+		}
+		fmt.Fprintf(buf, " text=%s,from=%s,to=%s %v\n", quoteField(e.String()), quoteField(e.From), quoteField(e.To), e.Time.UnixNano())
+	}
+	return buf.String()
+}
+
+var (
+	tagEscaper   = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	fieldEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+// escapeTag escapes a tag value for line protocol.
+func escapeTag(s string) string { return tagEscaper.Replace(s) }
+
+// quoteField quotes a string field value for line protocol.
+func quoteField(s string) string { return `"` + fieldEscaper.Replace(s) + `"` }
+
+// ServeEvents shows the timeline of changes in chronyd's state, newest first.
+func ServeEvents(w http.ResponseWriter, r *http.Request) {
+	eventsMu.Lock()
+	events := make([]chronymon.Event, len(chronyEvents))
+	for i, e := range chronyEvents {
+		events[len(events)-1-i] = e
+	}
+	eventsMu.Unlock()
+	w.WriteHeader(http.StatusOK)
+	if err := eventsPage.Execute(w, events); err != nil {
+		log.Printf("execute template: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jrockway/beaglebone-gps-clock/control/chronymon"
+	"golang.org/x/net/trace"
+)
+
+func TestEventLines(t *testing.T) {
+	events := []chronymon.Event{
+		{Time: time.Unix(1633046400, 0), Kind: chronymon.EventSelected, From: "PPS", To: "192.0.2.1"},
+		{Time: time.Unix(1633046430, 0), Kind: chronymon.EventState, Source: `odd, "name"`, From: "combined", To: "falseticker"},
+	}
+	want := "" +
+		`chrony_event,machine=beaglebone,kind=selected text="selected source changed from PPS to 192.0.2.1",from="PPS",to="192.0.2.1" 1633046400000000000` + "\n" +
+		`chrony_event,machine=beaglebone,kind=state,source=odd\,\ "name" text="odd, \"name\" changed from combined to falseticker",from="combined",to="falseticker" 1633046430000000000` + "\n"
+	if got := eventLines(events); got != want {
+		t.Errorf("event lines:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func TestServeEvents(t *testing.T) {
+	l := trace.NewEventLog("test", "chrony")
+	defer l.Finish()
+	for i := 0; i < maxEvents+10; i++ {
+		reportChronyEvents(l, []chronymon.Event{{Time: time.Unix(1633046400+int64(i), 0), Kind: chronymon.EventStratum, From: "1", To: "2"}})
+	}
+	reportChronyEvents(l, []chronymon.Event{{Time: time.Unix(1633050000, 0), Kind: chronymon.EventReachability, Source: "PPS", From: "377", To: "0"}})
+	eventsMu.Lock()
+	if got, want := len(chronyEvents), maxEvents; got != want {
+		t.Errorf("events kept:\n  got: %v\n want: %v", got, want)
+	}
+	eventsMu.Unlock()
+
+	rec := httptest.NewRecorder()
+	ServeEvents(rec, httptest.NewRequest("GET", "/events", nil))
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Errorf("response code:\n  got: %v\n want: %v", got, want)
+	}
+	body := rec.Body.String()
+	newest, older := strings.Index(body, "PPS became unreachable"), strings.Index(body, "stratum changed from 1 to 2")
+	if newest < 0 || older < 0 || newest > older {
+		t.Errorf("events should be shown newest first:\n%s", body)
+	}
+}
@@ -22,8 +22,9 @@ func watchGpsd() {
 
 func monitorGpsd(l trace.EventLog) {
 	watchdog := make(chan struct{})
-	l.Printf("dial localhost:2947")
-	gps, err := gpsd.Dial("localhost:2947")
+	addr := cfg.Current().Gpsd.Addr
+	l.Printf("dial %s", addr)
+	gps, err := gpsd.Dial(addr)
 	if err != nil {
 		l.Errorf("dial gpsd: %v", err)
 		return
@@ -39,6 +40,7 @@ func monitorGpsd(l trace.EventLog) {
 		default:
 		}
 		l.Printf("tpv report: %#v", tpv)
+		setFixMetrics(tpv, t)
 		AddPosition(tpv.Device, tpv.Lat, tpv.Lon, tpv.Alt)
 		msg := fmt.Sprintf("tpv,device=%v lat=%v,lon=%v,alt=%v %v\n", tpv.Device, tpv.Lat, tpv.Lon, tpv.Alt, t.UnixNano())
 		if err := sendToInflux(msg); err != nil {
@@ -58,6 +60,7 @@ func monitorGpsd(l trace.EventLog) {
 		default:
 		}
 		l.Printf("sky report: %#v", sky)
+		setSkyMetrics(sky, t)
 		sort.Slice(sky.Satellites, func(i, j int) bool {
 			return sky.Satellites[i].PRN < sky.Satellites[j].PRN
 		})
@@ -88,7 +91,10 @@ func monitorGpsd(l trace.EventLog) {
 			l.Errorf("gpsd hasn't sent data for 1 minute; restarting")
 			return
 		case <-watchdog:
-			continue
+			if newAddr := cfg.Current().Gpsd.Addr; newAddr != addr {
+				l.Printf("gpsd.addr changed from %s to %s; reconnecting", addr, newAddr)
+				return
+			}
 		}
 	}
 }
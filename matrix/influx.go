@@ -14,7 +14,7 @@ import (
 )
 
 var (
-	influxEventLog = trace.NewEventLog("destination", "influxdb.jrock.us")
+	influxEventLog = trace.NewEventLog("destination", "influxdb")
 	token          string
 )
 
@@ -40,7 +40,7 @@ func sendToInflux(body string) error {
 
 	ctx, c := context.WithTimeout(context.Background(), 5*time.Second)
 	defer c()
-	req, err := http.NewRequestWithContext(ctx, "POST", "https://influxdb.jrock.us/api/v2/write?org=jrock.us&bucket=home-sensors", strings.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", cfg.Current().InfluxDB.URL, strings.NewReader(body))
 	if err != nil {
 		return fmt.Errorf("create request: %v", err)
 	}
@@ -1,16 +1,44 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
+	"sync/atomic"
+	"time"
+
+	"github.com/jrockway/beaglebone-gps-clock/control/config"
+	"github.com/jrockway/beaglebone-gps-clock/control/sdnotify"
 
 	"golang.org/x/net/trace"
 	"periph.io/x/conn/v3/i2c/i2creg"
 	"periph.io/x/host/v3"
 )
 
+var configPath = flag.String("config", config.DefaultPath, "configuration file")
+
+// cfg holds the current configuration.  The default is replaced with the contents of the config
+// file at startup.
+var cfg = config.Static(config.Default())
+
 func main() {
+	flag.Parse()
+	w, err := config.NewWatcher(*configPath)
+	if err != nil {
+		log.Fatalf("load config: %v", err)
+	}
+	cfg = w
+	startupConfig := cfg.Current()
+	cfg.OnChange(func(c *config.Config) {
+		if c.HTTP.Bind != startupConfig.HTTP.Bind || c.Sensors.I2CBus != startupConfig.Sensors.I2CBus {
+			log.Printf("http.bind or sensors.i2c_bus changed; restart to apply")
+		}
+	})
+	go cfg.Run(context.Background(), 10*time.Second) // nolint:errcheck
+
 	trace.AuthRequest = func(req *http.Request) (any bool, sensitive bool) {
 		return true, true
 	}
@@ -19,7 +47,7 @@ func main() {
 		log.Fatalf("init host: %v", err)
 	}
 
-	i2cBus, err := i2creg.Open("2")
+	i2cBus, err := i2creg.Open(startupConfig.Sensors.I2CBus)
 	if err != nil {
 		log.Printf("open i2c: %v", err)
 		log.Printf("not monitoring sensors")
@@ -34,10 +62,30 @@ func main() {
 	go drawClock()
 	go watchGpsd()
 	go watchChrony()
+	go watchMQTT()
+	go watchAlerts()
+
+	notifier, err := sdnotify.FromEnv()
+	if err != nil {
+		log.Printf("systemd watchdog disabled: %v", err)
+	}
+	go notifier.RunWatchdog(context.Background(), func() bool { // nolint:errcheck
+		return time.Since(time.Unix(0, atomic.LoadInt64(&lastDraw))) < 3*time.Second
+	})
 
-	log.Println("listening on :8080")
+	log.Printf("listening on %s", startupConfig.HTTP.Bind)
 	http.HandleFunc("/", ServeStatus)
-	if err := http.ListenAndServe("0.0.0.0:8080", nil); err != nil {
-		log.Println(err)
+	http.HandleFunc("/events", ServeEvents)
+	http.HandleFunc("/alerts", ServeAlerts)
+	http.Handle("/debug/config", cfg)
+	http.Handle("/chrony/", http.StripPrefix("/chrony", newChronyControl(cfg)))
+	l, err := net.Listen("tcp", startupConfig.HTTP.Bind)
+	if err != nil {
+		log.Fatalf("listen: %v", err)
+	}
+	if err := notifier.Notify(sdnotify.Ready); err != nil {
+		log.Printf("notify systemd: %v", err)
 	}
+	cfg.NotifyReloads(notifier)
+	log.Fatal(http.Serve(l, nil))
 }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/facebookincubator/ntp/protocol/chrony"
+	"github.com/jrockway/beaglebone-gps-clock/control/chronymon"
+	"github.com/jrockway/beaglebone-gps-clock/control/config"
+	"github.com/jrockway/beaglebone-gps-clock/control/mqtt"
+	"golang.org/x/net/trace"
+)
+
+var (
+	mqttEventLog = trace.NewEventLog("destination", "mqtt")
+
+	mqttMu     sync.Mutex
+	mqttClient *mqtt.Client // nil if no broker is configured; must hold mqttMu.
+	mqttPrefix string       // must hold mqttMu.
+)
+
+// watchMQTT maintains a connection to the MQTT broker named in the config, reconnecting whenever
+// the broker or topic prefix changes.
+func watchMQTT() {
+	changedCh := make(chan struct{}, 1)
+	cfg.OnChange(func(*config.Config) {
+		select {
+		case changedCh <- struct{}{}:
+		default:
+		}
+	})
+	for {
+		c := cfg.Current()
+		broker, prefix := c.MQTT.Broker, c.MQTT.Prefix
+		ctx, cancel := context.WithCancel(context.Background())
+		doneCh := make(chan struct{})
+		if broker == "" {
+			log.Println("not publishing to mqtt; mqtt.broker is not configured")
+			mqttEventLog.Errorf("not published; mqtt.broker is empty")
+			setMQTTClient(nil, prefix)
+			close(doneCh)
+		} else {
+			// Validation guarantees that the URL parses.
+			client, _ := mqtt.ParseURL(broker, "matrix")
+			availability := prefix + "/monitor/availability"
+			client.Will = &mqtt.Message{Topic: availability, Payload: []byte("offline"), Retain: true}
+			client.OnConnect = func() {
+				mqttEventLog.Printf("connected to %s", client.Addr)
+				client.Publish(mqtt.Message{Topic: availability, Payload: []byte("online"), Retain: true}) // nolint:errcheck
+			}
+			setMQTTClient(client, prefix)
+			go func() {
+				defer close(doneCh)
+				if err := client.Run(ctx); err != nil && ctx.Err() == nil {
+					log.Printf("mqtt client exited unexpectedly: %v", err)
+				}
+			}()
+		}
+		for {
+			<-changedCh
+			if c := cfg.Current(); c.MQTT.Broker != broker || c.MQTT.Prefix != prefix {
+				break
+			}
+		}
+		cancel()
+		<-doneCh
+	}
+}
+
+func setMQTTClient(c *mqtt.Client, prefix string) {
+	mqttMu.Lock()
+	defer mqttMu.Unlock()
+	mqttClient, mqttPrefix = c, prefix
+}
+
+// publishToMQTT publishes v as JSON to <prefix>/<topic>, retained so that home automation systems
+// see the latest value as soon as they subscribe.  If no broker is configured, we just log the
+// message.
+func publishToMQTT(topic string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal %s: %w", topic, err)
+	}
+	mqttMu.Lock()
+	client, prefix := mqttClient, mqttPrefix
+	mqttMu.Unlock()
+	if prefix == "" {
+		prefix = cfg.Current().MQTT.Prefix
+	}
+	topic = prefix + "/" + topic
+	mqttEventLog.Printf("%s: %s", topic, body)
+	if client == nil {
+		return nil
+	}
+	if err := client.Publish(mqtt.Message{Topic: topic, Payload: body, Retain: true}); err != nil && err != mqtt.ErrNotConnected {
+		return fmt.Errorf("publish: %w", err)
+	}
+	return nil
+}
+
+// SyncStatus is the synchronization status published to MQTT.
+type SyncStatus struct {
+	Synchronized     bool    `json:"synchronized"`
+	Reference        string  `json:"reference"`
+	Stratum          uint16  `json:"stratum"`
+	LeapStatus       string  `json:"leap_status"`
+	OffsetSeconds    float64 `json:"offset_seconds"` // Positive if the system clock is fast.
+	RMSOffsetSeconds float64 `json:"rms_offset_seconds"`
+	FreqPPM          float64 `json:"freq_ppm"`
+}
+
+// syncStatusFromTracking summarizes chronyd's tracking report.  chronyd reports the correction it
+// is applying to the clock, which is the negated offset.
+func syncStatusFromTracking(t *chrony.Tracking) SyncStatus {
+	return SyncStatus{
+		// Leap status 3 is "unsynchronized"; see chronymon.LeapStatusName.
+		Synchronized:     t.LeapStatus != 3 && t.Stratum > 0,
+		Reference:        formatRefID(t.RefID),
+		Stratum:          t.Stratum,
+		LeapStatus:       chronymon.LeapStatusName(t.LeapStatus),
+		OffsetSeconds:    -t.CurrentCorrection,
+		RMSOffsetSeconds: t.RMSOffset,
+		FreqPPM:          t.FreqPPM,
+	}
+}
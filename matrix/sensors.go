@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"log"
+	"math"
 	"time"
 
 	"golang.org/x/net/trace"
@@ -36,12 +37,21 @@ func monitorSensors(i2cBus i2c.Bus) error {
 				continue
 			}
 			l.Printf("Temp: %v, Pressure: %v, Humidity: %v", e.Temperature, e.Pressure, e.Humidity)
+			now := time.Now()
+			alerts.Set("sensors.temperature_celsius", e.Temperature.Celsius(), now)
+			alerts.Set("sensors.relative_humidity", float64(e.Humidity)/float64(physic.PercentRH), now)
+			alerts.Set("sensors.pressure_hpa", float64(e.Pressure)/float64(100*physic.Pascal), now)
 			// Temperature is in nanokelvin.  Humidity is in 10ths of a micro% (!).  Pressure is in nanopascal.
 			if err := sendToInflux(fmt.Sprintf("environment temperature=%vu,relative_humidity=%vu,pressure=%vu %v\n", int64(e.Temperature), int64(e.Humidity), int64(e.Pressure), time.Now().UnixNano())); err != nil {
 				l.Errorf("error: write influx: %v", err)
-				continue
 			}
-
+			if err := publishToMQTT("sensors/environment", map[string]float64{
+				"temperature_celsius": e.Temperature.Celsius(),
+				"relative_humidity":   float64(e.Humidity) / float64(physic.PercentRH),
+				"pressure_hpa":        float64(e.Pressure) / float64(100*physic.Pascal),
+			}); err != nil {
+				l.Errorf("error: publish mqtt: %v", err)
+			}
 		}
 	}()
 
@@ -82,7 +92,28 @@ func monitorSensors(i2cBus i2c.Bus) error {
 			// TODO: scale by gain before writing.
 			if err := sendToInflux(fmt.Sprintf("luminosity combined=%vu,ir=%vu %v\n", both, ir, time.Now().UnixNano())); err != nil {
 				l.Errorf("write influx: %v", err)
-				continue
+			}
+			lux, saturated := light.Lux(both, ir), light.Saturated(both, ir)
+			switch {
+			case saturated:
+				// Brighter than we can measure, not dark.
+				lux = MaxLux
+			case math.IsNaN(lux) || math.IsInf(lux, 0):
+				// Dark.
+				lux = 0
+			}
+			var saturatedFlag float64
+			if saturated {
+				saturatedFlag = 1
+			}
+			alerts.Set("sensors.lux", lux, time.Now())
+			if err := publishToMQTT("sensors/luminosity", map[string]float64{
+				"combined":  float64(both),
+				"ir":        float64(ir),
+				"lux":       lux,
+				"saturated": saturatedFlag,
+			}); err != nil {
+				l.Errorf("publish mqtt: %v", err)
 			}
 		}
 	}()
@@ -114,6 +145,15 @@ const (
 	MaxGain    Gain = 0x30
 )
 
+// MaxLux is the brightest light that the TSL2591 can measure.
+const MaxLux = 88000
+
+// Full-scale channel counts; a reading at full scale has saturated the ADC.
+const (
+	maxCount100ms = 0x9400
+	maxCount      = 0xffff
+)
+
 const (
 	CommandEnablePowerOff = 0x00 // nolint:deadcode
 	CommandEnablePowerOn  = 0x01
@@ -220,6 +260,17 @@ func (t *TSL2591) GetLuminosity() (uint16, uint16, error) {
 	return chan0, chan1, nil
 }
 
+// Saturated returns true if a reading is too bright for the current gain and integration time, in
+// which case Lux is meaningless.
+func (t *TSL2591) Saturated(total, ir uint16) bool {
+	full := uint16(maxCount)
+	if t.it == 100*time.Millisecond {
+		full = maxCount100ms
+	}
+	// The IR channel can't exceed the full-spectrum one unless the latter has overflowed.
+	return total >= full || ir >= full || total < ir
+}
+
 func (t *TSL2591) Lux(total, ir uint16) float64 {
 	var gain float64
 	switch t.gain {
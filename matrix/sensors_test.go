@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSaturated(t *testing.T) {
+	testData := []struct {
+		name      string
+		it        time.Duration
+		total, ir uint16
+		want      bool
+	}{
+		{name: "dark", it: 600 * time.Millisecond, want: false},
+		{name: "normal", it: 600 * time.Millisecond, total: 1000, ir: 200, want: false},
+		{name: "full scale", it: 600 * time.Millisecond, total: 0xffff, ir: 200, want: true},
+		{name: "ir full scale", it: 600 * time.Millisecond, total: 0xffff, ir: 0xffff, want: true},
+		{name: "ir above total", it: 600 * time.Millisecond, total: 1000, ir: 2000, want: true},
+		{name: "100ms full scale", it: 100 * time.Millisecond, total: 0x9400, ir: 200, want: true},
+		{name: "600ms at 100ms full scale", it: 600 * time.Millisecond, total: 0x9400, ir: 200, want: false},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			light := &TSL2591{gain: HighGain, it: test.it}
+			if got, want := light.Saturated(test.total, test.ir), test.want; got != want {
+				t.Errorf("saturated:\n  got: %v\n want: %v", got, want)
+			}
+		})
+	}
+}
@@ -16,11 +16,13 @@ import (
 	"os"
 	"os/exec"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/facebookincubator/ntp/protocol/chrony"
+	"github.com/jrockway/beaglebone-gps-clock/control/chronymon"
 	"github.com/jrockway/go-gpsd"
 )
 
@@ -36,11 +38,13 @@ var (
 		"refid":       formatRefID,
 		"duration":    formatDuration,
 		"float3":      formatFloat3,
-		"leap":        formatLeap,
+		"leap":        chronymon.LeapStatusName,
 		"correction":  formatCorrection,
 		"freq":        formatFreq,
 		"sourcedata":  formatSourceData,
 		"sourcestats": formatSourceStats,
+		"ntpdata":     formatNTPData,
+		"client":      formatClient,
 		"image":       formatImage,
 		"skyview":     formatSkyView,
 		"deviation":   formatDeviation,
@@ -61,8 +65,12 @@ type PositionHistory struct {
 type Status struct {
 	ClockFace    *image.RGBA
 	Now          time.Time
-	Tracking     chrony.ReplyTracking
-	Sources      []SourceInfo
+	Tracking     chrony.Tracking
+	Sources      []chronymon.Source
+	Activity     *chronymon.Activity
+	RTC          *chronymon.RTC
+	ServerStats  *chrony.ServerStats2
+	Clients      []chronymon.Client
 	SatsByDevice map[string]map[float64]Satellite
 	PosByDevice  map[string]*PositionHistory
 }
@@ -73,17 +81,24 @@ func UpdateStatus(newStatus Status) {
 	if newStatus.ClockFace != nil {
 		status.ClockFace = newStatus.ClockFace
 	}
-	if newStatus.Tracking.Command != 0 {
-		status.Tracking = newStatus.Tracking
-	}
-	if len(newStatus.Sources) != 0 {
-		status.Sources = newStatus.Sources
-	}
 	if !newStatus.Now.IsZero() {
 		status.Now = newStatus.Now
 	}
 }
 
+// UpdateChrony replaces the tracking and sources shown on the status page.
+func UpdateChrony(s *chronymon.Snapshot) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	status.Now = s.Time
+	status.Tracking = s.Tracking
+	status.Sources = s.Sources
+	status.Activity = s.Activity
+	status.RTC = s.RTC
+	status.ServerStats = s.ServerStats
+	status.Clients = s.Clients
+}
+
 func AddSatellite(device string, s gpsd.Satellite) {
 	statusMu.Lock()
 	defer statusMu.Unlock()
@@ -198,7 +213,7 @@ func formatUnixTime(t time.Time) string { return t.In(time.UTC).Format(time.Unix
 func formatRefID(x uint32) string {
 	ip := make(net.IP, 4)
 	binary.BigEndian.PutUint32(ip, x)
-	return refID(ip)
+	return chronymon.SourceName(ip)
 }
 
 func formatDuration(x float64) string {
@@ -208,22 +223,6 @@ func formatDuration(x float64) string {
 
 func formatFloat3(x float64) string { return fmt.Sprintf("%.3f", x) }
 
-func formatLeap(x uint16) string {
-	// From chrony/client.c and chrony/ntp.h
-	switch x {
-	case 0:
-		return "Normal"
-	case 1:
-		return "Insert second"
-	case 2:
-		return "Delete second"
-	case 3:
-		return "Unsynchronized"
-	default:
-		return fmt.Sprintf("Invalid (%v)", x)
-	}
-}
-
 func formatCorrection(x float64) string {
 	var fast string
 	if x < 0 {
@@ -279,7 +278,7 @@ func formatSourceData(x chrony.SourceData) string {
 	case chrony.SourceStateOutlier:
 		state = "-"
 	}
-	name := refID(x.IPAddr)
+	name := chronymon.SourceName(x.IPAddr)
 	if len(name) > 27 {
 		name = name[:27]
 	}
@@ -290,6 +289,36 @@ func formatSourceStats(x chrony.SourceStats) string {
 	return fmt.Sprintf("%-27s %3d %3d  %13s %+10.3f %10.3f %13s %13s\n", intRefID(x.RefID), x.NSamples, x.NRuns, time.Duration(x.SpanSeconds)*1e9, x.ResidFreqPPM, x.SkewPPM, time.Duration(1e9*x.EstimatedOffset), time.Duration(1e9*x.StandardDeviation))
 }
 
+func formatNTPData(x chronymon.Source) string {
+	n := x.NTP
+	if n == nil {
+		return ""
+	}
+	name := chronymon.SourceName(x.Data.IPAddr)
+	if len(name) > 27 {
+		name = name[:27]
+	}
+	return fmt.Sprintf("%-27s %5d  %2d   %3d  %13s %13s %13s %13s %9d %9d %9d\n", name, n.RemotePort, n.Stratum, n.Poll, time.Duration(1e9*n.Offset), time.Duration(1e9*n.PeerDelay), time.Duration(1e9*n.PeerDispersion), time.Duration(1e9*n.ResponseTime), n.TotalTXCount, n.TotalRXCount, n.TotalValidCount)
+}
+
+// formatClient formats a client like "chronyc clients" does, with intervals as log2 seconds and
+// "-" for what chronyd doesn't know.
+func formatClient(c chronymon.Client) string {
+	interval := func(x int8) string {
+		if _, ok := chronymon.Interval(x); !ok {
+			return "-"
+		}
+		return strconv.Itoa(int(x))
+	}
+	last := func(d time.Duration) string {
+		if d < 0 {
+			return "-"
+		}
+		return d.String()
+	}
+	return fmt.Sprintf("%-27s %8d %6d %4s %4s %9s %8d %6d %4s %9s\n", c.IP, c.NTPHits, c.NTPDrops, interval(c.NTPInterval), interval(c.NTPTimeoutInterval), last(c.LastNTP), c.CmdHits, c.CmdDrops, interval(c.CmdInterval), last(c.LastCmd))
+}
+
 func ImageAsDataURL(bytes []byte) template.URL {
 	return template.URL("data:image/png;base64," + base64.RawStdEncoding.EncodeToString(bytes))
 }
@@ -7,25 +7,25 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/facebookincubator/ntp/protocol/chrony"
+	"github.com/jrockway/beaglebone-gps-clock/control/chronymon"
 	"github.com/jrockway/go-gpsd"
 )
 
 func TestTemplate(t *testing.T) {
 	now := time.Now()
-	UpdateStatus(Status{
-		ClockFace: getClockImage(now),
-		Now:       now,
-		Tracking: chrony.ReplyTracking{
-			Tracking: chrony.Tracking{
-				Stratum: 1,
-				RefID:   0x41414100,
-			},
+	UpdateStatus(Status{ClockFace: getClockImage(now)})
+	UpdateChrony(&chronymon.Snapshot{
+		Time: now,
+		Tracking: chrony.Tracking{
+			Stratum: 1,
+			RefID:   0x41414100,
 		},
-		Sources: []SourceInfo{
+		Sources: []chronymon.Source{
 			{
 				Index: 0,
 				Data: chrony.SourceData{
@@ -50,6 +50,37 @@ func TestTemplate(t *testing.T) {
 					EstimatedOffsetErr: 0.001,
 				},
 			},
+			{
+				Index: 1,
+				Data: chrony.SourceData{
+					IPAddr:       net.IPv4(192, 0, 2, 1),
+					Stratum:      2,
+					Poll:         6,
+					State:        chrony.SourceStateCandidate,
+					Mode:         chrony.SourceModeClient,
+					Reachability: 0o377,
+				},
+				Stats: chrony.SourceStats{
+					RefID:    0xc0000201,
+					IPAddr:   net.IPv4(192, 0, 2, 1),
+					NSamples: 8,
+				},
+				NTP: &chrony.NTPData{
+					RemoteAddr:   net.IPv4(192, 0, 2, 1),
+					RemotePort:   123,
+					Stratum:      1,
+					Offset:       -0.002,
+					PeerDelay:    0.015,
+					TotalTXCount: 100,
+					TotalRXCount: 99,
+				},
+			},
+		},
+		Activity:    &chronymon.Activity{Online: 2},
+		RTC:         &chronymon.RTC{RefTime: now, Samples: 5, Runs: 3, Span: time.Hour, SecondsFast: -0.25},
+		ServerStats: &chrony.ServerStats2{NTPHits: 1000},
+		Clients: []chronymon.Client{
+			{IP: net.IPv4(192, 0, 2, 10), NTPHits: 100, NTPInterval: 6, NTPTimeoutInterval: 127, LastNTP: 3 * time.Second, CmdInterval: 127, LastNKE: -1, LastCmd: -1},
 		},
 	})
 	AddSatellite("/dev/ttyS1", gpsd.Satellite{PRN: 1, Az: 0, El: 45, Ss: 20, Used: true})
@@ -73,6 +104,9 @@ func TestTemplate(t *testing.T) {
 	if got, want := rec.Code, http.StatusOK; got != want {
 		t.Errorf("render index.html: response code:\n  got: %v\n want: %v", got, want)
 	}
+	if got, want := rec.Body.String(), "192.0.2.10                       100      0    6    -        3s        0      0    -         -\n"; !strings.Contains(got, want) {
+		t.Errorf("render index.html: clients table is missing %q", want)
+	}
 	if os.Getenv("DUMP") != "" {
 		if err := ioutil.WriteFile("../index.html", rec.Body.Bytes(), 0o644); err != nil {
 			t.Fatal(err)